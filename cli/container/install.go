@@ -28,6 +28,7 @@ type InstallCommand struct {
 	CommandContext cli.Cli
 	ModuleVersion  string
 	File           string
+	Signature      string
 }
 
 type ImageResponse struct {
@@ -61,6 +62,7 @@ Example 2: Save an image (using an explicit platform) and install/create a conta
 
 	cmd.Flags().StringVar(&command.ModuleVersion, "module-version", "", "Software version to install")
 	cmd.Flags().StringVar(&command.File, "file", "", "File")
+	cmd.Flags().StringVar(&command.Signature, "signature", "", "Detached signature of the image file. Defaults to <file>.sig")
 	viper.SetDefault("container.alwaysPull", false)
 	command.Command = cmd
 	return cmd
@@ -83,6 +85,10 @@ func (c *InstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
 	if c.File != "" {
+		if err := c.CommandContext.VerifyImageFile(c.File, c.Signature, imageRef); err != nil {
+			return err
+		}
+
 		slog.Info("Loading image from file.", "file", c.File)
 		file, err := os.Open(c.File)
 		if err != nil {
@@ -152,12 +158,27 @@ func (c *InstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	// Check and pull image if it is not present
 	if !disablePull {
 		if _, err := cli.ImagePullWithRetries(ctx, imageRef, c.CommandContext.ImageAlwaysPull(), container.ImagePullOptions{
-			AuthFunc:    c.CommandContext.GetContainerRepositoryCredentialsFunc(imageRef),
-			MaxAttempts: 2,
-			Wait:        5 * time.Second,
+			AuthFunc:       c.CommandContext.GetContainerRepositoryCredentialsFunc(imageRef),
+			AuthFailedFunc: c.CommandContext.InvalidateRepositoryCredentialsFunc(imageRef),
+			MaxAttempts:    2,
+			Wait:           5 * time.Second,
 		}); err != nil {
 			return err
 		}
+
+		if err := c.CommandContext.VerifyImage(ctx, cli, imageRef); err != nil {
+			return err
+		}
+	}
+
+	//
+	// Record the image of the existing container so that it can be rolled back to
+	previousImage := ""
+	if prevContainer, err := cli.Client.ContainerInspect(ctx, containerName); err == nil && prevContainer.Config != nil {
+		previousImage = prevContainer.Config.Image
+		if container.ImageRefsEqual(previousImage, imageRef) {
+			previousImage = prevContainer.Config.Labels[container.LabelPreviousImage]
+		}
 	}
 
 	//
@@ -178,6 +199,9 @@ func (c *InstallCommand) RunE(cmd *cobra.Command, args []string) error {
 			container.LabelModuleVersion: c.ModuleVersion,
 		},
 	}
+	if previousImage != "" {
+		containerConfig.Labels[container.LabelPreviousImage] = previousImage
+	}
 
 	networkConfig := make(map[string]*network.EndpointSettings)
 	if commonNetwork != "" {
@@ -9,7 +9,6 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/thin-edge/tedge-container-plugin/pkg/cli"
-	"github.com/thin-edge/tedge-container-plugin/pkg/container"
 )
 
 type RemoveCommand struct {
@@ -35,12 +34,12 @@ Example 1: Remove a container
 			ctx := context.Background()
 			containerName := args[0]
 
-			cli, err := container.NewContainerClient(ctx, cliContext.GetContainerClientOptions()...)
+			containerCli, err := cliContext.GetContainerClientFor(ctx, cli.HasContainer(containerName))
 			if err != nil {
 				return err
 			}
 
-			return cli.StopRemoveContainer(ctx, containerName)
+			return containerCli.StopRemoveContainer(ctx, containerName)
 		},
 	}
 	cmd.Flags().StringVar(&command.ModuleVersion, "module-version", "", "Software version to remove")
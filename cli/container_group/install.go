@@ -112,15 +112,31 @@ func (c *InstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	}
 	for _, imageRef := range images {
 		if _, err := cli.ImagePullWithRetries(ctx, imageRef, c.CommandContext.ImageAlwaysPull(), container.ImagePullOptions{
-			AuthFunc:    c.CommandContext.GetContainerRepositoryCredentialsFunc(imageRef),
-			MaxAttempts: 2,
-			Wait:        5 * time.Second,
+			AuthFunc:       c.CommandContext.GetContainerRepositoryCredentialsFunc(imageRef),
+			AuthFailedFunc: c.CommandContext.InvalidateRepositoryCredentialsFunc(imageRef),
+			MaxAttempts:    2,
+			Wait:           5 * time.Second,
 		}); err != nil {
 			// Proceed anyway so docker-compose can potentially pull in the images
 			slog.Warn("Error whilst pulling images. Trying to proceed anyway.", "err", err)
 		}
 	}
 
+	// Verify the images before any containers are started
+	for _, imageRef := range images {
+		if err := c.CommandContext.VerifyImage(ctx, cli, imageRef); err != nil {
+			return err
+		}
+	}
+
+	// Compose must start the verified images, so it must not build or pull the images again
+	if c.CommandContext.ImageVerificationEnforced(images) {
+		if err := container.CheckPullPolicies(ctx, []string{composeFile}); err != nil {
+			return err
+		}
+		composeUpExtraArgs = []string{"--pull=never"}
+	}
+
 	// Create shared network
 	if err := cli.CreateSharedNetwork(ctx, c.CommandContext.GetSharedContainerNetwork()); err != nil {
 		return err
@@ -20,6 +20,7 @@ type InstallCommand struct {
 	CommandContext cli.Cli
 	ModuleVersion  string
 	File           string
+	Signature      string
 }
 
 // installCmd represents the install command
@@ -47,6 +48,7 @@ Example 2: Install an image from an archive created via 'docker save'
 
 	cmd.Flags().StringVar(&command.ModuleVersion, "module-version", "latest", "Software version to install")
 	cmd.Flags().StringVar(&command.File, "file", "", "File")
+	cmd.Flags().StringVar(&command.Signature, "signature", "", "Detached signature of the image file. Defaults to <file>.sig")
 	viper.SetDefault("container.alwaysPull", false)
 	command.Command = cmd
 	return cmd
@@ -68,6 +70,9 @@ func (c *InstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
 	if c.File != "" {
+		if err := c.CommandContext.VerifyImageFile(c.File, c.Signature, imageRef); err != nil {
+			return err
+		}
 		loadedRef, err := cli.LoadImageFromFile(ctx, c.File, imageRef)
 		if err != nil {
 			return err
@@ -79,12 +84,17 @@ func (c *InstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	// Check and pull image if it is not present
 	if !disablePull {
 		if _, err := cli.ImagePullWithRetries(ctx, imageRef, c.CommandContext.ImageAlwaysPull(), container.ImagePullOptions{
-			AuthFunc:    c.CommandContext.GetContainerRepositoryCredentialsFunc(imageRef),
-			MaxAttempts: 2,
-			Wait:        5 * time.Second,
+			AuthFunc:       c.CommandContext.GetContainerRepositoryCredentialsFunc(imageRef),
+			AuthFailedFunc: c.CommandContext.InvalidateRepositoryCredentialsFunc(imageRef),
+			MaxAttempts:    2,
+			Wait:           5 * time.Second,
 		}); err != nil {
 			return err
 		}
+
+		if err := c.CommandContext.VerifyImage(ctx, cli, imageRef); err != nil {
+			return err
+		}
 	}
 
 	slog.Info("Installed image.", "name", imageName, "version", c.ModuleVersion, "imageRef", imageRef)
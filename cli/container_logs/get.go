@@ -43,7 +43,7 @@ func NewGetCommand(ctx cli.Cli) *cobra.Command {
 func (c *ContainerLogsCommand) RunE(cmd *cobra.Command, args []string) error {
 	slog.Info("Executing", "cmd", cmd.CalledAs(), "args", args)
 
-	containerCli, err := container.NewContainerClient(context.TODO(), c.CommandContext.GetContainerClientOptions()...)
+	containerCli, err := c.CommandContext.GetContainerClientFor(context.TODO(), cli.HasContainer(args[0]))
 	if err != nil {
 		return err
 	}
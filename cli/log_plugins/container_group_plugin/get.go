@@ -44,11 +44,6 @@ func NewGetCommand(ctx cli.Cli) *cobra.Command {
 func (c *ContainerLogsCommand) RunE(cmd *cobra.Command, args []string) error {
 	slog.Info("Executing", "cmd", cmd.CalledAs(), "args", args)
 
-	containerCli, err := container.NewContainerClient(context.TODO(), c.CommandContext.GetContainerClientOptions()...)
-	if err != nil {
-		return err
-	}
-
 	ctx := context.Background()
 
 	// lookup
@@ -57,6 +52,19 @@ func (c *ContainerLogsCommand) RunE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid service name. expected name in format of '<project>@<service>'")
 	}
 
+	// Use the engine running the project (if multiple engines are configured)
+	containerCli, err := c.CommandContext.GetContainerClientFor(ctx, func(ctx context.Context, client *container.ContainerClient) bool {
+		resolved, err := client.ResolveComposeProjectName(ctx, projectName)
+		if err != nil {
+			resolved = projectName
+		}
+		services, err := client.LookupProject(ctx, resolved, serviceName)
+		return err == nil && len(services) > 0
+	})
+	if err != nil {
+		return err
+	}
+
 	// The stored service name may use the module name rather than the Docker
 	// compose project name. Resolve it to the actual Docker project label so
 	// the container lookup works regardless.
@@ -22,13 +22,17 @@ func NewListCommand(cliContext cli.Cli) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			slog.Info("Executing", "cmd", cmd.CalledAs(), "args", args)
 			ctx := context.Background()
-			cli, err := container.NewContainerClient(ctx, cliContext.GetContainerClientOptions()...)
+			clients, err := cliContext.GetContainerClients(ctx)
 			if err != nil {
 				return err
 			}
-			containers, err := cli.List(ctx, cliContext.GetFilterOptions())
-			if err != nil {
-				return err
+			containers := make([]container.TedgeContainer, 0)
+			for _, client := range clients {
+				items, err := client.List(ctx, cliContext.GetFilterOptions())
+				if err != nil {
+					return err
+				}
+				containers = append(containers, items...)
 			}
 			stdout := cmd.OutOrStdout()
 			useModuleName := cliContext.UseModuleNameForService()
@@ -45,17 +45,60 @@ func NewRunCommand(cliContext cli.Cli) *cobra.Command {
 
 			device := cliContext.GetDeviceTarget()
 			application, err := app.NewApp(device, app.Config{
-				ContainerHost:           cliContext.GetContainerHost(),
-				ServiceName:             cliContext.GetServiceName(),
-				RunOnce:                 command.RunOnce,
-				EnableMetrics:           cliContext.MetricsEnabled(),
-				DeleteFromCloud:         cliContext.DeleteFromCloud(),
-				DeleteOrphans:           cliContext.DeleteOrphans(),
-				OrphansCheckInterval:    cliContext.GetOrphansCheckInterval(),
-				EnableEngineEvents:      cliContext.EngineEventsEnabled(),
-				CrashLoopThreshold:      cliContext.GetCrashLoopThreshold(),
-				UseModuleNameForService: cliContext.UseModuleNameForService(),
-				SyncRetryInterval:       cliContext.GetSyncRetryInterval(),
+				ContainerHosts:         cliContext.GetContainerHosts(),
+				ServiceName:            cliContext.GetServiceName(),
+				PluginVersion:          cliContext.Version,
+				PluginBranch:           cliContext.Branch,
+				PluginSettings:         cliContext.GetEffectiveSettings(),
+				RunOnce:                command.RunOnce,
+				EnableMetrics:          cliContext.MetricsEnabled(),
+				DeleteFromCloud:        cliContext.DeleteFromCloud(),
+				DeleteOrphans:          cliContext.DeleteOrphans(),
+				OrphansCheckInterval:   cliContext.GetOrphansCheckInterval(),
+				EnableEngineEvents:     cliContext.EngineEventsEnabled(),
+				EventTypes:             cliContext.GetEventTypes(),
+				EventActions:           cliContext.GetEventActions(),
+				EventIncludeLabels:     cliContext.GetEventIncludeLabels(),
+				EventExcludeLabels:     cliContext.GetEventExcludeLabels(),
+				EventTypeNames:         cliContext.GetEventTypeNames(),
+				TwinOptions:            cliContext.GetTwinOptions(),
+				PublishRefreshInterval: cliContext.GetPublishRefreshInterval(),
+				EngineDataRoot:         cliContext.GetEngineDataRoot(),
+				EngineDiskUsageThresholds: app.DiskUsageThresholds{
+					Critical: cliContext.GetEngineDiskUsageThreshold("critical"),
+					Major:    cliContext.GetEngineDiskUsageThreshold("major"),
+					Minor:    cliContext.GetEngineDiskUsageThreshold("minor"),
+				},
+
+				EngineUnavailableAfter:         cliContext.GetEngineUnavailableAfter(),
+				EngineUnavailableServiceStatus: cliContext.GetEngineUnavailableServiceStatus(),
+
+				EnableContainerOperations:    cliContext.ContainerOperationsEnabled(),
+				EnableContainerExec:          cliContext.ContainerExecEnabled(),
+				ContainerExecTimeout:         cliContext.GetContainerExecTimeout(),
+				ContainerExecMaxOutput:       cliContext.GetContainerExecMaxOutput(),
+				ContainerExecAllowedCommands: cliContext.GetContainerExecAllowedCommands(),
+				DiagnosticsLogLines:          cliContext.GetDiagnosticsLogLines(),
+				ComposeDir:                   cliContext.GetComposeDir(),
+				CrashLoopThreshold:           cliContext.GetCrashLoopThreshold(),
+				CrashLoopAction:              cliContext.GetCrashLoopAction(),
+				OOMClearAfter:                cliContext.GetOOMClearAfter(),
+				UnhealthyAlarmAfter:          cliContext.GetUnhealthyAlarmAfter(),
+				EnableExitAlarm:              cliContext.ExitAlarmEnabled(),
+				ExitAlarmSeverity:            cliContext.GetExitAlarmSeverity(),
+				UseModuleNameForService:      cliContext.UseModuleNameForService(),
+				ContainerGroupMode:           cliContext.GetContainerGroupMode(),
+				SyncRetryInterval:            cliContext.GetSyncRetryInterval(),
+				StateFile:                    cliContext.GetMonitorStateFile(),
+
+				RegistryCredentialsConfigType: cliContext.GetCloudCredentialsConfigType(),
+				RegistryCredentialsPath:       cliContext.GetCloudCredentialsPath(),
+				ImportRegistryCredentials:     cliContext.ImportCloudRegistryCredentials,
+
+				ImageAutoUpdateWindow:       cliContext.GetImageAutoUpdateWindow(),
+				ImageAutoUpdateHealthyAfter: cliContext.GetImageAutoUpdateHealthyAfter(),
+				RegistryAuthFunc:            cliContext.GetContainerRepositoryCredentialsFunc,
+				VerifyImage:                 cliContext.VerifyImage,
 
 				HTTPHost:       cliContext.GetHTTPHost(),
 				HTTPPort:       cliContext.GetHTTPPort(),
@@ -75,6 +118,10 @@ func NewRunCommand(cliContext cli.Cli) *cobra.Command {
 			// FIXME: Wait until the entity store has been filled
 			time.Sleep(200 * time.Millisecond)
 
+			if err := application.PublishPluginInfo(false); err != nil {
+				slog.Warn("Could not publish the plugin information.", "err", err)
+			}
+
 			if command.RunOnce {
 				// Cleanly stop the application in run-once mode
 				// so that the service still appears to be "up" as the Last Will and Testament
@@ -121,6 +168,12 @@ func NewRunCommand(cliContext cli.Cli) *cobra.Command {
 				}()
 			}
 
+			if cliContext.EngineUsageEnabled() {
+				go func() {
+					_ = backgroundEngineUsage(ctx, application, cliContext.GetEngineUsageInterval())
+				}()
+			}
+
 			// Start the background reconcile loop. It periodically triggers a
 			// full update so that pending cloud deletions are retried and
 			// stale/orphaned services are cleaned up even when no container
@@ -134,6 +187,31 @@ func NewRunCommand(cliContext cli.Cli) *cobra.Command {
 				}()
 			}
 
+			// Local status API
+			if cliContext.StatusAPIEnabled() {
+				go func() {
+					if err := application.ServeStatusAPI(ctx, cliContext.GetStatusAPIAddress(), cliContext.GetFilterOptions()); err != nil {
+						slog.Error("Status API stopped.", "err", err)
+					}
+				}()
+			}
+
+			// Prometheus/OpenMetrics exporter
+			if cliContext.PrometheusEnabled() {
+				go func() {
+					if err := application.ServeMetrics(ctx, cliContext.GetPrometheusAddress(), cliContext.GetFilterOptions()); err != nil {
+						slog.Error("Metrics exporter stopped.", "err", err)
+					}
+				}()
+			}
+
+			// Periodically check the registries for newer images
+			if interval := cliContext.GetImageUpdatesInterval(); interval > 0 {
+				go func() {
+					_ = backgroundImageUpdates(ctx, cliContext, application, interval)
+				}()
+			}
+
 			<-stop
 			cancel()
 			application.Stop(false)
@@ -185,14 +263,57 @@ func NewRunCommand(cliContext cli.Cli) *cobra.Command {
 	// Reconcile loop (safety net to retry pending cloud deletions and clean up
 	// stale services independently of events). "0" disables it.
 	viper.SetDefault("reconcile.interval", "30m")
+	viper.SetDefault("reconcile.refresh_interval", "6h")
 
 	// Failure-driven retry: how long to wait before retrying a cloud sync
 	// after a failure (e.g. a cloud service deletion failed because the local
 	// Cumulocity proxy was unavailable). "0" disables it.
 	viper.SetDefault("reconcile.retry_interval", "30s")
 
+	// Image update checks. Containers with the tedge.autoupdate=digest label are
+	// updated automatically within the window ("HH:MM-HH:MM", empty means any time)
+	viper.SetDefault("image_updates.enabled", false)
+	viper.SetDefault("image_updates.interval", "1h")
+	viper.SetDefault("image_updates.auto_update.window", "")
+	viper.SetDefault("image_updates.auto_update.healthy_after", "60s")
+
+	// Local status API, served on a unix socket ("unix:///path") or a tcp address
+	viper.SetDefault("status_api.enabled", false)
+	viper.SetDefault("status_api.address", "127.0.0.1:8091")
+
+	// Prometheus/OpenMetrics exporter (GET /metrics)
+	viper.SetDefault("prometheus.enabled", false)
+	viper.SetDefault("prometheus.address", "127.0.0.1:9464")
+
+	// Optional container twin details
+	viper.SetDefault("twin.fields", []string{})
+	viper.SetDefault("twin.labels", []string{})
+	viper.SetDefault("twin.env_redact_patterns", []string{})
+
+	// Engine-wide usage measurements and disk usage alarm
+	viper.SetDefault("engine_usage.enabled", false)
+	viper.SetDefault("engine_usage.interval", "15m")
+	viper.SetDefault("engine_usage.data_root", "")
+	viper.SetDefault("engine_usage.alarm.critical", 95)
+	viper.SetDefault("engine_usage.alarm.major", 90)
+	viper.SetDefault("engine_usage.alarm.minor", 80)
+
+	// Engine unavailable alarm ("0" disables it), and the health status of the services
+	// of an unavailable engine ("", "unknown" or "down")
+	viper.SetDefault("engine_availability.alarm_after", "60s")
+	viper.SetDefault("engine_availability.service_status", "")
+
 	// Feature flags
 	viper.SetDefault("events.enabled", true)
+	viper.SetDefault("events.types", []string{"container"})
+	viper.SetDefault("events.include_labels", []string{})
+	viper.SetDefault("events.exclude_labels", []string{})
+	viper.SetDefault("operations.enabled", true)
+	viper.SetDefault("operations.exec.enabled", false)
+	viper.SetDefault("operations.exec.timeout", "30s")
+	viper.SetDefault("operations.exec.max_output", 1024*1024)
+	viper.SetDefault("operations.exec.allowed_commands", []string{})
+	viper.SetDefault("operations.diagnostics.log_lines", 1000)
 	viper.SetDefault("delete_from_cloud.enabled", true)
 	viper.SetDefault("delete_from_cloud.orphans", true)
 	// Minimum time between routine checks for orphaned cloud services, to
@@ -203,6 +324,11 @@ func NewRunCommand(cliContext cli.Cli) *cobra.Command {
 
 	// Crash loop detection
 	viper.SetDefault("container.crash_loop_threshold", 5)
+	viper.SetDefault("container.crash_loop_action", app.CrashLoopActionNone)
+	viper.SetDefault("container.oom_clear_after", "5m")
+	viper.SetDefault("container.unhealthy_alarm_after", "5m")
+	viper.SetDefault("container.exit_alarm", true)
+	viper.SetDefault("container.exit_alarm_severity", "major")
 
 	// thin-edge.io services
 	viper.SetDefault("client.http.host", "127.0.0.1")
@@ -248,6 +374,44 @@ func backgroundReconcile(ctx context.Context, cliContext cli.Cli, application *a
 	}
 }
 
+// backgroundImageUpdates periodically checks if newer images are available in the
+// registries for the managed containers, and updates the containers which opted in
+func backgroundImageUpdates(ctx context.Context, cliContext cli.Cli, application *app.App, interval time.Duration) error {
+	slog.Info("Starting background image update task.", "interval", interval)
+	timerCh := time.NewTicker(interval)
+	defer timerCh.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping image update task")
+			return ctx.Err()
+
+		case <-timerCh.C:
+			if err := application.CheckImageUpdates(ctx, cliContext.GetFilterOptions()); err != nil {
+				slog.Warn("Error checking for image updates.", "err", err)
+			}
+		}
+	}
+}
+
+func backgroundEngineUsage(ctx context.Context, application *app.App, interval time.Duration) error {
+	slog.Info("Starting background engine usage task.", "interval", interval)
+	timerCh := time.NewTicker(interval)
+	defer timerCh.Stop()
+	for {
+		// Publish on startup so that a full disk is reported without waiting for the first interval
+		if err := application.UpdateEngineUsage(ctx); err != nil {
+			slog.Warn("Error updating engine usage.", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping engine usage task")
+			return ctx.Err()
+		case <-timerCh.C:
+		}
+	}
+}
+
 func backgroundMetric(ctx context.Context, cliContext cli.Cli, application *app.App, interval time.Duration) error {
 	timerCh := time.NewTicker(interval)
 	for {
@@ -19,6 +19,7 @@ func NewToolsCommand(cmdCli cli.Cli) *cobra.Command {
 		NewContainerRunInContextCommand(cmdCli),
 		NewContainerRemoveCommand(cmdCli),
 		NewContainerRestartCommand(cmdCli),
+		NewDiagnosticsCommand(cmdCli),
 	)
 	return cmd
 }
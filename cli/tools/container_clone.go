@@ -133,12 +133,18 @@ func (c *ContainerCloneCommand) RunE(cmd *cobra.Command, args []string) error {
 	} else {
 		// Pull potentially new image
 		if _, err := containerCli.ImagePullWithRetries(ctx, c.Image, c.CommandContext.ImageAlwaysPull(), container.ImagePullOptions{
-			AuthFunc:    c.CommandContext.GetContainerRepositoryCredentialsFunc(c.Image),
-			MaxAttempts: 2,
-			Wait:        5 * time.Second,
+			AuthFunc:       c.CommandContext.GetContainerRepositoryCredentialsFunc(c.Image),
+			AuthFailedFunc: c.CommandContext.InvalidateRepositoryCredentialsFunc(c.Image),
+			MaxAttempts:    2,
+			Wait:           5 * time.Second,
 		}); err != nil {
 			return err
 		}
+
+		// Images installed from an archive (--no-pull) are verified when they are loaded
+		if err := c.CommandContext.VerifyImage(ctx, containerCli, c.Image); err != nil {
+			return err
+		}
 	}
 
 	if c.CheckForUpdate {
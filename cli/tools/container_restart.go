@@ -10,7 +10,6 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/thin-edge/tedge-container-plugin/pkg/cli"
-	"github.com/thin-edge/tedge-container-plugin/pkg/container"
 )
 
 type ContainerRestartCommand struct {
@@ -40,24 +39,29 @@ func NewContainerRestartCommand(ctx cli.Cli) *cobra.Command {
 func (c *ContainerRestartCommand) RunE(cmd *cobra.Command, args []string) error {
 	slog.Debug("Executing", "cmd", cmd.CalledAs(), "args", args)
 
-	containerCli, err := container.NewContainerClient(context.TODO(), c.CommandContext.GetContainerClientOptions()...)
-	if err != nil {
-		return err
-	}
-
 	ctx := context.Background()
 
 	if len(args) == 0 {
 		slog.Info("Restarting current container")
+		containerCli, err := c.CommandContext.GetContainerClientFor(ctx, cli.IsSelf)
+		if err != nil {
+			return err
+		}
 		con, err := containerCli.Self(ctx)
 		if err != nil {
 			return err
 		}
-		args = append(args, con.ID)
+		return containerCli.RestartContainer(ctx, con.ID)
 	}
 
 	errs := make([]error, 0)
 	for _, con := range args {
+		// Use the engine running the container (if multiple engines are configured)
+		containerCli, err := c.CommandContext.GetContainerClientFor(ctx, cli.HasContainer(con))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
 		errs = append(errs, containerCli.RestartContainer(ctx, con))
 	}
 	return errors.Join(errs...)
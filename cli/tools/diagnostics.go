@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 thin-edge.io <info@thin-edge.io>
+*/
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thin-edge/tedge-container-plugin/pkg/cli"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+type DiagnosticsCommand struct {
+	*cobra.Command
+
+	CommandContext cli.Cli
+
+	// Options
+	Output   string
+	LogLines int
+}
+
+// NewDiagnosticsCommand creates a new diagnostics command
+func NewDiagnosticsCommand(ctx cli.Cli) *cobra.Command {
+	command := &DiagnosticsCommand{
+		CommandContext: ctx,
+	}
+	cmd := &cobra.Command{
+		Use:   "diagnostics [OPTIONS] NAME",
+		Short: "Collect diagnostics of a container or container-group",
+		Long: `Collect the diagnostics (inspect output, logs, resource usage, compose files and engine information)
+of a container or container-group into a gzip compressed tarball. Secrets are redacted from the environment variables.
+
+The NAME is either a container name, a container-group service (<project>@<service>), or a container-group (<project>)`,
+		Example: `
+	$ tedge-container tools diagnostics nodered --output nodered.tar.gz
+	$ tedge-container tools diagnostics myproject > myproject.tar.gz
+		`,
+		RunE:         command.RunE,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVarP(&command.Output, "output", "o", "", "Output file. Defaults to stdout")
+	cmd.Flags().IntVarP(&command.LogLines, "log-lines", "n", 1000, "Number of log lines to include for each container")
+	command.Command = cmd
+	return cmd
+}
+
+func (c *DiagnosticsCommand) RunE(cmd *cobra.Command, args []string) error {
+	slog.Debug("Executing", "cmd", cmd.CalledAs(), "args", args)
+	ctx := context.Background()
+	name := args[0]
+
+	var containerIDs []string
+	// Use the engine running the container(s) (if multiple engines are configured)
+	containerCli, err := c.CommandContext.GetContainerClientFor(ctx, func(ctx context.Context, client *container.ContainerClient) bool {
+		ids, err := client.ResolveContainers(ctx, name)
+		if err == nil && len(ids) > 0 {
+			containerIDs = ids
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if len(containerIDs) == 0 {
+		if containerIDs, err = containerCli.ResolveContainers(ctx, name); err != nil {
+			return err
+		}
+	}
+	if len(containerIDs) == 0 {
+		return fmt.Errorf("no containers found. name=%s", name)
+	}
+
+	var out io.Writer = cmd.OutOrStdout()
+	if c.Output != "" {
+		file, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	slog.Info("Collecting diagnostics.", "name", name, "containers", len(containerIDs))
+	return containerCli.WriteDiagnostics(ctx, out, containerIDs, container.DiagnosticsOptions{
+		LogLines:   c.LogLines,
+		ComposeDir: c.CommandContext.GetComposeDir(),
+	})
+}
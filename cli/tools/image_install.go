@@ -19,8 +19,9 @@ type ImageInstallCommand struct {
 	CommandContext cli.Cli
 
 	// Options
-	Image string
-	File  string
+	Image     string
+	File      string
+	Signature string
 }
 
 // NewImageInstallCommand creates a new image-install command
@@ -47,6 +48,7 @@ Example 2: Load an image from an archive created via 'docker save'
 	}
 	cmd.Flags().StringVar(&command.Image, "image", "", "Container image reference")
 	cmd.Flags().StringVar(&command.File, "file", "", "Image archive to load the image from. The image is pulled from a registry if not given")
+	cmd.Flags().StringVar(&command.Signature, "signature", "", "Detached signature of the image archive. Defaults to <file>.sig")
 	_ = cmd.MarkFlagRequired("image")
 
 	command.Command = cmd
@@ -64,6 +66,9 @@ func (c *ImageInstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
 	if c.File != "" {
+		if err := c.CommandContext.VerifyImageFile(c.File, c.Signature, c.Image); err != nil {
+			return err
+		}
 		imageRef, err := containerCli.LoadImageFromFile(ctx, c.File, c.Image)
 		if err != nil {
 			return err
@@ -73,12 +78,16 @@ func (c *ImageInstallCommand) RunE(cmd *cobra.Command, args []string) error {
 	}
 
 	if _, err := containerCli.ImagePullWithRetries(ctx, c.Image, c.CommandContext.ImageAlwaysPull(), container.ImagePullOptions{
-		AuthFunc:    c.CommandContext.GetContainerRepositoryCredentialsFunc(c.Image),
-		MaxAttempts: 2,
-		Wait:        5 * time.Second,
+		AuthFunc:       c.CommandContext.GetContainerRepositoryCredentialsFunc(c.Image),
+		AuthFailedFunc: c.CommandContext.InvalidateRepositoryCredentialsFunc(c.Image),
+		MaxAttempts:    2,
+		Wait:           5 * time.Second,
 	}); err != nil {
 		return err
 	}
+	if err := c.CommandContext.VerifyImage(ctx, containerCli, c.Image); err != nil {
+		return err
+	}
 	slog.Info("Installed image.", "image", c.Image)
 	return nil
 }
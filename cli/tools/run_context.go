@@ -96,9 +96,10 @@ func (c *ContainerRunInContextCommand) RunE(cmd *cobra.Command, args []string) e
 
 	// Pull potentially new image
 	if _, err := containerCli.ImagePullWithRetries(ctx, c.Image, c.CommandContext.ImageAlwaysPull(), container.ImagePullOptions{
-		AuthFunc:    c.CommandContext.GetContainerRepositoryCredentialsFunc(c.Image),
-		MaxAttempts: 2,
-		Wait:        5 * time.Second,
+		AuthFunc:       c.CommandContext.GetContainerRepositoryCredentialsFunc(c.Image),
+		AuthFailedFunc: c.CommandContext.InvalidateRepositoryCredentialsFunc(c.Image),
+		MaxAttempts:    2,
+		Wait:           5 * time.Second,
 	}); err != nil {
 		slog.Warn("Failed to pull image.", "err", err)
 		return err
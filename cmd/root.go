@@ -85,7 +85,10 @@ func SetLogLevel() error {
 }
 
 func init() {
-	cliConfig := cli.Cli{}
+	cliConfig := cli.Cli{
+		Version: buildVersion,
+		Branch:  buildBranch,
+	}
 	cobra.OnInitialize(cliConfig.OnInit)
 	rootCmd.AddCommand(
 		container.NewContainerCommand(cliConfig),
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"strings"
 	"sync"
@@ -18,6 +17,7 @@ import (
 	"github.com/thin-edge/tedge-container-plugin/pkg/container"
 	"github.com/thin-edge/tedge-container-plugin/pkg/random"
 	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+	"github.com/thin-edge/tedge-container-plugin/pkg/utils"
 )
 
 type Action int
@@ -25,6 +25,7 @@ type Action int
 const (
 	ActionUpdateAll Action = iota
 	ActionUpdateMetrics
+	ActionRemoveService
 )
 
 type ActionRequest struct {
@@ -51,10 +52,11 @@ func NewUpdateMetricsAction(filter container.FilterOptions) ActionRequest {
 
 type App struct {
 	client *tedge.Client
-	// containerClient is stored atomically so that ReconnectContainerClient can
-	// swap it without a data race against concurrent readers (worker, metrics).
-	containerClient     atomic.Pointer[container.ContainerClient]
-	containerClientOpts []container.Opt
+	// engines are the monitored container engines. The first one is the primary engine
+	engines []*containerEngine
+	// containerEngines maps container id → engine (only used when monitoring multiple engines)
+	containerEngines   map[string]*containerEngine
+	containerEnginesMu sync.Mutex
 
 	Device *tedge.Target
 
@@ -71,9 +73,45 @@ type App struct {
 	restartBaselineMu sync.Mutex
 	crashLoopAlarms   map[string]struct{}
 	crashLoopAlarmsMu sync.Mutex
+	// retainedCrashLoopAlarms are the services with an active (retained) crash
+	// loop alarm on the broker, used to reconcile the alarms after a restart
+	retainedCrashLoopAlarms   map[string]struct{}
+	retainedCrashLoopAlarmsMu sync.Mutex
+	// crashLoopStateReconciled is set once the restored state has been compared
+	// with the containers. Only accessed from the worker goroutine (doUpdate).
+	crashLoopStateReconciled bool
+	stateSaveScheduled       atomic.Bool
+	stateFileMu              sync.Mutex
+	// oomAlarms maps service name → the pending clearing of its active OOM alarm (nil if
+	// the container has not been restarted yet)
+	oomAlarms        map[string]*time.Timer
+	oomAlarmRaisedAt map[string]time.Time
+	oomAlarmsMu      sync.Mutex
+	// lastMemoryUsage maps service name → the last resource usage sample, used in the OOM alarm
+	lastMemoryUsage   map[string]container.StatsEntry
+	lastMemoryUsageMu sync.Mutex
+	// unhealthyAlarms maps service name → the pending unhealthy alarm (nil once the alarm was raised)
+	unhealthyAlarms   map[string]*time.Timer
+	unhealthyAlarmsMu sync.Mutex
+	// exitAlarms are the services with an active (unexpected) exit alarm
+	exitAlarms   map[string]struct{}
+	exitAlarmsMu sync.Mutex
+	// exitRequests are the containers which were requested to stop
+	exitRequests exitRequests
+	// declaredOperations are the services which declared the container operations
+	declaredOperations declaredOperations
 	// eventLimiter rate-limits per-(container, event-type) MQTT publishes so
 	// that a crash-looping container cannot flood the broker.
 	eventLimiter *EventRateLimiter
+	// publishCache records the published twin and health messages, so that unchanged
+	// messages are not republished on each update
+	publishCache *publishCache
+	// engineDiskAlarm is the state of the engine's disk usage alarm
+	engineDiskAlarm engineDiskAlarm
+	// engineAvailability is the state of the engine unavailable alarm
+	engineAvailability engineAvailability
+	// eventSelector selects which engine events are published
+	eventSelector *EventSelector
 	// syncRetryScheduled guards against scheduling more than one concurrent
 	// failure-driven cloud sync retry.
 	syncRetryScheduled atomic.Bool
@@ -81,14 +119,41 @@ type App struct {
 	// completed successfully, used to enforce OrphansCheckInterval. Only
 	// accessed from the worker goroutine (doUpdate), so it needs no locking.
 	lastOrphansCheck time.Time
-	wg               sync.WaitGroup
+	// imageUpdates maps service name → the last published image update
+	// information, so that unchanged information is not republished.
+	imageUpdates        map[string]ImageUpdateInfo
+	imageUpdatesMu      sync.Mutex
+	imageUpdatesRunning atomic.Bool
+	// syncState records the outcome of the last full update (see Status)
+	syncState   SyncState
+	syncStateMu sync.Mutex
+	// containerIndex maps container id → service name. It is built by doUpdate
+	// (from the entity store and the container list) so that a removed container's
+	// service can be deregistered without a full update.
+	containerIndex   map[string]string
+	containerIndexMu sync.Mutex
+	// statsSamples maps container id → the last resource usage collected by
+	// updateMetrics, which is served by the metrics exporter
+	statsSamples   map[string]container.StatsEntry
+	statsSamplesMu sync.Mutex
+	// metrics are plugin internal counters exposed by the metrics exporter
+	metrics pluginMetrics
+	wg      sync.WaitGroup
 }
 
 type Config struct {
-	ContainerHost string
+	// ContainerHosts are the addresses of the container engines to monitor. The engine
+	// is auto detected if empty
+	ContainerHosts []string
 
 	ServiceName string
 
+	// PluginVersion and PluginBranch are the build information, and PluginSettings
+	// the effective (non-secret) settings, which are published as twin information
+	PluginVersion  string
+	PluginBranch   string
+	PluginSettings map[string]any
+
 	// TLS
 	KeyFile  string
 	CertFile string
@@ -97,9 +162,60 @@ type Config struct {
 	// Feature flags
 	EnableMetrics      bool
 	EnableEngineEvents bool
-	DeleteFromCloud    bool
-	DeleteOrphans      bool
-	RunOnce            bool
+	// EventTypes are the engine event types which are published, e.g. container, image, network and volume
+	EventTypes []string
+	// EventActions are the actions which are published per event type. The default actions are used
+	// for the event types which are not included
+	EventActions map[string][]string
+	// EventIncludeLabels only publishes the events with one of the labels ("key" or "key=value")
+	EventIncludeLabels []string
+	// EventExcludeLabels does not publish the events with any of the labels ("key" or "key=value")
+	EventExcludeLabels []string
+	// EventTypeNames are the thin-edge event types per engine event type, e.g. "image_{action}"
+	EventTypeNames map[string]string
+
+	// EngineDataRoot is the path used to read the free space of the engine's data root. The data root
+	// reported by the engine is used if empty
+	EngineDataRoot string
+	// EngineDiskUsageThresholds are the used space (in percent) of the engine's data root at which
+	// the disk usage alarm is raised
+	EngineDiskUsageThresholds DiskUsageThresholds
+
+	// EngineUnavailableAfter is how long a container engine can't be reached before the engine
+	// unavailable alarm is raised and the plugin's health status is set to down. 0 disables it
+	EngineUnavailableAfter time.Duration
+	// EngineUnavailableServiceStatus is the health status (unknown or down) which the services of
+	// an unavailable engine are set to. The health status is not changed if empty
+	EngineUnavailableServiceStatus string
+
+	// PublishRefreshInterval is the maximum time between publishing unchanged twin and health
+	// messages. The messages are published on every update if it is 0
+	PublishRefreshInterval time.Duration
+
+	// TwinOptions are the optional container details which are included in the container twin
+	TwinOptions container.TwinOptions
+	// EnableContainerOperations declares and handles the container operations (e.g. container_restart)
+	// on the container services
+	EnableContainerOperations bool
+
+	// EnableContainerExec declares and handles the container_exec operation, which runs a command
+	// inside a container. The output is limited to ContainerExecMaxOutput bytes, and only the
+	// ContainerExecAllowedCommands can be run (if not empty)
+	EnableContainerExec          bool
+	ContainerExecTimeout         time.Duration
+	ContainerExecMaxOutput       int
+	ContainerExecAllowedCommands []string
+
+	// DiagnosticsLogLines is the number of log lines included for each container in the
+	// container_diagnostics bundle
+	DiagnosticsLogLines int
+	DeleteFromCloud     bool
+	DeleteOrphans       bool
+	RunOnce             bool
+
+	// ComposeDir is the directory of the container-groups deployed by the plugin. Only their compose
+	// files are included in the diagnostics bundle
+	ComposeDir string
 
 	HTTPHost string
 	HTTPPort uint16
@@ -114,6 +230,23 @@ type Config struct {
 	// last healthy state required to declare a crash loop.
 	CrashLoopThreshold int
 
+	// CrashLoopAction is the action taken when a crash loop is declared, see CrashLoopActionNone etc.
+	// It can be overridden per container using the "tedge.crashloop" label.
+	CrashLoopAction string
+
+	// OOMClearAfter is how long a container which was killed by the OOM killer
+	// must run healthily before the OOM alarm is cleared
+	OOMClearAfter time.Duration
+
+	// UnhealthyAlarmAfter is how long a container must stay unhealthy before an alarm is raised.
+	// A value of 0 (or less) disables the alarm.
+	UnhealthyAlarmAfter time.Duration
+
+	// EnableExitAlarm raises an alarm when a container which is not restarted by the
+	// container engine (restart policy "no") exits with a non-zero exit code
+	EnableExitAlarm   bool
+	ExitAlarmSeverity string
+
 	// UseModuleNameForService controls whether the thin-edge service name for
 	// container-group services is derived from the stored module name (true,
 	// default) or from the compose project name taken from Docker labels
@@ -122,6 +255,11 @@ type Config struct {
 	// but the compose project name (and therefore the service name) is "myapp".
 	UseModuleNameForService bool
 
+	// ContainerGroupMode controls how container-groups are registered, either as services of the
+	// device (ContainerGroupModeService, default), or as child devices with their services beneath
+	// them (ContainerGroupModeDevice)
+	ContainerGroupMode string
+
 	// SyncRetryInterval is the delay before a failed cloud sync is retried,
 	// e.g. a stale service which could not be deleted from the cloud because
 	// the local Cumulocity proxy was unavailable. The retry is scheduled by
@@ -140,6 +278,33 @@ type Config struct {
 	// A value of 0 (or less) checks on every update.
 	// Only applies when DeleteFromCloud and DeleteOrphans are enabled.
 	OrphansCheckInterval time.Duration
+
+	// RegistryCredentialsConfigType is the config type of the config_update
+	// operation used to deliver registry credentials from the cloud.
+	// An empty value disables the feature.
+	RegistryCredentialsConfigType string
+	// RegistryCredentialsPath is the location of the delivered credentials file.
+	// Commands which delivered the file to another path are ignored
+	RegistryCredentialsPath string
+	// ImportRegistryCredentials validates and securely stores the delivered
+	// credentials file, returning the list of registries
+	ImportRegistryCredentials func(path string) ([]string, error)
+
+	// ImageAutoUpdateWindow is the time window in which containers with the
+	// tedge.autoupdate=digest label are updated. A nil window allows updates at any time.
+	ImageAutoUpdateWindow *utils.TimeWindow
+	// ImageAutoUpdateHealthyAfter is the duration the updated container must stay
+	// healthy, otherwise the previous container is restored
+	ImageAutoUpdateHealthyAfter time.Duration
+	// RegistryAuthFunc returns the registry credentials (encoded registry auth) of an image
+	RegistryAuthFunc func(imageRef string) func(ctx context.Context, attempt int) (string, error)
+	// VerifyImage checks the signature of an image (according to the verification policy)
+	// before it is used for an automatic update
+	VerifyImage func(ctx context.Context, containerCli *container.ContainerClient, imageRef string) error
+
+	// StateFile is where the crash loop detection state (restart baselines, crash loop alarms
+	// and event rate limits) is persisted across restarts. An empty value disables persistence.
+	StateFile string
 }
 
 func NewApp(device tedge.Target, config Config) (*App, error) {
@@ -162,32 +327,6 @@ func NewApp(device tedge.Target, config Config) (*App, error) {
 	}
 	tedgeClient := tedge.NewClient(device, *serviceTarget, config.ServiceName, tedgeOpts)
 
-	ctx, ctxCancel := context.WithTimeout(context.TODO(), 300*time.Second)
-	defer ctxCancel()
-
-	clientOptions := make([]container.Opt, 0)
-
-	// Build reconnect options (limited retries, used when Monitor restarts).
-	reconnectOpts := make([]container.Opt, 0)
-	if config.ContainerHost != "" {
-		reconnectOpts = append(reconnectOpts, container.WithHost(config.ContainerHost))
-	}
-
-	// Use a time-based timeout instead of limiting number of retries
-	clientOptions = append(clientOptions, container.WithInfiniteRetries())
-
-	if config.ContainerHost != "" {
-		clientOptions = append(clientOptions, container.WithHost(config.ContainerHost))
-	}
-
-	containerClient, err := container.NewContainerClient(
-		ctx,
-		clientOptions...,
-	)
-	if err != nil {
-		return nil, err
-	}
-
 	// Register via http interface
 	_, registrationErr := tedgeClient.TedgeAPI.CreateEntity(context.Background(), tedge.Entity{
 		TedgeType:    tedge.EntityTypeService,
@@ -221,18 +360,44 @@ func NewApp(device tedge.Target, config Config) (*App, error) {
 		}
 	}
 
+	// Connect to the engines after connecting to the broker, so that an unreachable engine raises
+	// the engine unavailable alarm. The unreachable engines are connected later by the monitor, so only
+	// wait for the engines in run-once mode
+	engines, err := newContainerEngines(config.ContainerHosts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, ctxCancel := context.WithTimeout(context.TODO(), 300*time.Second)
+	defer ctxCancel()
+	connectOpts := []container.Opt{container.WithAttempts(1)}
+	if config.RunOnce {
+		// Use a time-based timeout instead of limiting number of retries
+		connectOpts = []container.Opt{container.WithInfiniteRetries()}
+	}
+	unavailableEngines := connectEngines(ctx, engines, connectOpts...)
+	if config.RunOnce && len(unavailableEngines) == len(engines) {
+		errs := make([]error, 0, len(unavailableEngines))
+		for host, err := range unavailableEngines {
+			errs = append(errs, fmt.Errorf("host=%s, err=%w", host, err))
+		}
+		return nil, fmt.Errorf("could not connect to container engine. %w", errors.Join(errs...))
+	}
+
 	application := &App{
-		client:              tedgeClient,
-		containerClientOpts: reconnectOpts,
-		Device:              &device,
-		config:              config,
+		client:           tedgeClient,
+		engines:          engines,
+		containerEngines: make(map[string]*containerEngine),
+		Device:           &device,
+		config:           config,
 		// Buffered so that the debouncer's dispatch and synchronous Update()
 		// calls never block when the worker is briefly busy.
 		updateRequests: make(chan ActionRequest, 8),
 		shutdown:       make(chan struct{}),
+		imageUpdates:   make(map[string]ImageUpdateInfo),
+		publishCache:   newPublishCache(config.PublishRefreshInterval),
+		containerIndex: make(map[string]string),
 		wg:             sync.WaitGroup{},
 	}
-	application.containerClient.Store(containerClient)
 
 	// The debouncer coalesces rapid-fire event-driven update requests into a
 	// single doUpdate call. It is used by Monitor() and Subscribe() callbacks.
@@ -247,16 +412,59 @@ func NewApp(device tedge.Target, config Config) (*App, error) {
 	if config.CrashLoopThreshold <= 0 {
 		config.CrashLoopThreshold = 5
 	}
+	if !ValidCrashLoopAction(config.CrashLoopAction) {
+		slog.Warn("Unknown crash loop action. Using the default.", "action", config.CrashLoopAction, "default", CrashLoopActionNone)
+		application.config.CrashLoopAction = CrashLoopActionNone
+	}
 	// Track container restart baselines to detect crash loops using the Docker
 	// daemon's own RestartCount.
 	application.restartBaseline = make(map[string]int)
 	application.crashLoopAlarms = make(map[string]struct{})
+	application.retainedCrashLoopAlarms = make(map[string]struct{})
+	application.oomAlarms = make(map[string]*time.Timer)
+	application.oomAlarmRaisedAt = make(map[string]time.Time)
+	application.exitAlarms = make(map[string]struct{})
+	application.lastMemoryUsage = make(map[string]container.StatsEntry)
+	application.unhealthyAlarms = make(map[string]*time.Timer)
+	if !ValidContainerGroupMode(config.ContainerGroupMode) {
+		if config.ContainerGroupMode != "" {
+			slog.Warn("Unknown container-group mode. Using the default.", "mode", config.ContainerGroupMode, "default", ContainerGroupModeService)
+		}
+		application.config.ContainerGroupMode = ContainerGroupModeService
+	}
+	if config.ExitAlarmSeverity == "" {
+		application.config.ExitAlarmSeverity = "MAJOR"
+	}
+	if config.DiagnosticsLogLines <= 0 {
+		application.config.DiagnosticsLogLines = 1000
+	}
+	if config.ContainerExecTimeout <= 0 {
+		application.config.ContainerExecTimeout = 30 * time.Second
+	}
+	if config.ContainerExecMaxOutput <= 0 {
+		application.config.ContainerExecMaxOutput = 1024 * 1024
+	}
+	if config.OOMClearAfter <= 0 {
+		application.config.OOMClearAfter = 5 * time.Minute
+	}
 
 	// Rate-limit engine event publishes: at most 1 event per
 	// (container, event-type) per 5 seconds. Combined with crash-loop
 	// suppression this eliminates broker flooding from restart storms.
 	application.eventLimiter = NewEventRateLimiter(5 * time.Second)
 
+	eventTypes := config.EventTypes
+	if len(eventTypes) == 0 {
+		eventTypes = []string{EventTypeContainer}
+	}
+	application.eventSelector = NewEventSelector(eventTypes, config.EventActions, config.EventIncludeLabels, config.EventExcludeLabels, config.EventTypeNames)
+
+	// Restore the state from before the plugin was restarted. It is reconciled
+	// with the containers on the first full update
+	if !config.RunOnce {
+		application.loadState()
+	}
+
 	// Start background task to process requests
 	application.wg.Add(1)
 	go application.worker()
@@ -269,29 +477,15 @@ func NewApp(device tedge.Target, config Config) (*App, error) {
 		return nil, err
 	}
 
-	return application, nil
-}
-
-// getContainerClient returns the current ContainerClient. The pointer is loaded
-// atomically so it is safe to call concurrently with ReconnectContainerClient.
-func (a *App) getContainerClient() *container.ContainerClient {
-	return a.containerClient.Load()
-}
-
-// ReconnectContainerClient creates a new ContainerClient using the same host
-// options as the original and atomically replaces the current one. It is called
-// by the Monitor restart loop when the event stream fails, so that a stale
-// client (e.g. after a podman socket restart) does not block recovery.
-// It uses a bounded retry count so it doesn't block forever during shutdown.
-func (a *App) ReconnectContainerClient(ctx context.Context) error {
-	opts := append([]container.Opt{container.WithAttempts(5)}, a.containerClientOpts...)
-	newClient, err := container.NewContainerClient(ctx, opts...)
-	if err != nil {
-		return err
+	// Record the engines which could not be reached. Any engine unavailable alarm from before the plugin
+	// was restarted is cleared once all of the engines are connected
+	if !config.RunOnce {
+		if err := application.updateEngineAvailability(unavailableEngines); err != nil {
+			slog.Warn("Could not update the container engine availability.", "err", err)
+		}
 	}
-	a.containerClient.Store(newClient)
-	slog.Info("Container client reconnected successfully.")
-	return nil
+
+	return application, nil
 }
 
 func (a *App) DeleteLegacyService(deleteFromCloud bool) {
@@ -344,22 +538,34 @@ func (a *App) DeleteOrphanedCloudServices(tedgeEntities map[string]tedge.Entity)
 			slog.Info("Service is registered locally.", "topic-id", target.TopicID)
 		}
 	}
+
+	// Container-group devices are child devices (not child additions)
+	if a.groupDevicesEnabled() {
+		return a.deleteOrphanedGroupDevices(extID.ManagedObject.ID, tedgeEntities)
+	}
 	return nil
 }
 
 func (a *App) Subscribe() error {
-	topic := tedge.GetTopic(*a.Device.Service("+"), "cmd", "health", "check")
+	topic := tedge.GetTopic(*a.serviceTopicPattern(), "cmd", "health", "check")
 	slog.Info("Listening to commands on topic.", "topic", topic)
 
 	a.client.Client.AddRoute(topic, func(c mqtt.Client, m mqtt.Message) {
-		parts := strings.Split(m.Topic(), "/")
-		if len(parts) > 5 {
-			slog.Info("Received request to update service data.", "service", parts[4], "topic", topic)
-			name := parts[4]
+		target, err := tedge.NewTargetFromTopic(m.Topic())
+		if err != nil {
+			return
+		}
+		name := a.serviceNameFromTopicID(target.TopicID)
+		if name != "" {
+			slog.Info("Received request to update service data.", "service", name, "topic", topic)
 			opts := container.FilterOptions{}
-			// If the name matches the current service name, then update all containers
+			// If the name matches the current service name, then update all containers.
+			// The messages are republished even if they are unchanged, as they were explicitly requested
 			if name != a.config.ServiceName {
 				opts.Names = []string{fmt.Sprintf("^%s$", name)}
+				a.publishCache.Forget(target.TopicID)
+			} else {
+				a.publishCache.ForgetAll()
 			}
 			a.debouncer.Enqueue(NewUpdateAllAction(opts))
 		}
@@ -378,12 +584,34 @@ func (a *App) Subscribe() error {
 			}
 			if isBridgeOnline(m.Payload()) {
 				slog.Info("Cloud bridge is online, triggering service resync to process any pending cloud deletions.", "topic", m.Topic())
+				// Republish all messages, as any published while the bridge was offline may have been lost
+				a.publishCache.ForgetAll()
 				a.debouncer.Enqueue(NewUpdateAllAction(container.FilterOptions{}))
+				go a.refreshPluginInfo()
 			}
 		})
 	}
 
-	return nil
+	if !a.config.RunOnce {
+		a.client.OnConnect(a.refreshPluginInfo)
+		a.client.OnConnect(a.republishEngineUnavailable)
+		if err := a.subscribeCrashLoopAlarms(); err != nil {
+			return err
+		}
+		if err := a.subscribeOOMAlarms(); err != nil {
+			return err
+		}
+		if err := a.subscribeUnhealthyAlarms(); err != nil {
+			return err
+		}
+		if err := a.subscribeExitAlarms(); err != nil {
+			return err
+		}
+		if err := a.subscribeContainerOperations(); err != nil {
+			return err
+		}
+	}
+	return a.subscribeRegistryCredentials()
 }
 
 func (a *App) Stop(clean bool) {
@@ -397,6 +625,9 @@ func (a *App) Stop(clean bool) {
 
 	// Wait for shutdown confirmation
 	a.wg.Wait()
+	if !a.config.RunOnce {
+		a.saveState()
+	}
 }
 
 // sendResult delivers err to the request's result channel when one was
@@ -419,10 +650,13 @@ func (a *App) worker() {
 			switch req.Action {
 			case ActionUpdateAll:
 				slog.Info("Processing update request")
+				start := time.Now()
 				err := a.doUpdate(req.Options.(container.FilterOptions))
+				a.metrics.observeUpdate(time.Since(start), err)
+				a.recordSyncResult(err)
 				sendResult(req, err)
 			case ActionUpdateMetrics:
-				items, err := a.getContainerClient().List(context.Background(), req.Options.(container.FilterOptions))
+				items, err := a.listContainers(context.Background(), req.Options.(container.FilterOptions))
 				if err != nil {
 					slog.Warn("Could not get container list.", "err", err)
 				} else {
@@ -433,6 +667,12 @@ func (a *App) worker() {
 					}
 				}
 				sendResult(req, err)
+			case ActionRemoveService:
+				err := a.doRemoveService(req.Options.(RemoveServiceOptions))
+				if err != nil {
+					slog.Warn("Error removing service.", "err", err)
+				}
+				sendResult(req, err)
 			}
 
 		case <-a.shutdown:
@@ -497,6 +737,7 @@ var ContainerEventText = map[events.Action]string{
 	events.ActionExecDie:               "process died",
 	events.ActionHealthStatusHealthy:   "healthy",
 	events.ActionHealthStatusUnhealthy: "unhealthy",
+	events.ActionOOM:                   "killed by the OOM killer",
 }
 
 func mustMarshalJSON(v any) []byte {
@@ -560,8 +801,10 @@ func (a *App) serviceNameFromEventAttrs(attr map[string]string) string {
 }
 
 func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions) error {
-	evtCh, errCh := a.getContainerClient().MonitorEvents(ctx)
-	slog.Info("Subscribed to container engine event stream.")
+	// Stop the event streams of all engines when returning
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	evtCh := a.monitorEvents(ctx, filterOptions)
 
 	// Update after subscribing to the events but before reacting to them
 	if err := a.Update(filterOptions); err != nil {
@@ -590,7 +833,8 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 				}
 
 				payload := make(map[string]any)
-				if action, ok := ContainerEventText[evt.Action]; ok {
+				if a.eventSelector.Match(evt) {
+					action := eventActionText(evt)
 					props := getEventAttributes(evt.Actor.Attributes, "name", "image", "com.docker.compose.project")
 					name := props[0]
 					image := props[1]
@@ -606,6 +850,9 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 					}
 					payload["containerID"] = evt.Actor.ID
 					payload["attributes"] = evt.Actor.Attributes
+					if engine := a.containerEngineName(evt.Actor.ID); engine != "" {
+						payload["engine"] = engine
+					}
 				}
 
 				switch evt.Action {
@@ -616,13 +863,21 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 					if evt.Action == events.ActionHealthStatusHealthy {
 						serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes)
 						if serviceName != "" {
-							if rc, err := a.getContainerClient().GetRestartCount(context.Background(), evt.Actor.ID); err == nil {
+							if rc, err := a.clientForContainer(evt.Actor.ID).GetRestartCount(context.Background(), evt.Actor.ID); err == nil {
 								a.restartBaselineMu.Lock()
 								a.restartBaseline[serviceName] = rc
 								a.restartBaselineMu.Unlock()
+								a.markStateChanged()
 							}
 							a.clearCrashLoopAlarm(serviceName)
 							a.eventLimiter.Remove(serviceName)
+							a.scheduleOOMAlarmClear(serviceName, evt.Actor.ID)
+							a.clearUnhealthyAlarm(serviceName)
+						}
+					}
+					if evt.Action == events.ActionHealthStatusUnhealthy {
+						if serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes); serviceName != "" {
+							a.scheduleUnhealthyAlarm(serviceName, evt.Actor.ID)
 						}
 					}
 					// On each daemon-initiated restart the Docker daemon increments
@@ -631,7 +886,9 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 					if evt.Action == events.ActionStart {
 						serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes)
 						if serviceName != "" {
-							if rc, err := a.getContainerClient().GetRestartCount(context.Background(), evt.Actor.ID); err == nil && rc > 0 {
+							a.scheduleOOMAlarmClear(serviceName, evt.Actor.ID)
+							a.clearExitAlarm(serviceName)
+							if rc, err := a.clientForContainer(evt.Actor.ID).GetRestartCount(context.Background(), evt.Actor.ID); err == nil && rc > 0 {
 								a.restartBaselineMu.Lock()
 								baseline, exists := a.restartBaseline[serviceName]
 								if !exists {
@@ -640,12 +897,15 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 									// (before the plugin started) are not counted.
 									a.restartBaseline[serviceName] = rc
 									a.restartBaselineMu.Unlock()
+									a.markStateChanged()
 								} else {
 									delta := rc - baseline
 									a.restartBaselineMu.Unlock()
 									if delta >= a.config.CrashLoopThreshold {
 										slog.Warn("Crash loop detected.", "container", serviceName, "restartCount", rc, "baseline", baseline, "delta", delta)
-										a.publishCrashLoopAlarm(serviceName, rc)
+										if a.publishCrashLoopAlarm(serviceName, rc) {
+											go a.remediateCrashLoop(context.Background(), serviceName, evt.Actor.ID)
+										}
 									}
 								}
 							}
@@ -663,65 +923,106 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 						ExcludeNames:     filterOptions.ExcludeNames,
 						ExcludeWithLabel: filterOptions.ExcludeWithLabel,
 					}))
-				case events.ActionDestroy, events.ActionRemove, events.ActionDie:
+				case events.ActionKill:
+					a.exitRequests.Record(evt.Actor.ID)
+				case events.ActionOOM:
+					// Not all engines emit the oom event, so the die event is also checked. The check is
+					// delayed so that the die event (which includes the exit code) is normally used
+					if serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes); serviceName != "" {
+						containerID, attributes := evt.Actor.ID, evt.Actor.Attributes
+						time.AfterFunc(2*time.Second, func() {
+							exit := a.inspectContainerExit(context.Background(), containerID, attributes)
+							exit.OOMKilled = true
+							a.checkOOMKilled(serviceName, containerID, exit)
+						})
+					}
+				case events.ActionDie:
+					// The container still exists, so only its state needs to be updated
+					slog.Info("Container died", "container", evt.Actor.ID, "attributes", evt.Actor.Attributes)
+					// The event is published once the exit information is known
+					go a.handleContainerDie(evt, payload)
+					payload = nil
+					a.debouncer.Enqueue(NewUpdateAllAction(container.FilterOptions{
+						IDs: []string{evt.Actor.ID},
+						// Preserve global filter options
+						Names:            filterOptions.Names,
+						Labels:           filterOptions.Labels,
+						Types:            filterOptions.Types,
+						ExcludeNames:     filterOptions.ExcludeNames,
+						ExcludeWithLabel: filterOptions.ExcludeWithLabel,
+					}))
+				case events.ActionDestroy, events.ActionRemove:
 					slog.Info("Container removed/destroyed", "container", evt.Actor.ID, "attributes", evt.Actor.Attributes)
-					serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes)
+					// Prefer the indexed service name as it was resolved the same way as
+					// when the service was registered (e.g. using the module name)
+					serviceName := a.lookupServiceName(evt.Actor.ID)
+					if serviceName == "" {
+						serviceName = a.serviceNameFromEventAttrs(evt.Actor.Attributes)
+					}
 					if serviceName != "" {
-						switch evt.Action {
-						case events.ActionDestroy, events.ActionRemove:
-							// Container was permanently removed — clear baseline, alarm, and rate-limit state.
-							a.restartBaselineMu.Lock()
-							delete(a.restartBaseline, serviceName)
-							a.restartBaselineMu.Unlock()
-							a.clearCrashLoopAlarm(serviceName)
-							a.eventLimiter.Remove(serviceName)
-						}
+						// Container was permanently removed — clear baseline, alarm, and rate-limit state.
+						a.restartBaselineMu.Lock()
+						delete(a.restartBaseline, serviceName)
+						a.restartBaselineMu.Unlock()
+						a.markStateChanged()
+						a.clearCrashLoopAlarm(serviceName)
+						a.clearOOMAlarm(serviceName)
+						a.clearUnhealthyAlarm(serviceName)
+						a.clearExitAlarm(serviceName)
+						a.eventLimiter.Remove(serviceName)
+						a.lastMemoryUsageMu.Lock()
+						delete(a.lastMemoryUsage, serviceName)
+						a.lastMemoryUsageMu.Unlock()
 					}
-					// TODO: Trigger a removal instead of checking the whole state
-					// Lookup container name by container id (from the entity store) as lookup by name won't work for container-groups
-					a.debouncer.Enqueue(NewUpdateAllAction(filterOptions))
+					// Only remove the affected service. The periodic reconcile (full update)
+					// acts as a safety net if the removal is missed
+					a.enqueueRemoveService(RemoveServiceOptions{
+						ContainerID:   evt.Actor.ID,
+						ServiceName:   serviceName,
+						FilterOptions: filterOptions,
+					})
+					a.forgetContainerEngine(evt.Actor.ID)
 				}
 
-				if a.config.EnableEngineEvents && len(payload) > 0 {
-					serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes)
-					key := serviceName + "/" + string(evt.Action)
-
-					// Determine whether a crash loop is active for this container.
-					a.crashLoopAlarmsMu.Lock()
-					_, inCrashLoop := a.crashLoopAlarms[serviceName]
-					a.crashLoopAlarmsMu.Unlock()
-
-					switch {
-					case inCrashLoop:
-						// Suppress all events for a crash-looping container to
-						// avoid saturating the broker. The alarm already signals
-						// the operator.
-						slog.Debug("Suppressing engine event for crash-looping container.",
-							"container", serviceName, "action", evt.Action)
-					case !a.eventLimiter.Allow(key):
-						// Rate-limit: too many events for this (container,
-						// action) pair within the window.
-						slog.Debug("Rate-limiting engine event.",
-							"container", serviceName, "action", evt.Action)
-					default:
-						if err := a.client.Publish(tedge.GetTopic(a.client.Target, "e", string(evt.Action)), 1, false, mustMarshalJSON(payload)); err != nil {
-							slog.Warn("Failed to publish container event.", "err", err)
-						}
-					}
+				a.publishContainerEvent(evt, payload)
+			case events.ImageEventType, events.NetworkEventType, events.VolumeEventType:
+				if a.config.EnableEngineEvents && a.eventSelector.Match(evt) {
+					a.publishEngineEvent(evt)
 				}
 			}
-		case err := <-errCh:
-			if err == nil {
-				// Channel closed without a value — treat as a stream closure so
-				// the restart loop reconnects rather than spinning with no delay.
-				err = fmt.Errorf("event stream closed unexpectedly")
-			}
-			if errors.Is(err, io.EOF) {
-				slog.Info("No more events")
-			} else {
-				slog.Warn("Received error.", "value", err)
-			}
-			return err
+		}
+	}
+}
+
+// publishContainerEvent publishes the event of a container, unless the container is in a crash loop
+// or its events are rate limited
+func (a *App) publishContainerEvent(evt events.Message, payload map[string]any) {
+	if !a.config.EnableEngineEvents || len(payload) == 0 {
+		return
+	}
+	serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes)
+	key := serviceName + "/" + string(evt.Action)
+
+	// Determine whether a crash loop is active for this container.
+	a.crashLoopAlarmsMu.Lock()
+	_, inCrashLoop := a.crashLoopAlarms[serviceName]
+	a.crashLoopAlarmsMu.Unlock()
+
+	switch {
+	case inCrashLoop:
+		// Suppress all events for a crash-looping container to
+		// avoid saturating the broker. The alarm already signals
+		// the operator.
+		slog.Debug("Suppressing engine event for crash-looping container.",
+			"container", serviceName, "action", evt.Action)
+	case !a.eventLimiter.Allow(key):
+		// Rate-limit: too many events for this (container,
+		// action) pair within the window.
+		slog.Debug("Rate-limiting engine event.",
+			"container", serviceName, "action", evt.Action)
+	default:
+		if err := a.client.Publish(tedge.GetTopic(a.client.Target, "e", a.eventSelector.TypeName(evt)), 1, false, mustMarshalJSON(payload)); err != nil {
+			slog.Warn("Failed to publish container event.", "err", err)
 		}
 	}
 }
@@ -735,20 +1036,24 @@ func (a *App) Monitor(ctx context.Context, filterOptions container.FilterOptions
 // registered (idempotent), which ensures the mapper can route the alarm to
 // Cumulocity even when the container dies faster than the debounced
 // doUpdate() manages to register it.
-func (a *App) publishCrashLoopAlarm(name string, count int) {
+//
+// It returns true if the alarm was newly raised.
+func (a *App) publishCrashLoopAlarm(name string, count int) bool {
 	a.crashLoopAlarmsMu.Lock()
 	_, alreadyRaised := a.crashLoopAlarms[name]
 	if !alreadyRaised {
 		a.crashLoopAlarms[name] = struct{}{}
+		a.metrics.crashLoopAlarms.Add(1)
 	}
 	a.crashLoopAlarmsMu.Unlock()
 
 	if alreadyRaised {
-		return
+		return false
 	}
+	a.markStateChanged()
 
 	go func() {
-		target := a.Device.Service(name)
+		target := a.serviceTarget(name)
 		topic := tedge.GetTopic(*target, "a", "ContainerCrashLoop")
 		payload := mustMarshalJSON(map[string]any{
 			"severity": "CRITICAL",
@@ -765,13 +1070,8 @@ func (a *App) publishCrashLoopAlarm(name string, count int) {
 		if strings.Contains(name, "@") {
 			entityType = container.ContainerGroupType
 		}
-		if _, err := a.client.TedgeAPI.CreateEntity(context.Background(), tedge.Entity{
-			TedgeType:     tedge.EntityTypeService,
-			TedgeTopicID:  target.TopicID,
-			Name:          name,
-			Type:          entityType,
-			TedgeParentID: a.client.Parent.TopicID,
-		}); err != nil {
+		a.ensureGroupDevice(name)
+		if _, err := a.client.TedgeAPI.CreateEntity(context.Background(), a.serviceEntity(name, entityType)); err != nil {
 			slog.Warn("Could not pre-register entity for crash-loop alarm.", "container", name, "err", err)
 		}
 
@@ -786,6 +1086,8 @@ func (a *App) publishCrashLoopAlarm(name string, count int) {
 		})
 		if err := a.client.Publish(healthTopic, 1, true, healthPayload); err != nil {
 			slog.Warn("Could not set crash-loop container status to down.", "container", name, "err", err)
+		} else {
+			a.publishCache.Record(healthCacheKey(target.TopicID), fingerprint(tedge.StatusDown))
 		}
 
 		slog.Warn("Publishing crash-loop alarm.", "container", name, "topic", topic, "restarts", count)
@@ -814,7 +1116,9 @@ func (a *App) publishCrashLoopAlarm(name string, count int) {
 		a.crashLoopAlarmsMu.Lock()
 		delete(a.crashLoopAlarms, name)
 		a.crashLoopAlarmsMu.Unlock()
+		a.markStateChanged()
 	}()
+	return true
 }
 
 // clearCrashLoopAlarm clears any active crash-loop alarm for the named
@@ -828,8 +1132,9 @@ func (a *App) clearCrashLoopAlarm(name string) {
 	if !had {
 		return
 	}
+	a.markStateChanged()
 
-	target := a.Device.Service(name)
+	target := a.serviceTarget(name)
 	topic := tedge.GetTopic(*target, "a", "ContainerCrashLoop")
 	slog.Info("Clearing crash-loop alarm.", "container", name, "topic", topic)
 	if err := a.client.Publish(topic, 1, true, ""); err != nil {
@@ -842,26 +1147,38 @@ func (a *App) updateMetrics(items []container.TedgeContainer) error {
 	numJobs := len(items)
 	jobs := make(chan container.TedgeContainer, numJobs)
 	results := make(chan error, numJobs)
+	// Resource usage of the container-group services, used for the totals of the container-group devices
+	groupUsage := make(map[string]container.StatsEntry)
+	// Resource usage by container id, served by the metrics exporter
+	samples := make(map[string]container.StatsEntry)
+	groupUsageMu := sync.Mutex{}
 
 	doWork := func(jobs <-chan container.TedgeContainer, results chan<- error) {
 		for j := range jobs {
 			var jobErr error
 			// TODO: Check if container exists, if not then do nothing
-			target := a.Device.Service(j.Name)
+			target := a.serviceTarget(j.Name)
 			if _, entityErr := a.client.TedgeAPI.GetEntity(context.Background(), *target); entityErr != nil {
 				slog.Info("Entity has not be registered yet, skipping metric for it.", "topic-id", target.TopicID)
 				results <- jobErr
 				continue
 			}
 
-			stats, jobErr := a.getContainerClient().GetStats(context.Background(), j.Container.Id)
-			if jobErr == nil {
-				topic := tedge.GetTopic(*target, "m", "resource_usage")
-				payload, err := json.Marshal(stats)
-				if err == nil {
-					slog.Info("Publish container stats.", "topic", topic, "payload", payload)
-					jobErr = a.client.Publish(topic, 1, false, payload)
-				}
+			// Errors are ignored (as the engine may not support the stats), but the
+			// memory usage is only recorded from a valid sample
+			statsEntry, statsErr := a.clientForContainer(j.Container.Id).GetStatsEntry(context.Background(), j.Container.Id)
+			if statsErr == nil {
+				a.recordMemoryUsage(j.Name, statsEntry)
+				groupUsageMu.Lock()
+				groupUsage[j.Name] = statsEntry
+				samples[j.Container.Id] = statsEntry
+				groupUsageMu.Unlock()
+			}
+			topic := tedge.GetTopic(*target, "m", "resource_usage")
+			payload, err := json.Marshal(container.NewContainerTelemetryMessage(statsEntry))
+			if err == nil {
+				slog.Info("Publish container stats.", "topic", topic, "payload", payload)
+				jobErr = a.client.Publish(topic, 1, false, payload)
 			}
 			results <- jobErr
 		}
@@ -884,6 +1201,10 @@ func (a *App) updateMetrics(items []container.TedgeContainer) error {
 			slog.Warn("Failed to update metrics.", "err", err)
 		}
 	}
+	a.storeStatsSamples(samples)
+	if a.groupDevicesEnabled() {
+		a.publishGroupMetrics(groupUsage)
+	}
 	return errors.Join(jobErrors...)
 }
 
@@ -929,89 +1250,75 @@ func (a *App) doUpdate(filterOptions container.FilterOptions) error {
 	}
 
 	slog.Info("Reading containers")
-	items, err := a.getContainerClient().List(context.Background(), filterOptions)
+	items, failedEngines, err := a.listEngineContainers(context.Background(), filterOptions)
 	if err != nil {
 		return err
 	}
+	for name, engineErr := range failedEngines {
+		slog.Warn("Could not list the containers of the engine. Keeping its services.", "engine", name, "err", engineErr)
+	}
 	items = a.applyServiceNamePolicy(items)
+	// Keep the containers of the unreachable engines in the index
+	a.updateContainerIndex(entities, items, removeStaleServices && len(failedEngines) == 0)
+
+	// Reconcile the crash loop state restored from before the plugin was restarted
+	if removeStaleServices && len(failedEngines) == 0 && !a.crashLoopStateReconciled && !a.config.RunOnce {
+		a.reconcileCrashLoopState(context.Background(), items)
+		a.reconcileOOMAlarms(items)
+		a.reconcileUnhealthyAlarms(items)
+		a.reconcileExitAlarms(items)
+		a.crashLoopStateReconciled = true
+	}
+
+	// Register the container-group devices before their services
+	if a.groupDevicesEnabled() {
+		slog.Info("Registering container-group devices")
+		a.registerGroupDevices(items, entities, existingServices)
+	}
 
-	// Register devices
+	// Register the services, then publish their twin information and health status. The services are
+	// processed concurrently, but the steps of each service are done in order. Containers providing
+	// the same service (e.g. replicas) are processed by the same worker so that they don't race
 	slog.Info("Registering containers")
+	services := make([][]container.TedgeContainer, 0, len(items))
+	serviceIndex := make(map[string]int)
 	for _, item := range items {
-		target := a.Device.Service(item.Name)
-		delete(existingServices, target.TopicID)
-
-		// Register using HTTP API
-		entity := tedge.Entity{
-			TedgeType:     tedge.EntityTypeService,
-			TedgeTopicID:  target.TopicID,
-			Name:          item.Name,
-			Type:          item.ServiceType,
-			TedgeParentID: tedgeClient.Parent.TopicID,
+		target := a.serviceTarget(item.Name)
+		if _, registered := existingServices[target.TopicID]; !registered {
+			// Publish the twin and health of new (or re-registered) services
+			a.publishCache.Forget(target.TopicID)
 		}
-		resp, err := tedgeClient.TedgeAPI.CreateEntity(context.Background(), entity)
+		delete(existingServices, target.TopicID)
 
-		if err == nil {
-			slog.Info("Registered container.", "topic", target.Topic(), "url", resp.RawResponse.Request.URL.String(), "status_code", resp.RawResponse.Status)
+		if i, exists := serviceIndex[item.Name]; exists {
+			services[i] = append(services[i], item)
 		} else {
-			slog.Error("Failed to register container.", "topic", target.Topic(), "err", err)
+			serviceIndex[item.Name] = len(services)
+			services = append(services, []container.TedgeContainer{item})
 		}
-
-		// Manually add to entities store for re-use later without having to fetch a new list of entities
-		entities[entity.TedgeTopicID] = entity
 	}
-
-	// update digital twin information
-	slog.Info("Updating digital twin information")
-	for _, item := range items {
-		target := a.Device.Service(item.Name)
-
-		// Create status
-		_, err := tedgeClient.TedgeAPI.UpdateTwin(
-			context.Background(),
-			tedge.Entity{
-				TedgeTopicID: target.TopicID,
-			},
-			"container",
-			item.Container,
-		)
-		if err != nil {
-			slog.Error("Could not publish container status", "err", err)
+	entitiesMu := sync.Mutex{}
+	publishedChanges := atomic.Bool{}
+	updateErr := forEachConcurrently(services, updateWorkers, func(serviceItems []container.TedgeContainer) error {
+		errs := make([]error, 0)
+		for _, item := range serviceItems {
+			entity, changed, err := a.updateService(item)
+			// Manually add to entities store for re-use later without having to fetch a new list of entities
+			entitiesMu.Lock()
+			entities[entity.TedgeTopicID] = entity
+			entitiesMu.Unlock()
+			if changed {
+				publishedChanges.Store(true)
+			}
+			errs = append(errs, err)
 		}
+		return errors.Join(errs...)
+	})
+	if updateErr != nil {
+		slog.Warn("Failed to update some services.", "err", updateErr)
 	}
-
-	// Publish health messages last so the health status always wins over any
-	// implicit status that the C8Y mapper may emit when processing the entity
-	// registration or twin update messages (which could otherwise race and
-	// override an explicit "down" status with "up").
-	for _, item := range items {
-		target := a.Device.Service(item.Name)
-
-		// If this container is in a crash loop, report it as down regardless of
-		// what Docker currently reports — during a crash loop the container is
-		// transiently running between restarts when the scan fires.
-		status := item.Status
-		a.crashLoopAlarmsMu.Lock()
-		_, inCrashLoop := a.crashLoopAlarms[item.Name]
-		a.crashLoopAlarmsMu.Unlock()
-		if inCrashLoop {
-			status = tedge.StatusDown
-		}
-
-		payload := map[string]any{
-			"status": status,
-			"time":   item.Time,
-		}
-		b, err := json.Marshal(payload)
-		if err != nil {
-			slog.Warn("Could not marshal registration message", "err", err)
-			continue
-		}
-		topic := tedge.GetHealthTopic(*target)
-		slog.Info("Publishing container health status", "topic", topic, "payload", b)
-		if err := tedgeClient.Publish(topic, 1, true, b); err != nil {
-			slog.Error("Failed to update health status", "target", topic, "err", err)
-		}
+	if publishedChanges.Load() {
+		a.markStateChanged()
 	}
 
 	// Delete stale services — cloud first, then thin-edge.
@@ -1026,28 +1333,22 @@ func (a *App) doUpdate(filterOptions container.FilterOptions) error {
 	// trigger.
 	cloudSyncPending := false
 	staleServicesRemoved := false
+	pendingCloudDeletions := make([]string, 0)
 	if removeStaleServices {
 		slog.Info("Checking for any stale services")
-		for staleTopicID := range existingServices {
-			slog.Info("Removing stale service.", "topic-id", staleTopicID)
-			target := tedge.NewTarget(a.Device.RootPrefix, staleTopicID)
-
-			// Attempt cloud deletion first. On failure, leave the entity
-			// registered in thin-edge so it is re-detected on the next run.
-			if a.config.DeleteFromCloud {
-				target.CloudIdentity = tedgeClient.Target.CloudIdentity
-				if target.CloudIdentity != "" {
-					slog.Info("Removing service from the cloud", "topic", target.Topic())
-					if _, err := tedgeClient.DeleteCumulocityManagedObject(*target); err != nil {
-						slog.Warn("Failed to delete managed object, will retry on next update.", "err", err, "topic", target.Topic())
-						cloudSyncPending = true
-						continue
-					}
-				}
+		// Services are removed before the container-group devices which they belong to
+		for _, staleTopicID := range sortStaleEntities(existingServices) {
+			// The services of an unreachable engine are not stale. They are removed by a later update
+			// (once all of the engines are reachable) if their containers no longer exist
+			if !a.isContainerOfReachableEngine(entities[staleTopicID].ContainerID, failedEngines) {
+				slog.Info("Skipping service of an unreachable container engine.", "topic-id", staleTopicID)
+				continue
 			}
-
-			if err := tedgeClient.DeregisterEntity(*target); err != nil {
-				slog.Warn("Failed to deregister entity.", "err", err)
+			slog.Info("Removing stale service.", "topic-id", staleTopicID)
+			if !a.removeService(staleTopicID) {
+				cloudSyncPending = true
+				pendingCloudDeletions = append(pendingCloudDeletions, staleTopicID)
+				continue
 			}
 			staleServicesRemoved = true
 			delete(entities, staleTopicID)
@@ -1083,11 +1384,16 @@ func (a *App) doUpdate(filterOptions container.FilterOptions) error {
 	if cloudSyncPending {
 		a.scheduleSyncRetry()
 	}
+	if removeStaleServices {
+		a.syncStateMu.Lock()
+		a.syncState.PendingCloudDeletions = pendingCloudDeletions
+		a.syncStateMu.Unlock()
+	}
 
 	// Update tedge-agent log types
 	if err := a.client.SyncLogTypes(tedgeClient.Target); err != nil {
 		slog.Warn("Failed to send tedge-agent sync request to update the log types", "err", err)
 	}
 
-	return nil
+	return updateErr
 }
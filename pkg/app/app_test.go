@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// newFakeEngine returns an engine whose api lists the given containers, and
+// returns the given inspect responses (by container id)
+func newFakeEngine(t *testing.T, containers []containerSDK.Summary, inspect map[string]containerSDK.InspectResponse) *containerEngine {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			_ = json.NewEncoder(w).Encode(containers)
+		case strings.HasSuffix(r.URL.Path, "/json") && strings.Contains(r.URL.Path, "/containers/"):
+			resp, ok := inspect[path.Base(path.Dir(r.URL.Path))]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_ = json.NewEncoder(w).Encode(map[string]string{"message": "No such container"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	dockerClient, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")),
+		client.WithHTTPClient(server.Client()),
+		client.WithVersion("1.41"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &containerEngine{}
+	engine.client.Store(&container.ContainerClient{Client: dockerClient})
+	return engine
+}
+
+// newTestApp returns an app which uses the (optional) fake engine and mqtt client
+func newTestApp(t *testing.T, engine *containerEngine, mqttClient *fakeMQTTClient) *App {
+	a := &App{
+		Device:                  tedge.NewTarget("te", "device/main//"),
+		restartBaseline:         make(map[string]int),
+		crashLoopAlarms:         make(map[string]struct{}),
+		retainedCrashLoopAlarms: make(map[string]struct{}),
+		eventLimiter:            NewEventRateLimiter(5 * time.Second),
+		publishCache:            newPublishCache(time.Hour),
+	}
+	if engine != nil {
+		a.engines = []*containerEngine{engine}
+	}
+	if mqttClient != nil {
+		a.client = newFakeTedgeClient(t, mqttClient)
+	}
+	return a
+}
+
+// fakeMQTTClient records the published messages, along with the requests to the fake
+// thin-edge.io api (see newFakeTedgeClient) so that the order of the calls can be checked
+type fakeMQTTClient struct {
+	mqtt.Client
+	mu        sync.Mutex
+	published map[string]string
+	calls     []string
+	notify    chan string
+	// httpErrors are the status codes returned by the api by request, e.g. "PUT /te/v1/entities/..."
+	httpErrors map[string]int
+}
+
+func newFakeMQTTClient() *fakeMQTTClient {
+	return &fakeMQTTClient{
+		published:  make(map[string]string),
+		notify:     make(chan string, 100),
+		httpErrors: make(map[string]int),
+	}
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch v := payload.(type) {
+	case string:
+		c.published[topic] = v
+	case []byte:
+		c.published[topic] = string(v)
+	}
+	c.calls = append(c.calls, "PUBLISH "+topic)
+	select {
+	case c.notify <- topic:
+	default:
+	}
+	return &mqtt.DummyToken{}
+}
+
+// Message returns the last message published on a topic
+func (c *fakeMQTTClient) Message(topic string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	payload, ok := c.published[topic]
+	return payload, ok
+}
+
+// Calls returns the publishes and api requests in the order they were made
+func (c *fakeMQTTClient) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.calls...)
+}
+
+func (c *fakeMQTTClient) request(r *http.Request) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call := r.Method + " " + r.URL.Path
+	c.calls = append(c.calls, call)
+	if code, ok := c.httpErrors[call]; ok {
+		return code
+	}
+	if r.Method == http.MethodPost {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// newFakeTedgeClient returns a tedge client which publishes to the fake mqtt client,
+// and whose http api accepts all requests (unless configured otherwise)
+func newFakeTedgeClient(t *testing.T, mqttClient *fakeMQTTClient) *tedge.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(mqttClient.request(r))
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return &tedge.Client{
+		Client: mqttClient,
+		TedgeAPI: &tedge.TedgeAPIClient{
+			Client:  server.Client(),
+			BaseURL: server.URL,
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// RemoveServiceOptions identifies the service of a removed container
+type RemoveServiceOptions struct {
+	ContainerID string
+	// ServiceName is used if the container is not in the index
+	ServiceName   string
+	FilterOptions container.FilterOptions
+}
+
+func NewRemoveServiceAction(opts RemoveServiceOptions) ActionRequest {
+	return ActionRequest{
+		Action:  ActionRemoveService,
+		Options: opts,
+	}
+}
+
+// updateContainerIndex records the container id → service name mapping of the services
+// in the entity store and the listed containers. A full update replaces the index so that
+// removed containers do not accumulate.
+func (a *App) updateContainerIndex(entities map[string]tedge.Entity, items []container.TedgeContainer, full bool) {
+	a.containerIndexMu.Lock()
+	defer a.containerIndexMu.Unlock()
+	if full {
+		a.containerIndex = make(map[string]string)
+	}
+	for _, entity := range entities {
+		if entity.ContainerID == "" || (entity.Type != container.ContainerType && entity.Type != container.ContainerGroupType) {
+			continue
+		}
+		if _, exists := a.containerIndex[entity.ContainerID]; !exists {
+			a.containerIndex[entity.ContainerID] = entity.Name
+		}
+	}
+	for _, item := range items {
+		a.containerIndex[item.Container.Id] = item.Name
+	}
+}
+
+func (a *App) lookupServiceName(containerID string) string {
+	a.containerIndexMu.Lock()
+	defer a.containerIndexMu.Unlock()
+	return a.containerIndex[containerID]
+}
+
+// enqueueRemoveService sends the removal to the worker (bypassing the debouncer as removals
+// can't be merged with updates). A full update is used if the worker queue is full.
+func (a *App) enqueueRemoveService(opts RemoveServiceOptions) {
+	select {
+	case a.updateRequests <- NewRemoveServiceAction(opts):
+	default:
+		slog.Warn("Worker queue is full. Falling back to a full update.", "container", opts.ContainerID)
+		a.debouncer.Enqueue(NewUpdateAllAction(opts.FilterOptions))
+	}
+}
+
+// removeService removes a service from the cloud (first) and then from thin-edge.
+// If the cloud deletion fails then the service is left registered in thin-edge so that
+// it is re-detected as stale by the next update, and false is returned.
+func (a *App) removeService(topicID string) bool {
+	target := tedge.NewTarget(a.Device.RootPrefix, topicID)
+
+	if a.config.DeleteFromCloud {
+		target.CloudIdentity = a.client.Target.CloudIdentity
+		if target.CloudIdentity != "" {
+			slog.Info("Removing service from the cloud", "topic", target.Topic())
+			if _, err := a.client.DeleteCumulocityManagedObject(*target); err != nil {
+				slog.Warn("Failed to delete managed object, will retry on next update.", "err", err, "topic", target.Topic())
+				return false
+			}
+		}
+	}
+
+	a.removeContainerOperations(*target)
+	if err := a.client.DeregisterEntity(*target); err != nil {
+		slog.Warn("Failed to deregister entity.", "err", err)
+	}
+	a.publishCache.Forget(topicID)
+	a.forgetImageUpdate(topicID)
+	a.markStateChanged()
+	return true
+}
+
+// serviceContainerIDs returns the ids of the containers which provide a service
+func serviceContainerIDs(items []container.TedgeContainer, name string) []string {
+	ids := make([]string, 0)
+	for _, item := range items {
+		if item.Name == name {
+			ids = append(ids, item.Container.Id)
+		}
+	}
+	return ids
+}
+
+// doRemoveService deregisters only the service of a removed container, rather than doing a full update.
+// The service is kept if it is still provided by another container, e.g. the container was recreated
+// or a container-group service has multiple replicas.
+func (a *App) doRemoveService(opts RemoveServiceOptions) error {
+	name := a.lookupServiceName(opts.ContainerID)
+	if name == "" {
+		name = opts.ServiceName
+	}
+	a.containerIndexMu.Lock()
+	delete(a.containerIndex, opts.ContainerID)
+	a.containerIndexMu.Unlock()
+
+	if name == "" {
+		slog.Info("Unknown service of removed container. Doing a full update.", "container", opts.ContainerID)
+		a.debouncer.Enqueue(NewUpdateAllAction(opts.FilterOptions))
+		return nil
+	}
+
+	items, err := a.listContainers(context.Background(), opts.FilterOptions)
+	if err != nil {
+		return err
+	}
+	items = a.applyServiceNamePolicy(items)
+	a.updateContainerIndex(nil, items, false)
+
+	if remaining := serviceContainerIDs(items, name); len(remaining) > 0 {
+		slog.Info("Service is still provided by another container.", "service", name, "containers", remaining)
+		scoped := opts.FilterOptions
+		scoped.IDs = remaining
+		a.debouncer.Enqueue(NewUpdateAllAction(scoped))
+		return nil
+	}
+
+	target := a.serviceTarget(name)
+	if _, err := a.client.TedgeAPI.GetEntity(context.Background(), *target); err != nil {
+		// e.g. the container was excluded by the filters, so it was never registered
+		slog.Debug("Service of removed container is not registered.", "service", name, "err", err)
+		return nil
+	}
+	slog.Info("Removing service of removed container.", "service", name, "container", opts.ContainerID, "topic-id", target.TopicID)
+	removed := a.removeService(target.TopicID)
+	deviceRemoved := true
+	if removed {
+		deviceRemoved = a.removeEmptyGroupDevice(name, items)
+	}
+
+	a.syncStateMu.Lock()
+	pending := slices.DeleteFunc(a.syncState.PendingCloudDeletions, func(v string) bool { return v == target.TopicID })
+	if !removed {
+		pending = append(pending, target.TopicID)
+	}
+	if !deviceRemoved {
+		pending = append(pending, a.groupDeviceTarget(strings.Split(name, "@")[0]).TopicID)
+	}
+	a.syncState.PendingCloudDeletions = pending
+	a.syncStateMu.Unlock()
+
+	if !removed || !deviceRemoved {
+		a.scheduleSyncRetry()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+func composeContainer(id string, project string, service string) containerSDK.Summary {
+	return containerSDK.Summary{
+		ID:     id,
+		Names:  []string{"/" + project + "-" + service + "-" + id},
+		State:  "running",
+		Labels: map[string]string{"com.docker.compose.project": project, "com.docker.compose.service": service},
+	}
+}
+
+func Test_UpdateContainerIndex(t *testing.T) {
+	a := &App{containerIndex: make(map[string]string)}
+
+	entities := map[string]tedge.Entity{
+		"device/main/service/nodered": {Name: "nodered", Type: container.ContainerType, ContainerID: "c1"},
+		"device/main/service/app@web": {Name: "app@web", Type: container.ContainerGroupType, ContainerID: "c2"},
+		"device/main/service/other":   {Name: "other", Type: "service", ContainerID: "c3"},
+		"device/main/service/no-id":   {Name: "no-id", Type: container.ContainerType},
+	}
+	items := []container.TedgeContainer{
+		{Name: "nodered-renamed", Container: container.Container{Id: "c1"}},
+		{Name: "app@web", Container: container.Container{Id: "c4"}},
+	}
+	a.updateContainerIndex(entities, items, true)
+
+	// The listed containers take precedence over the entity store
+	assert.Equal(t, "nodered-renamed", a.lookupServiceName("c1"))
+	assert.Equal(t, "app@web", a.lookupServiceName("c2"))
+	assert.Equal(t, "app@web", a.lookupServiceName("c4"))
+	// Only the container services are indexed
+	assert.Equal(t, "", a.lookupServiceName("c3"))
+	assert.Len(t, a.containerIndex, 3)
+
+	// A partial update keeps the existing entries
+	a.updateContainerIndex(nil, []container.TedgeContainer{{Name: "db", Container: container.Container{Id: "c5"}}}, false)
+	assert.Equal(t, "nodered-renamed", a.lookupServiceName("c1"))
+	assert.Equal(t, "db", a.lookupServiceName("c5"))
+
+	// A full update replaces the index, so removed containers do not accumulate
+	a.updateContainerIndex(nil, []container.TedgeContainer{{Name: "db", Container: container.Container{Id: "c5"}}}, true)
+	assert.Equal(t, map[string]string{"c5": "db"}, a.containerIndex)
+}
+
+func Test_ServiceContainerIDs(t *testing.T) {
+	items := []container.TedgeContainer{
+		{Name: "app@web", Container: container.Container{Id: "r1"}},
+		{Name: "app@web", Container: container.Container{Id: "r2"}},
+		{Name: "app@db", Container: container.Container{Id: "d1"}},
+	}
+	assert.Equal(t, []string{"r1", "r2"}, serviceContainerIDs(items, "app@web"))
+	assert.Equal(t, []string{"d1"}, serviceContainerIDs(items, "app@db"))
+	assert.Empty(t, serviceContainerIDs(items, "nodered"))
+}
+
+func Test_DoRemoveServiceKeepsScaledService(t *testing.T) {
+	// The second replica of the service is still running
+	engine := newFakeEngine(t, []containerSDK.Summary{
+		composeContainer("r2", "app", "web"),
+	}, nil)
+	dispatched := make(chan ActionRequest, 1)
+	a := &App{
+		engines:        []*containerEngine{engine},
+		containerIndex: map[string]string{"r1": "app@web", "r2": "app@web"},
+		debouncer: NewUpdateDebouncer(time.Millisecond, func(req ActionRequest) {
+			dispatched <- req
+		}),
+	}
+
+	// The tedge client is not set, so the test fails if the service is deregistered
+	err := a.doRemoveService(RemoveServiceOptions{ContainerID: "r1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"r2": "app@web"}, a.containerIndex)
+
+	select {
+	case req := <-dispatched:
+		assert.Equal(t, ActionUpdateAll, req.Action)
+		assert.Equal(t, []string{"r2"}, req.Options.(container.FilterOptions).IDs)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an update of the remaining replica")
+	}
+}
+
+func Test_DoRemoveServiceUnknownContainer(t *testing.T) {
+	dispatched := make(chan ActionRequest, 1)
+	a := &App{
+		containerIndex: make(map[string]string),
+		debouncer: NewUpdateDebouncer(time.Millisecond, func(req ActionRequest) {
+			dispatched <- req
+		}),
+	}
+
+	// The engine is not queried, a full update is done instead
+	err := a.doRemoveService(RemoveServiceOptions{
+		ContainerID:   "unknown",
+		FilterOptions: container.FilterOptions{Labels: []string{"foo"}},
+	})
+	assert.NoError(t, err)
+
+	select {
+	case req := <-dispatched:
+		assert.Equal(t, ActionUpdateAll, req.Action)
+		assert.Equal(t, []string{"foo"}, req.Options.(container.FilterOptions).Labels)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a full update")
+	}
+}
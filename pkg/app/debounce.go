@@ -2,6 +2,7 @@ package app
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/thin-edge/tedge-container-plugin/pkg/container"
@@ -91,6 +92,10 @@ type UpdateDebouncer struct {
 	timer    *time.Timer
 	delay    time.Duration
 	dispatch func(ActionRequest)
+
+	// counters exposed as metrics
+	requests   atomic.Uint64
+	dispatched atomic.Uint64
 }
 
 // NewUpdateDebouncer creates a debouncer with the given quiet-period delay.
@@ -106,6 +111,7 @@ func NewUpdateDebouncer(delay time.Duration, dispatch func(ActionRequest)) *Upda
 // Enqueue adds req to the pending set. If a request is already waiting it is
 // merged with req and the debounce timer is reset.
 func (d *UpdateDebouncer) Enqueue(req ActionRequest) {
+	d.requests.Add(1)
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -129,6 +135,13 @@ func (d *UpdateDebouncer) fire() {
 	d.timer = nil
 	d.mu.Unlock()
 	if r != nil {
+		d.dispatched.Add(1)
 		d.dispatch(*r)
 	}
 }
+
+// Counts returns the total number of enqueued requests, and the number of
+// (merged) requests which were dispatched
+func (d *UpdateDebouncer) Counts() (requests uint64, dispatched uint64) {
+	return d.requests.Load(), d.dispatched.Load()
+}
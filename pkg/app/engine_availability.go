@@ -0,0 +1,211 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// AlarmTypeEngineUnavailable is the alarm raised when a container engine can't be reached for a prolonged time
+const AlarmTypeEngineUnavailable = "ContainerEngineUnavailable"
+
+// engineAvailability tracks the unavailable container engines. The alarm may be retained from before
+// the plugin was restarted, so it is cleared once all of the engines are available (or kept if an engine
+// is still unavailable)
+type engineAvailability struct {
+	mu      sync.Mutex
+	checked bool
+	// since maps engine name → when the engine was first found to be unavailable
+	since map[string]time.Time
+	// errs maps engine name → the last error when connecting to the engine
+	errs map[string]error
+	// raised are the engines included in the raised alarm (empty if no alarm is raised)
+	raised []string
+	// reason is the reason of the plugin's down health status
+	reason string
+	// now returns the current time (time.Now if nil)
+	now func() time.Time
+}
+
+// updateEngineAvailability records which of the engines could not be reached (e.g. when reconnecting),
+// and raises the engine unavailable alarm once an engine has been unavailable for longer than
+// EngineUnavailableAfter. The plugin's health status is set to down while the alarm is raised, and
+// optionally the health status of the engine's services. Everything is cleared (and the services are
+// resynced) once the engines are available again.
+func (a *App) updateEngineAvailability(failed map[string]error) error {
+	if a.config.EngineUnavailableAfter <= 0 {
+		return nil
+	}
+	s := &a.engineAvailability
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	if s.since == nil {
+		s.since = make(map[string]time.Time)
+	}
+	for name := range s.since {
+		if _, ok := failed[name]; !ok {
+			delete(s.since, name)
+		}
+	}
+	for name := range failed {
+		if _, ok := s.since[name]; !ok {
+			slog.Warn("Container engine is unavailable.", "engine", name, "err", failed[name])
+			s.since[name] = now
+		}
+	}
+	s.errs = failed
+
+	unavailable := make([]string, 0)
+	for name, since := range s.since {
+		if now.Sub(since) >= a.config.EngineUnavailableAfter {
+			unavailable = append(unavailable, name)
+		}
+	}
+	slices.Sort(unavailable)
+	if len(unavailable) == 0 {
+		if s.checked && len(s.raised) == 0 {
+			return nil
+		}
+		if !s.checked && len(s.since) > 0 {
+			// Don't clear a retained alarm while the outage may still be ongoing
+			return nil
+		}
+		return a.clearEngineUnavailable()
+	}
+	if slices.Equal(unavailable, s.raised) {
+		return nil
+	}
+	return a.raiseEngineUnavailable(unavailable)
+}
+
+// raiseEngineUnavailable raises the alarm, and sets the health status of the plugin (and optionally the
+// services) to down. Callers must hold the lock
+func (a *App) raiseEngineUnavailable(engines []string) error {
+	s := &a.engineAvailability
+	errs := make([]error, 0)
+
+	details := make([]string, 0, len(engines))
+	for _, name := range engines {
+		details = append(details, fmt.Sprintf("engine=%s, err=%v", name, s.errs[name]))
+	}
+	text := fmt.Sprintf("Container engine is unavailable for more than %s. %s", a.config.EngineUnavailableAfter, strings.Join(details, "; "))
+	slog.Error("Container engine is unavailable. Raising alarm.", "engines", engines, "after", a.config.EngineUnavailableAfter)
+
+	topic := tedge.GetTopic(a.client.Target, "a", AlarmTypeEngineUnavailable)
+	payload := map[string]any{
+		"severity": "CRITICAL",
+		"text":     text,
+		"engines":  engines,
+		"time":     time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := a.client.Publish(topic, 1, true, mustMarshalJSON(payload)); err != nil {
+		return err
+	}
+	s.checked = true
+	s.raised = engines
+	s.reason = text
+
+	if err := a.publishPluginHealth(tedge.StatusDown, text); err != nil {
+		errs = append(errs, err)
+	}
+
+	if status := a.config.EngineUnavailableServiceStatus; status != "" {
+		for _, name := range a.servicesOfEngines(engines) {
+			target := a.serviceTarget(name)
+			healthPayload, err := tedge.PayloadHealthStatus(map[string]any{"reason": text}, status)
+			if err != nil {
+				continue
+			}
+			slog.Info("Marking the container service health status.", "service", name, "status", status)
+			if err := a.client.Publish(tedge.GetHealthTopic(*target), 1, true, healthPayload); err != nil {
+				errs = append(errs, fmt.Errorf("publish health of %s. %w", name, err))
+			}
+			// Republish the actual status and twin information once the engine is available again
+			a.publishCache.Forget(target.TopicID)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clearEngineUnavailable clears the alarm, and resyncs the plugin and services if the alarm was raised.
+// Callers must hold the lock
+func (a *App) clearEngineUnavailable() error {
+	s := &a.engineAvailability
+	topic := tedge.GetTopic(a.client.Target, "a", AlarmTypeEngineUnavailable)
+	if err := a.client.Publish(topic, 1, true, ""); err != nil {
+		return err
+	}
+	s.checked = true
+	if len(s.raised) == 0 {
+		return nil
+	}
+
+	slog.Info("Container engine is available again. Clearing alarm and resyncing the services.", "engines", s.raised)
+	s.raised = nil
+	s.reason = ""
+	a.debouncer.Enqueue(NewUpdateAllAction(container.FilterOptions{}))
+	return a.publishPluginHealth(tedge.StatusUp, "")
+}
+
+// republishEngineUnavailable republishes the plugin's down health status (if the engine is unavailable),
+// e.g. after reconnecting to the broker, as the client publishes "up" when connecting
+func (a *App) republishEngineUnavailable() {
+	s := &a.engineAvailability
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.raised) == 0 {
+		return
+	}
+	if err := a.publishPluginHealth(tedge.StatusDown, s.reason); err != nil {
+		slog.Warn("Could not publish the plugin health status.", "err", err)
+	}
+}
+
+// publishPluginHealth publishes the health status of the plugin service with an optional reason
+func (a *App) publishPluginHealth(status string, reason string) error {
+	payload := map[string]any{}
+	if reason != "" {
+		payload["reason"] = reason
+	}
+	b, err := tedge.PayloadHealthStatus(payload, status)
+	if err != nil {
+		return err
+	}
+	topic := tedge.GetHealthTopic(a.client.Target)
+	slog.Info("Publishing plugin health status.", "topic", topic, "status", status)
+	return a.client.Publish(topic, 1, true, b)
+}
+
+// servicesOfEngines returns the names of the known services which run on any of the engines
+func (a *App) servicesOfEngines(engines []string) []string {
+	a.containerIndexMu.Lock()
+	index := maps.Clone(a.containerIndex)
+	a.containerIndexMu.Unlock()
+
+	names := make(map[string]struct{})
+	for containerID, name := range index {
+		if a.multipleEngines() {
+			a.containerEnginesMu.Lock()
+			engine, ok := a.containerEngines[containerID]
+			a.containerEnginesMu.Unlock()
+			if !ok || !slices.Contains(engines, engine.Name()) {
+				continue
+			}
+		}
+		names[name] = struct{}{}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+func newEngineAvailabilityApp(t *testing.T, mqttClient *fakeMQTTClient, now *time.Time) *App {
+	a := newTestApp(t, nil, mqttClient)
+	a.client.Target = *tedge.NewTarget("te", "device/main/service/tedge-container-plugin")
+	a.config.EngineUnavailableAfter = time.Minute
+	a.engineAvailability.now = func() time.Time { return *now }
+	a.debouncer = NewUpdateDebouncer(time.Hour, func(req ActionRequest) {})
+	return a
+}
+
+func Test_UpdateEngineAvailability(t *testing.T) {
+	mqttClient := newFakeMQTTClient()
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	a := newEngineAvailabilityApp(t, mqttClient, &now)
+	alarmTopic := tedge.GetTopic(a.client.Target, "a", AlarmTypeEngineUnavailable)
+	healthTopic := tedge.GetHealthTopic(a.client.Target)
+	failed := map[string]error{"docker": errors.New("connection refused")}
+
+	// The alarm is not raised before the engine has been unavailable for long enough,
+	// and a retained alarm is not cleared while the outage may still be ongoing
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	assert.Empty(t, mqttClient.Calls())
+
+	// The alarm is raised, and the plugin is marked as down
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	payload, ok := mqttClient.Message(alarmTopic)
+	assert.True(t, ok)
+	assert.Contains(t, payload, `"severity":"CRITICAL"`)
+	assert.Contains(t, payload, "engine=docker, err=connection refused")
+	payload, _ = mqttClient.Message(healthTopic)
+	assert.Contains(t, payload, `"status":"down"`)
+
+	// The alarm is not republished while the same engines are unavailable
+	calls := len(mqttClient.Calls())
+	now = now.Add(time.Minute)
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	assert.Len(t, mqttClient.Calls(), calls)
+
+	// The alarm is cleared once the engine is available again
+	assert.NoError(t, a.updateEngineAvailability(map[string]error{}))
+	payload, ok = mqttClient.Message(alarmTopic)
+	assert.True(t, ok)
+	assert.Empty(t, payload)
+	payload, _ = mqttClient.Message(healthTopic)
+	assert.Contains(t, payload, `"status":"up"`)
+	assert.Empty(t, a.engineAvailability.raised)
+
+	// The unavailable time starts again after the engine was available
+	now = now.Add(time.Minute)
+	calls = len(mqttClient.Calls())
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	assert.Len(t, mqttClient.Calls(), calls)
+}
+
+func Test_UpdateEngineAvailabilityClearsRetainedAlarm(t *testing.T) {
+	mqttClient := newFakeMQTTClient()
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	a := newEngineAvailabilityApp(t, mqttClient, &now)
+	alarmTopic := tedge.GetTopic(a.client.Target, "a", AlarmTypeEngineUnavailable)
+
+	// A retained alarm (from before the restart) is cleared on the first check if all engines are available
+	assert.NoError(t, a.updateEngineAvailability(map[string]error{}))
+	assert.Equal(t, []string{"PUBLISH " + alarmTopic}, mqttClient.Calls())
+
+	// The alarm is only cleared once
+	assert.NoError(t, a.updateEngineAvailability(map[string]error{}))
+	assert.Len(t, mqttClient.Calls(), 1)
+}
+
+func Test_UpdateEngineAvailabilityDisabled(t *testing.T) {
+	mqttClient := newFakeMQTTClient()
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	a := newEngineAvailabilityApp(t, mqttClient, &now)
+	a.config.EngineUnavailableAfter = 0
+
+	failed := map[string]error{"docker": errors.New("connection refused")}
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	now = now.Add(time.Hour)
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	assert.Empty(t, mqttClient.Calls())
+}
+
+func Test_UpdateEngineAvailabilityServiceStatus(t *testing.T) {
+	mqttClient := newFakeMQTTClient()
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	a := newEngineAvailabilityApp(t, mqttClient, &now)
+	a.config.EngineUnavailableServiceStatus = "unknown"
+	a.containerIndex = map[string]string{"c1": "nodered"}
+	target := a.serviceTarget("nodered")
+	a.publishCache.Record(healthCacheKey(target.TopicID), "abc")
+
+	failed := map[string]error{"docker": errors.New("connection refused")}
+	assert.NoError(t, a.updateEngineAvailability(failed))
+	now = now.Add(time.Minute)
+	assert.NoError(t, a.updateEngineAvailability(failed))
+
+	payload, ok := mqttClient.Message(tedge.GetHealthTopic(*target))
+	assert.True(t, ok)
+	assert.Contains(t, payload, `"status":"unknown"`)
+	// The actual status is republished once the engine is available again
+	assert.Empty(t, a.publishCache.Snapshot())
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// AlarmTypeEngineDiskUsage is the alarm raised when the filesystem of the engine's data root is almost full
+const AlarmTypeEngineDiskUsage = "ContainerEngineDiskUsage"
+
+// MeasurementTypeEngineUsage is the measurement type of the engine-wide usage
+const MeasurementTypeEngineUsage = "container_engine"
+
+// containerStates are the container states which are always included in the measurements
+var containerStates = []string{"created", "running", "paused", "restarting", "exited", "dead"}
+
+// DiskUsageThresholds are the used space (in percent) of the engine's data root filesystem at which the
+// disk usage alarm is raised with the given severity. A threshold of 0 is disabled
+type DiskUsageThresholds struct {
+	Critical float64
+	Major    float64
+	Minor    float64
+}
+
+// Severity returns the alarm severity for the used space, or an empty string if no threshold is crossed
+func (t DiskUsageThresholds) Severity(usedPercent float64) string {
+	switch {
+	case t.Critical > 0 && usedPercent >= t.Critical:
+		return "CRITICAL"
+	case t.Major > 0 && usedPercent >= t.Major:
+		return "MAJOR"
+	case t.Minor > 0 && usedPercent >= t.Minor:
+		return "MINOR"
+	}
+	return ""
+}
+
+// engineDiskAlarm tracks the severity of the raised disk usage alarm. The alarm is always
+// published (raised or cleared) on the first check, as it may be retained from before the
+// plugin was restarted
+type engineDiskAlarm struct {
+	mu       sync.Mutex
+	checked  bool
+	severity string
+	// unreadable are the engines whose data root could not be read, so that the failure
+	// is only logged once (until it can be read again)
+	unreadable map[string]struct{}
+}
+
+// Unreadable records whether the data root of an engine could be read, and returns true
+// if the engine's data root was readable before
+func (s *engineDiskAlarm) Unreadable(engine string, failed bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.unreadable[engine]
+	if !failed {
+		delete(s.unreadable, engine)
+		return false
+	}
+	if s.unreadable == nil {
+		s.unreadable = make(map[string]struct{})
+	}
+	s.unreadable[engine] = struct{}{}
+	return !exists
+}
+
+// Changed checks if the severity differs from the published alarm
+func (s *engineDiskAlarm) Changed(severity string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.checked || s.severity != severity
+}
+
+// Set records the severity of the published alarm
+func (s *engineDiskAlarm) Set(severity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = true
+	s.severity = severity
+}
+
+// UpdateEngineUsage publishes the engine-wide usage (containers per state, images and disk usage) of all
+// of the engines as a measurement of the plugin service, and raises the disk usage alarm when the
+// filesystem of the engine's data root is almost full
+func (a *App) UpdateEngineUsage(ctx context.Context) error {
+	errs := make([]error, 0)
+	total := &container.EngineUsage{
+		Containers: make(map[string]int),
+	}
+	// The engine with the least free space (in percent) is used for the data root values
+	var fullest *container.EngineUsage
+	fullestEngine := ""
+	fullestPercent := -1.0
+
+	for _, engine := range a.engines {
+		usage, err := engine.client.Load().GetEngineUsage(ctx, a.config.EngineDataRoot)
+		if err != nil {
+			slog.Warn("Could not read the engine usage.", "engine", engine.Name(), "err", err)
+			errs = append(errs, fmt.Errorf("engine=%s, err=%w", engine.Name(), err))
+			continue
+		}
+		for state, count := range usage.Containers {
+			total.Containers[state] += count
+		}
+		total.Images += usage.Images
+		total.ImagesSize += usage.ImagesSize
+		total.ContainersSize += usage.ContainersSize
+		total.VolumesSize += usage.VolumesSize
+		total.BuildCacheSize += usage.BuildCacheSize
+
+		if a.engineDiskAlarm.Unreadable(engine.Name(), usage.DataRootError != nil) {
+			slog.Warn("Could not read the free space of the engine's data root, so the disk usage alarm is not checked. Mount the data root and set engine_usage.data_root if the plugin runs in a container.", "engine", engine.Name(), "path", usage.DataRoot, "err", usage.DataRootError)
+		}
+
+		if percent, ok := usage.DataRootUsedPercent(); ok && percent > fullestPercent {
+			fullest, fullestEngine, fullestPercent = usage, engine.Name(), percent
+		}
+	}
+	if len(errs) == len(a.engines) {
+		return errors.Join(errs...)
+	}
+
+	containers := map[string]any{
+		"total": total.TotalContainers(),
+	}
+	for _, state := range containerStates {
+		containers[state] = total.Containers[state]
+	}
+	for state, count := range total.Containers {
+		if state != "" {
+			containers[strings.ToLower(state)] = count
+		}
+	}
+	payload := map[string]any{
+		"containers": containers,
+		"images": map[string]any{
+			"total": total.Images,
+		},
+		"disk": map[string]any{
+			"images":     total.ImagesSize,
+			"containers": total.ContainersSize,
+			"volumes":    total.VolumesSize,
+			"buildCache": total.BuildCacheSize,
+		},
+	}
+	if fullest != nil {
+		payload["dataRoot"] = map[string]any{
+			"size":        fullest.DataRootSize,
+			"used":        fullest.DataRootUsed,
+			"free":        fullest.DataRootFree,
+			"usedPercent": fullestPercent,
+		}
+	}
+	topic := tedge.GetTopic(a.client.Target, "m", MeasurementTypeEngineUsage)
+	if err := a.client.Publish(topic, 1, false, mustMarshalJSON(payload)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if fullest != nil {
+		if err := a.checkEngineDiskUsage(fullestEngine, fullest, fullestPercent); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkEngineDiskUsage raises, updates or clears the disk usage alarm of the engine's data root
+func (a *App) checkEngineDiskUsage(engine string, usage *container.EngineUsage, usedPercent float64) error {
+	severity := a.config.EngineDiskUsageThresholds.Severity(usedPercent)
+	if !a.engineDiskAlarm.Changed(severity) {
+		return nil
+	}
+
+	topic := tedge.GetTopic(a.client.Target, "a", AlarmTypeEngineDiskUsage)
+	if severity == "" {
+		slog.Info("Clearing container engine disk usage alarm.", "topic", topic, "usedPercent", usedPercent)
+		if err := a.client.Publish(topic, 1, true, ""); err != nil {
+			return err
+		}
+		a.engineDiskAlarm.Set(severity)
+		return nil
+	}
+
+	text := fmt.Sprintf("Container engine data root is %.1f%% full. free=%s, path=%s", usedPercent, units.HumanSize(float64(usage.DataRootFree)), usage.DataRoot)
+	if a.multipleEngines() {
+		text += ", engine=" + engine
+	}
+	slog.Warn("Container engine disk usage is high.", "engine", engine, "usedPercent", usedPercent, "severity", severity)
+	payload := map[string]any{
+		"severity":    severity,
+		"text":        text,
+		"path":        usage.DataRoot,
+		"usedPercent": usedPercent,
+		"free":        usage.DataRootFree,
+		"time":        time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := a.client.Publish(topic, 1, true, mustMarshalJSON(payload)); err != nil {
+		return err
+	}
+	a.engineDiskAlarm.Set(severity)
+	return nil
+}
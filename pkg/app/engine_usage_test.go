@@ -0,0 +1,28 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiskUsageThresholdsSeverity(t *testing.T) {
+	thresholds := DiskUsageThresholds{Critical: 95, Major: 90, Minor: 0}
+	assert.Equal(t, "", thresholds.Severity(85))
+	assert.Equal(t, "MAJOR", thresholds.Severity(90))
+	assert.Equal(t, "CRITICAL", thresholds.Severity(99.5))
+}
+
+func Test_EngineDiskAlarmUnreadable(t *testing.T) {
+	alarm := engineDiskAlarm{}
+
+	// The failure is only reported once per engine
+	assert.False(t, alarm.Unreadable("docker", false))
+	assert.True(t, alarm.Unreadable("docker", true))
+	assert.False(t, alarm.Unreadable("docker", true))
+	assert.True(t, alarm.Unreadable("podman", true))
+
+	// The failure is reported again after the data root could be read
+	assert.False(t, alarm.Unreadable("docker", false))
+	assert.True(t, alarm.Unreadable("docker", true))
+}
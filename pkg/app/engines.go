@@ -0,0 +1,347 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+// errEngineNotConnected is the error of an engine until the first connection attempt completes
+var errEngineNotConnected = errors.New("container engine is not connected")
+
+// engineReconnectInterval is the delay between the attempts to connect to an unavailable engine
+const engineReconnectInterval = 5 * time.Second
+
+// containerEngine is a container engine monitored by the plugin
+type containerEngine struct {
+	// host is the configured address of the engine (empty if it is auto detected)
+	host string
+	// client is stored atomically so that ReconnectContainerClient can
+	// swap it without a data race against concurrent readers (worker, metrics).
+	client atomic.Pointer[container.ContainerClient]
+
+	mu sync.Mutex
+	// err is the error of the last connection attempt (nil when the engine is connected)
+	err error
+}
+
+// Name returns the address of the engine, which is used to tag the services
+func (e *containerEngine) Name() string {
+	if e.host != "" {
+		return e.host
+	}
+	return e.client.Load().Client.DaemonHost()
+}
+
+// Err returns the error of the last connection attempt, or nil if the engine is connected
+func (e *containerEngine) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+func (e *containerEngine) clientOptions() []container.Opt {
+	opts := make([]container.Opt, 0)
+	if e.host != "" {
+		opts = append(opts, container.WithHost(e.host))
+	}
+	return opts
+}
+
+// connect creates a new client of the engine, and replaces the current one if successful.
+// The current client is kept if the engine can't be reached
+func (e *containerEngine) connect(ctx context.Context, opts ...container.Opt) error {
+	client, err := container.NewContainerClient(ctx, append(opts, e.clientOptions()...)...)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.err = err
+		return err
+	}
+	e.client.Store(client)
+	e.err = nil
+	slog.Info("Connected to container engine.", "host", e.Name(), "type", client.Engine.Type)
+	return nil
+}
+
+// newContainerEngines creates the container engines without connecting to them. Until an engine
+// is connected, its client fails any requests. An empty list of hosts uses the auto detected engine
+func newContainerEngines(hosts []string) ([]*containerEngine, error) {
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	engines := make([]*containerEngine, 0, len(hosts))
+	for _, host := range hosts {
+		client, err := container.NewUnavailableContainerClient(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container engine. host=%s, err=%w", host, err)
+		}
+		engine := &containerEngine{host: host, err: errEngineNotConnected}
+		engine.client.Store(client)
+		engines = append(engines, engine)
+	}
+	return engines, nil
+}
+
+// connectEngines connects to the engines concurrently, and returns the engines
+// which could not be reached (by name)
+func connectEngines(ctx context.Context, engines []*containerEngine, opts ...container.Opt) map[string]error {
+	wg := sync.WaitGroup{}
+	for _, engine := range engines {
+		wg.Add(1)
+		go func(engine *containerEngine) {
+			defer wg.Done()
+			if err := engine.connect(ctx, opts...); err != nil {
+				slog.Warn("Could not connect to container engine.", "host", engine.Name(), "err", err)
+			}
+		}(engine)
+	}
+	wg.Wait()
+	return failedEngines(engines)
+}
+
+// failedEngines returns the engines which are not connected (by name)
+func failedEngines(engines []*containerEngine) map[string]error {
+	failed := make(map[string]error)
+	for _, engine := range engines {
+		if err := engine.Err(); err != nil {
+			failed[engine.Name()] = err
+		}
+	}
+	return failed
+}
+
+func (a *App) multipleEngines() bool {
+	return len(a.engines) > 1
+}
+
+// getContainerClient returns the client of the primary (first) container engine. The pointer is loaded
+// atomically so it is safe to call concurrently with ReconnectContainerClient.
+// Use clientForContainer for operations on an existing container.
+func (a *App) getContainerClient() *container.ContainerClient {
+	return a.engines[0].client.Load()
+}
+
+// recordContainerEngine records which engine a container belongs to
+func (a *App) recordContainerEngine(containerID string, engine *containerEngine) {
+	if !a.multipleEngines() || containerID == "" {
+		return
+	}
+	a.containerEnginesMu.Lock()
+	defer a.containerEnginesMu.Unlock()
+	a.containerEngines[containerID] = engine
+}
+
+func (a *App) forgetContainerEngine(containerID string) {
+	a.containerEnginesMu.Lock()
+	defer a.containerEnginesMu.Unlock()
+	delete(a.containerEngines, containerID)
+}
+
+// clientForContainer returns the client of the engine which runs the container. The primary
+// engine is used if the container is unknown
+func (a *App) clientForContainer(containerID string) *container.ContainerClient {
+	if a.multipleEngines() {
+		a.containerEnginesMu.Lock()
+		engine, ok := a.containerEngines[containerID]
+		a.containerEnginesMu.Unlock()
+		if ok {
+			return engine.client.Load()
+		}
+	}
+	return a.getContainerClient()
+}
+
+// containerEngineName returns the name of the engine which runs the container, or an empty
+// string if only one engine is monitored
+func (a *App) containerEngineName(containerID string) string {
+	if !a.multipleEngines() {
+		return ""
+	}
+	a.containerEnginesMu.Lock()
+	defer a.containerEnginesMu.Unlock()
+	if engine, ok := a.containerEngines[containerID]; ok {
+		return engine.Name()
+	}
+	return ""
+}
+
+// listContainers lists the containers of all of the reachable engines. The containers are tagged with their
+// engine if more than one engine is monitored. The list only fails if none of the engines can be reached.
+func (a *App) listContainers(ctx context.Context, filterOptions container.FilterOptions) ([]container.TedgeContainer, error) {
+	items, failed, err := a.listEngineContainers(ctx, filterOptions)
+	if err != nil {
+		return nil, err
+	}
+	for name, engineErr := range failed {
+		slog.Warn("Could not list the containers of the engine.", "engine", name, "err", engineErr)
+	}
+	return items, nil
+}
+
+// listEngineContainers lists the containers of all of the engines, and returns the engines which
+// could not be reached (by name), so that the services of the unreachable engines are not removed.
+// An error is returned if none of the engines can be reached.
+func (a *App) listEngineContainers(ctx context.Context, filterOptions container.FilterOptions) ([]container.TedgeContainer, map[string]error, error) {
+	failed := make(map[string]error)
+	if !a.multipleEngines() {
+		items, err := a.getContainerClient().List(ctx, filterOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		return items, failed, nil
+	}
+
+	out := make([]container.TedgeContainer, 0)
+	engineOfService := make(map[string]string)
+	errs := make([]error, 0)
+	for _, engine := range a.engines {
+		items, err := engine.client.Load().List(ctx, filterOptions)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("engine=%s, err=%w", engine.Name(), err))
+			failed[engine.Name()] = err
+			continue
+		}
+		for _, item := range items {
+			// The service names must be unique, as the services are registered on the same device
+			if other, exists := engineOfService[item.Name]; exists && other != engine.Name() {
+				slog.Warn("Ignoring container as a service with the same name is provided by another engine.", "service", item.Name, "engine", engine.Name(), "other", other)
+				continue
+			}
+			engineOfService[item.Name] = engine.Name()
+			item.Container.Engine = engine.Name()
+			a.recordContainerEngine(item.Container.Id, engine)
+			out = append(out, item)
+		}
+	}
+	if len(failed) == len(a.engines) {
+		return nil, nil, errors.Join(errs...)
+	}
+	return out, failed, nil
+}
+
+// isContainerOfReachableEngine checks if the container was last seen on an engine which could be reached.
+// It is false if the engine of the container is unknown, e.g. the plugin was restarted while the engine
+// was unavailable
+func (a *App) isContainerOfReachableEngine(containerID string, failed map[string]error) bool {
+	if len(failed) == 0 {
+		return true
+	}
+	a.containerEnginesMu.Lock()
+	engine, ok := a.containerEngines[containerID]
+	a.containerEnginesMu.Unlock()
+	if !ok {
+		return false
+	}
+	_, isFailed := failed[engine.Name()]
+	return !isFailed
+}
+
+// monitorEvents merges the event streams of all of the engines. Each engine is monitored independently,
+// so an unreachable engine does not stop the events of the other engines. The engine of each container
+// is recorded before its event is received, so that clientForContainer can be used to act on it.
+// The context must be cancelled once the caller stops reading the events.
+func (a *App) monitorEvents(ctx context.Context, filterOptions container.FilterOptions) <-chan events.Message {
+	evtCh := make(chan events.Message)
+	for _, engine := range a.engines {
+		go a.monitorEngineEvents(ctx, engine, filterOptions, evtCh)
+	}
+	return evtCh
+}
+
+// monitorEngineEvents forwards the events of an engine until the context is cancelled. The engine
+// is reconnected when it is not connected or its event stream fails (e.g. after a podman socket restart),
+// and the containers are resynced once it is connected again
+func (a *App) monitorEngineEvents(ctx context.Context, engine *containerEngine, filterOptions container.FilterOptions, evtCh chan<- events.Message) {
+	reconnect := engine.Err() != nil
+	for {
+		if reconnect {
+			err := engine.connect(ctx, container.WithAttempts(1))
+			if ctx.Err() != nil {
+				return
+			}
+			if availabilityErr := a.updateEngineAvailability(failedEngines(a.engines)); availabilityErr != nil {
+				slog.Warn("Could not update the container engine availability.", "err", availabilityErr)
+			}
+			if err != nil {
+				slog.Info("Container engine is still unavailable. Retrying later.", "engine", engine.Name(), "err", err, "interval", engineReconnectInterval)
+				t := time.NewTimer(engineReconnectInterval)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return
+				case <-t.C:
+				}
+				continue
+			}
+			a.debouncer.Enqueue(NewUpdateAllAction(filterOptions))
+			go a.refreshPluginInfo()
+		}
+
+		err := a.forwardEngineEvents(ctx, engine, evtCh)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			slog.Info("No more events. Reconnecting to the container engine.", "engine", engine.Name())
+		} else {
+			slog.Warn("Container engine event stream stopped. Reconnecting.", "engine", engine.Name(), "err", err)
+		}
+		reconnect = true
+	}
+}
+
+// forwardEngineEvents forwards the events of the engine's event stream until the stream fails
+func (a *App) forwardEngineEvents(ctx context.Context, engine *containerEngine, evtCh chan<- events.Message) error {
+	engineEvtCh, engineErrCh := engine.client.Load().MonitorEvents(ctx)
+	slog.Info("Subscribed to container engine event stream.", "engine", engine.Name())
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case evt := <-engineEvtCh:
+			if evt.Type == events.ContainerEventType {
+				a.recordContainerEngine(evt.Actor.ID, engine)
+			}
+			select {
+			case evtCh <- evt:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case err := <-engineErrCh:
+			if err == nil {
+				// Channel closed without a value — treat as a stream closure so
+				// the engine is reconnected rather than spinning with no delay.
+				err = fmt.Errorf("event stream closed unexpectedly")
+			}
+			return err
+		}
+	}
+}
+
+// ReconnectContainerClient creates new clients using the same host
+// options as the original ones and atomically replaces the current ones, so that
+// a stale client (e.g. after a podman socket restart) does not block recovery.
+// It uses a bounded retry count so it doesn't block forever during shutdown.
+func (a *App) ReconnectContainerClient(ctx context.Context) error {
+	failed := connectEngines(ctx, a.engines, container.WithAttempts(5))
+	if len(failed) < len(a.engines) {
+		go a.refreshPluginInfo()
+	}
+	if err := a.updateEngineAvailability(failed); err != nil {
+		slog.Warn("Could not update the container engine availability.", "err", err)
+	}
+	errs := make([]error, 0, len(failed))
+	for name, err := range failed {
+		errs = append(errs, fmt.Errorf("engine=%s, err=%w", name, err))
+	}
+	return errors.Join(errs...)
+}
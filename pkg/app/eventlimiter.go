@@ -2,6 +2,7 @@ package app
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +17,8 @@ type EventRateLimiter struct {
 	mu       sync.Mutex
 	last     map[string]time.Time
 	interval time.Duration
+
+	suppressed atomic.Uint64
 }
 
 // NewEventRateLimiter creates a limiter that passes at most one event per
@@ -35,6 +38,7 @@ func (r *EventRateLimiter) Allow(key string) bool {
 	defer r.mu.Unlock()
 	now := time.Now()
 	if t, ok := r.last[key]; ok && now.Sub(t) < r.interval {
+		r.suppressed.Add(1)
 		return false
 	}
 	r.last[key] = now
@@ -49,3 +53,35 @@ func (r *EventRateLimiter) Remove(key string) {
 	defer r.mu.Unlock()
 	delete(r.last, key)
 }
+
+// Suppressed returns the total number of events which were suppressed
+func (r *EventRateLimiter) Suppressed() uint64 {
+	return r.suppressed.Load()
+}
+
+// Snapshot returns the time of the last allowed event of each key which is still
+// within the rate limit window
+func (r *EventRateLimiter) Snapshot() map[string]time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := make(map[string]time.Time, len(r.last))
+	now := time.Now()
+	for key, t := range r.last {
+		if now.Sub(t) < r.interval {
+			snapshot[key] = t
+		}
+	}
+	return snapshot
+}
+
+// Restore the rate limit history from a snapshot. Expired entries are ignored
+func (r *EventRateLimiter) Restore(snapshot map[string]time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for key, t := range snapshot {
+		if now.Sub(t) < r.interval {
+			r.last[key] = t
+		}
+	}
+}
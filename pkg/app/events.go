@@ -0,0 +1,212 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// Engine event types which can be published
+const (
+	EventTypeContainer = string(events.ContainerEventType)
+	EventTypeImage     = string(events.ImageEventType)
+	EventTypeNetwork   = string(events.NetworkEventType)
+	EventTypeVolume    = string(events.VolumeEventType)
+)
+
+// EventSelectionAll selects all of the actions of an event type
+const EventSelectionAll = "*"
+
+var ImageEventText = map[events.Action]string{
+	events.ActionPull:   "pulled",
+	events.ActionPush:   "pushed",
+	events.ActionDelete: "deleted",
+	events.ActionRemove: "removed",
+	events.ActionTag:    "tagged",
+	events.ActionUnTag:  "untagged",
+	events.ActionImport: "imported",
+	events.ActionLoad:   "loaded",
+	events.ActionSave:   "saved",
+	events.ActionPrune:  "pruned",
+}
+
+var NetworkEventText = map[events.Action]string{
+	events.ActionCreate:     "created",
+	events.ActionDestroy:    "destroyed",
+	events.ActionRemove:     "removed",
+	events.ActionConnect:    "connected",
+	events.ActionDisconnect: "disconnected",
+	events.ActionPrune:      "pruned",
+}
+
+var VolumeEventText = map[events.Action]string{
+	events.ActionCreate:  "created",
+	events.ActionDestroy: "destroyed",
+	events.ActionRemove:  "removed",
+	events.ActionMount:   "mounted",
+	events.ActionUnmount: "unmounted",
+	events.ActionPrune:   "pruned",
+}
+
+// eventText returns the text of each action per event type
+var eventText = map[string]map[events.Action]string{
+	EventTypeContainer: ContainerEventText,
+	EventTypeImage:     ImageEventText,
+	EventTypeNetwork:   NetworkEventText,
+	EventTypeVolume:    VolumeEventText,
+}
+
+// DefaultEventActions are the actions which are published if the actions of an event type are not configured.
+// Noisy actions, e.g. volume mounts (on each container start), are not included
+var DefaultEventActions = map[string][]string{
+	EventTypeContainer: {
+		string(events.ActionCreate), string(events.ActionStart), string(events.ActionStop), string(events.ActionDestroy),
+		string(events.ActionRemove), string(events.ActionDie), string(events.ActionPause), string(events.ActionUnPause),
+		string(events.ActionExecDie), string(events.ActionHealthStatusHealthy), string(events.ActionHealthStatusUnhealthy),
+		string(events.ActionOOM),
+	},
+	EventTypeImage: {
+		string(events.ActionPull), string(events.ActionPush), string(events.ActionDelete), string(events.ActionRemove),
+		string(events.ActionTag), string(events.ActionUnTag), string(events.ActionImport), string(events.ActionLoad),
+	},
+	EventTypeNetwork: {
+		string(events.ActionCreate), string(events.ActionDestroy), string(events.ActionRemove),
+		string(events.ActionConnect), string(events.ActionDisconnect),
+	},
+	EventTypeVolume: {
+		string(events.ActionCreate), string(events.ActionDestroy), string(events.ActionRemove), string(events.ActionPrune),
+	},
+}
+
+// DefaultEventTypeNames are the default templates of the thin-edge event types. The container events
+// only use the action so that the existing event types are not changed
+var DefaultEventTypeNames = map[string]string{
+	EventTypeContainer: "{action}",
+	EventTypeImage:     "image_{action}",
+	EventTypeNetwork:   "network_{action}",
+	EventTypeVolume:    "volume_{action}",
+}
+
+// EventSelector selects which engine events are published, and the thin-edge event type they are published as
+type EventSelector struct {
+	// Actions per event type. Only the event types with actions are published
+	Actions map[string][]string
+	// IncludeLabels only selects the events with at least one of the labels (if not empty)
+	IncludeLabels []string
+	// ExcludeLabels excludes the events with any of the labels
+	ExcludeLabels []string
+	// TypeNames are the templates of the thin-edge event types per event type.
+	// The "{type}" and "{action}" placeholders are replaced by the engine event's type and action
+	TypeNames map[string]string
+}
+
+// NewEventSelector creates an event selector for the event types. The default actions are
+// used for the event types without configured actions
+func NewEventSelector(types []string, actions map[string][]string, includeLabels []string, excludeLabels []string, typeNames map[string]string) *EventSelector {
+	selector := &EventSelector{
+		Actions:       make(map[string][]string),
+		IncludeLabels: includeLabels,
+		ExcludeLabels: excludeLabels,
+		TypeNames:     make(map[string]string),
+	}
+	for _, eventType := range types {
+		eventType = strings.ToLower(strings.TrimSpace(eventType))
+		if _, ok := eventText[eventType]; !ok {
+			slog.Warn("Ignoring unsupported event type.", "type", eventType)
+			continue
+		}
+		selector.Actions[eventType] = DefaultEventActions[eventType]
+		if values := actions[eventType]; len(values) > 0 {
+			selector.Actions[eventType] = values
+		}
+		selector.TypeNames[eventType] = DefaultEventTypeNames[eventType]
+		if name := strings.TrimSpace(typeNames[eventType]); name != "" {
+			selector.TypeNames[eventType] = name
+		}
+	}
+	return selector
+}
+
+// Match checks if an event should be published
+func (s *EventSelector) Match(evt events.Message) bool {
+	actions, ok := s.Actions[string(evt.Type)]
+	if !ok {
+		return false
+	}
+	if !slices.Contains(actions, EventSelectionAll) && !slices.Contains(actions, string(evt.Action)) {
+		return false
+	}
+	if len(s.IncludeLabels) > 0 && !matchLabels(evt.Actor.Attributes, s.IncludeLabels) {
+		return false
+	}
+	return !matchLabels(evt.Actor.Attributes, s.ExcludeLabels)
+}
+
+// TypeName returns the thin-edge event type of an engine event
+func (s *EventSelector) TypeName(evt events.Message) string {
+	template, ok := s.TypeNames[string(evt.Type)]
+	if !ok {
+		template = DefaultEventTypeNames[EventTypeContainer]
+	}
+	return strings.NewReplacer("{type}", string(evt.Type), "{action}", string(evt.Action)).Replace(template)
+}
+
+// matchLabels checks if the attributes include any of the labels. A label is either only a key,
+// which matches any value, or "key=value" where the value supports wildcards, e.g. "app=node*"
+func matchLabels(attributes map[string]string, labels []string) bool {
+	for _, label := range labels {
+		key, pattern, hasValue := strings.Cut(label, "=")
+		value, exists := attributes[key]
+		if !exists {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// eventActionText returns the text describing the action of an event
+func eventActionText(evt events.Message) string {
+	if text, ok := eventText[string(evt.Type)][evt.Action]; ok {
+		return text
+	}
+	return string(evt.Action)
+}
+
+// publishEngineEvent publishes a non-container (image, network or volume) engine event on the plugin service
+func (a *App) publishEngineEvent(evt events.Message) {
+	props := getEventAttributes(evt.Actor.Attributes, "name", "container")
+	name, containerID := props[0], props[1]
+	if name == "" {
+		name = evt.Actor.ID
+	}
+
+	text := fmt.Sprintf("%s %s. name=%s", evt.Type, eventActionText(evt), name)
+	if containerID != "" {
+		text = fmt.Sprintf("%s, container=%s", text, containerID)
+	}
+	payload := map[string]any{
+		"text":       text,
+		"id":         evt.Actor.ID,
+		"attributes": evt.Actor.Attributes,
+	}
+
+	key := string(evt.Type) + "/" + name + "/" + string(evt.Action)
+	if !a.eventLimiter.Allow(key) {
+		slog.Debug("Rate-limiting engine event.", "type", evt.Type, "name", name, "action", evt.Action)
+		return
+	}
+	if err := a.client.Publish(tedge.GetTopic(a.client.Target, "e", a.eventSelector.TypeName(evt)), 1, false, mustMarshalJSON(payload)); err != nil {
+		slog.Warn("Failed to publish engine event.", "type", evt.Type, "err", err)
+	}
+}
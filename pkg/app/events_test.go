@@ -0,0 +1,196 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEvent(eventType events.Type, action events.Action, attributes map[string]string) events.Message {
+	return events.Message{
+		Type:   eventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         "abc",
+			Attributes: attributes,
+		},
+	}
+}
+
+func Test_NewEventSelector(t *testing.T) {
+	selector := NewEventSelector(
+		[]string{"container", " Image ", "unknown"},
+		map[string][]string{"image": {"pull"}, "volume": {"mount"}},
+		nil,
+		nil,
+		map[string]string{"image": "img_{action}", "container": "  "},
+	)
+
+	// Unsupported event types, and the actions of the event types which are not selected, are ignored
+	assert.Len(t, selector.Actions, 2)
+	assert.Equal(t, DefaultEventActions[EventTypeContainer], selector.Actions[EventTypeContainer])
+	assert.Equal(t, []string{"pull"}, selector.Actions[EventTypeImage])
+	assert.NotContains(t, selector.Actions, EventTypeVolume)
+
+	// Blank type names use the default
+	assert.Equal(t, map[string]string{
+		EventTypeContainer: "{action}",
+		EventTypeImage:     "img_{action}",
+	}, selector.TypeNames)
+}
+
+func Test_DefaultContainerEventActions(t *testing.T) {
+	// The container events which were published before the events could be selected are still published by default
+	selector := NewEventSelector([]string{EventTypeContainer}, nil, nil, nil, nil)
+	for action := range ContainerEventText {
+		assert.True(t, selector.Match(newEvent(events.ContainerEventType, action, nil)), "action=%s", action)
+	}
+	assert.False(t, selector.Match(newEvent(events.ContainerEventType, events.ActionKill, nil)))
+	assert.False(t, selector.Match(newEvent(events.ContainerEventType, events.ActionExecStart, nil)))
+}
+
+func Test_EventSelectorMatch(t *testing.T) {
+	testcases := []struct {
+		Name          string
+		Types         []string
+		Actions       map[string][]string
+		IncludeLabels []string
+		ExcludeLabels []string
+		Event         events.Message
+		Expect        bool
+	}{
+		{
+			Name:   "default action",
+			Types:  []string{"container"},
+			Event:  newEvent(events.ContainerEventType, events.ActionStart, nil),
+			Expect: true,
+		},
+		{
+			Name:   "event type is not selected",
+			Types:  []string{"container"},
+			Event:  newEvent(events.ImageEventType, events.ActionPull, nil),
+			Expect: false,
+		},
+		{
+			Name:   "action is not selected",
+			Types:  []string{"volume"},
+			Event:  newEvent(events.VolumeEventType, events.ActionMount, nil),
+			Expect: false,
+		},
+		{
+			Name:    "configured action",
+			Types:   []string{"volume"},
+			Actions: map[string][]string{"volume": {"mount"}},
+			Event:   newEvent(events.VolumeEventType, events.ActionMount, nil),
+			Expect:  true,
+		},
+		{
+			Name:    "configured actions replace the defaults",
+			Types:   []string{"container"},
+			Actions: map[string][]string{"container": {"die"}},
+			Event:   newEvent(events.ContainerEventType, events.ActionStart, nil),
+			Expect:  false,
+		},
+		{
+			Name:    "all actions",
+			Types:   []string{"network"},
+			Actions: map[string][]string{"network": {"*"}},
+			Event:   newEvent(events.NetworkEventType, events.ActionPrune, nil),
+			Expect:  true,
+		},
+		{
+			Name:          "included label",
+			Types:         []string{"container"},
+			IncludeLabels: []string{"other", "app=node*"},
+			Event:         newEvent(events.ContainerEventType, events.ActionStart, map[string]string{"app": "nodered"}),
+			Expect:        true,
+		},
+		{
+			Name:          "missing included label",
+			Types:         []string{"container"},
+			IncludeLabels: []string{"app=node*"},
+			Event:         newEvent(events.ContainerEventType, events.ActionStart, map[string]string{"app": "mosquitto"}),
+			Expect:        false,
+		},
+		{
+			Name:          "excluded label",
+			Types:         []string{"container"},
+			ExcludeLabels: []string{"tedge.events=false"},
+			Event:         newEvent(events.ContainerEventType, events.ActionStart, map[string]string{"tedge.events": "false"}),
+			Expect:        false,
+		},
+		{
+			Name:          "excluded label takes precedence",
+			Types:         []string{"container"},
+			IncludeLabels: []string{"app"},
+			ExcludeLabels: []string{"tedge.events"},
+			Event:         newEvent(events.ContainerEventType, events.ActionStart, map[string]string{"app": "nodered", "tedge.events": "false"}),
+			Expect:        false,
+		},
+	}
+	for _, tc := range testcases {
+		selector := NewEventSelector(tc.Types, tc.Actions, tc.IncludeLabels, tc.ExcludeLabels, nil)
+		assert.Equal(t, tc.Expect, selector.Match(tc.Event), tc.Name)
+	}
+}
+
+func Test_MatchLabels(t *testing.T) {
+	attributes := map[string]string{
+		"app":          "nodered",
+		"tedge.events": "",
+	}
+	testcases := []struct {
+		Labels []string
+		Expect bool
+	}{
+		{Labels: nil, Expect: false},
+		{Labels: []string{"app"}, Expect: true},
+		{Labels: []string{"tedge.events"}, Expect: true},
+		{Labels: []string{"tedge.events="}, Expect: true},
+		{Labels: []string{"app=nodered"}, Expect: true},
+		{Labels: []string{"app=node*"}, Expect: true},
+		{Labels: []string{"app=*red"}, Expect: true},
+		{Labels: []string{"app=node"}, Expect: false},
+		{Labels: []string{"other"}, Expect: false},
+		{Labels: []string{"other", "app=node?ed"}, Expect: true},
+		// Invalid patterns don't match
+		{Labels: []string{"app=[node"}, Expect: false},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, matchLabels(attributes, tc.Labels), "labels=%v", tc.Labels)
+	}
+}
+
+func Test_EventSelectorTypeName(t *testing.T) {
+	selector := NewEventSelector(
+		[]string{"container", "image", "network", "volume"},
+		nil,
+		nil,
+		nil,
+		map[string]string{"network": "{type}_{action}_event"},
+	)
+	testcases := []struct {
+		Event  events.Message
+		Expect string
+	}{
+		{Event: newEvent(events.ContainerEventType, events.ActionStart, nil), Expect: "start"},
+		{Event: newEvent(events.ContainerEventType, events.ActionHealthStatusHealthy, nil), Expect: "health_status: healthy"},
+		{Event: newEvent(events.ImageEventType, events.ActionPull, nil), Expect: "image_pull"},
+		{Event: newEvent(events.VolumeEventType, events.ActionCreate, nil), Expect: "volume_create"},
+		{Event: newEvent(events.NetworkEventType, events.ActionConnect, nil), Expect: "network_connect_event"},
+		// Event types without a template use the container template
+		{Event: newEvent(events.DaemonEventType, events.ActionReload, nil), Expect: "reload"},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, selector.TypeName(tc.Event))
+	}
+}
+
+func Test_EventActionText(t *testing.T) {
+	assert.Equal(t, "pulled", eventActionText(newEvent(events.ImageEventType, events.ActionPull, nil)))
+	assert.Equal(t, "connected", eventActionText(newEvent(events.NetworkEventType, events.ActionConnect, nil)))
+	assert.Equal(t, "killed by the OOM killer", eventActionText(newEvent(events.ContainerEventType, events.ActionOOM, nil)))
+	// Actions without a text use the action
+	assert.Equal(t, "attach", eventActionText(newEvent(events.ContainerEventType, events.ActionAttach, nil)))
+}
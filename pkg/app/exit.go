@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// AlarmTypeExited is the alarm raised when a container which is not restarted exits with a non-zero exit code
+const AlarmTypeExited = "ContainerExited"
+
+// expectedExitWindow is how long after a kill/stop request an exit is considered to be expected
+const expectedExitWindow = 2 * time.Minute
+
+// ContainerExit describes why (and how) a container exited
+type ContainerExit struct {
+	ExitCode      *int   `json:"exitCode,omitempty"`
+	Signal        string `json:"signal,omitempty"`
+	OOMKilled     bool   `json:"oomKilled"`
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	// Uptime is the time (in seconds) the container was running before it exited
+	Uptime       *float64 `json:"uptime,omitempty"`
+	RestartCount int      `json:"restartCount"`
+
+	autoRemove  bool
+	memoryLimit int64
+	// maximumRetryCount is the maximum number of restarts of the on-failure restart policy (0 is unlimited)
+	maximumRetryCount int
+}
+
+// Restarts checks if the container is restarted by the container engine after it exited. The engine
+// gives up restarting a container with the on-failure policy once the maximum retry count is reached
+func (e ContainerExit) Restarts() bool {
+	switch containerSDK.RestartPolicyMode(e.RestartPolicy) {
+	case "", containerSDK.RestartPolicyDisabled:
+		return false
+	case containerSDK.RestartPolicyOnFailure:
+		return e.maximumRetryCount <= 0 || e.RestartCount < e.maximumRetryCount
+	}
+	return true
+}
+
+// signalNames are the common signals which terminate containers
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+}
+
+// exitSignal returns the name of the signal which terminated the process, derived from
+// the exit code using the shell convention (128 + signal number)
+func exitSignal(exitCode int) string {
+	if exitCode <= 128 || exitCode > 128+64 {
+		return ""
+	}
+	if name, ok := signalNames[exitCode-128]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", exitCode-128)
+}
+
+// inspectContainerExit collects the exit information of a container from the die event attributes
+// and the container's state. The state is only used if the container has not been restarted yet.
+func (a *App) inspectContainerExit(ctx context.Context, containerID string, attributes map[string]string) ContainerExit {
+	exit := ContainerExit{
+		OOMKilled: strings.EqualFold(attributes["oomKilled"], "true"),
+	}
+	if v, err := strconv.Atoi(attributes["exitCode"]); err == nil {
+		exit.ExitCode = &v
+	}
+
+	con, err := a.clientForContainer(containerID).Client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		slog.Debug("Could not inspect exited container.", "container", containerID, "err", err)
+	} else {
+		exit.RestartCount = con.RestartCount
+		if con.HostConfig != nil {
+			exit.RestartPolicy = string(con.HostConfig.RestartPolicy.Name)
+			exit.autoRemove = con.HostConfig.AutoRemove
+			exit.memoryLimit = con.HostConfig.Memory
+			exit.maximumRetryCount = con.HostConfig.RestartPolicy.MaximumRetryCount
+		}
+		// Note: the state is reset when the container is restarted by its restart policy
+		if con.State != nil && !con.State.Running {
+			exit.OOMKilled = exit.OOMKilled || con.State.OOMKilled
+			if exit.ExitCode == nil {
+				exitCode := con.State.ExitCode
+				exit.ExitCode = &exitCode
+			}
+			startedAt, startErr := time.Parse(time.RFC3339Nano, con.State.StartedAt)
+			finishedAt, finishErr := time.Parse(time.RFC3339Nano, con.State.FinishedAt)
+			if startErr == nil && finishErr == nil && finishedAt.After(startedAt) {
+				uptime := finishedAt.Sub(startedAt).Seconds()
+				exit.Uptime = &uptime
+			}
+		}
+	}
+
+	if exit.ExitCode != nil {
+		exit.Signal = exitSignal(*exit.ExitCode)
+	}
+	return exit
+}
+
+// handleContainerDie inspects the exited container, publishes the die event (if any) enriched with the
+// exit information, and checks if the container was killed due to running out of memory or exited
+// unexpectedly. It is run in the background, so the inspection does not block the event loop
+func (a *App) handleContainerDie(evt events.Message, payload map[string]any) {
+	exit := a.inspectContainerExit(context.Background(), evt.Actor.ID, evt.Actor.Attributes)
+	if len(payload) > 0 {
+		payload["exit"] = exit
+		if exit.ExitCode != nil {
+			payload["text"] = fmt.Sprintf("%s, exitCode=%d", payload["text"], *exit.ExitCode)
+		}
+		a.publishContainerEvent(evt, payload)
+	}
+	if serviceName := a.serviceNameFromEventAttrs(evt.Actor.Attributes); serviceName != "" {
+		a.checkOOMKilled(serviceName, evt.Actor.ID, exit)
+		a.checkUnexpectedExit(serviceName, evt.Actor.ID, exit)
+	}
+}
+
+// exitRequests records containers which were requested to stop (kill/stop events),
+// so that their exit is not reported as unexpected
+type exitRequests struct {
+	mu       sync.Mutex
+	requests map[string]time.Time
+}
+
+func (r *exitRequests) Record(containerID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.requests == nil {
+		r.requests = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for id, requestedAt := range r.requests {
+		if now.Sub(requestedAt) > expectedExitWindow {
+			delete(r.requests, id)
+		}
+	}
+	r.requests[containerID] = now
+}
+
+// Expected checks (and forgets) if the container was requested to stop recently
+func (r *exitRequests) Expected(containerID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requestedAt, ok := r.requests[containerID]
+	delete(r.requests, containerID)
+	return ok && time.Since(requestedAt) <= expectedExitWindow
+}
+
+// checkUnexpectedExit raises an alarm if a container which is not restarted by the container
+// engine exited with a non-zero exit code, without being requested to stop
+func (a *App) checkUnexpectedExit(name string, containerID string, exit ContainerExit) {
+	expected := a.exitRequests.Expected(containerID)
+	if !a.config.EnableExitAlarm || exit.ExitCode == nil || *exit.ExitCode == 0 || exit.Restarts() || exit.autoRemove {
+		return
+	}
+	if expected {
+		slog.Info("Container was stopped.", "container", name, "exitCode", *exit.ExitCode)
+		return
+	}
+
+	text := fmt.Sprintf("Container exited unexpectedly. exitCode=%d", *exit.ExitCode)
+	if exit.Signal != "" {
+		text += fmt.Sprintf(", signal=%s", exit.Signal)
+	}
+	if exit.OOMKilled {
+		text += ", oomKilled=true"
+	}
+	slog.Warn("Container exited unexpectedly.", "container", name, "exitCode", *exit.ExitCode, "signal", exit.Signal)
+
+	a.exitAlarmsMu.Lock()
+	a.exitAlarms[name] = struct{}{}
+	a.exitAlarmsMu.Unlock()
+
+	payload := map[string]any{
+		"severity":    a.config.ExitAlarmSeverity,
+		"text":        text,
+		"containerID": containerID,
+		"exit":        exit,
+		"time":        time.Now().UTC().Format(time.RFC3339),
+	}
+	topic := tedge.GetTopic(*a.serviceTarget(name), "a", AlarmTypeExited)
+	if err := a.client.Publish(topic, 1, true, mustMarshalJSON(payload)); err != nil {
+		slog.Warn("Could not publish container exit alarm.", "container", name, "err", err)
+	}
+}
+
+// clearExitAlarm clears the exit alarm of the service (e.g. when it is started again). It is a
+// no-op when no alarm is active
+func (a *App) clearExitAlarm(name string) {
+	a.exitAlarmsMu.Lock()
+	_, active := a.exitAlarms[name]
+	delete(a.exitAlarms, name)
+	a.exitAlarmsMu.Unlock()
+	if !active {
+		return
+	}
+	topic := tedge.GetTopic(*a.serviceTarget(name), "a", AlarmTypeExited)
+	slog.Info("Clearing container exit alarm.", "container", name, "topic", topic)
+	if err := a.client.Publish(topic, 1, true, ""); err != nil {
+		slog.Warn("Failed to clear container exit alarm.", "err", err)
+	}
+}
+
+// subscribeExitAlarms tracks the retained exit alarms so that alarms raised before the
+// plugin was restarted are still cleared
+func (a *App) subscribeExitAlarms() error {
+	return a.subscribeServiceAlarms(AlarmTypeExited, func(name string, active bool) {
+		if !active {
+			return
+		}
+		a.exitAlarmsMu.Lock()
+		defer a.exitAlarmsMu.Unlock()
+		a.exitAlarms[name] = struct{}{}
+	})
+}
+
+// reconcileExitAlarms clears the exit alarms of containers which were removed or are running again
+func (a *App) reconcileExitAlarms(items []container.TedgeContainer) {
+	running := make(map[string]bool, len(items))
+	for _, item := range items {
+		running[item.Name] = running[item.Name] || item.Container.State == "running"
+	}
+
+	a.exitAlarmsMu.Lock()
+	names := make([]string, 0, len(a.exitAlarms))
+	for name := range a.exitAlarms {
+		names = append(names, name)
+	}
+	a.exitAlarmsMu.Unlock()
+
+	for _, name := range names {
+		if isRunning, exists := running[name]; !exists || isRunning {
+			a.clearExitAlarm(name)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+func Test_ExitSignal(t *testing.T) {
+	testcases := []struct {
+		ExitCode int
+		Expect   string
+	}{
+		{ExitCode: 0, Expect: ""},
+		{ExitCode: 1, Expect: ""},
+		{ExitCode: 127, Expect: ""},
+		{ExitCode: 128, Expect: ""},
+		{ExitCode: 130, Expect: "SIGINT"},
+		{ExitCode: 134, Expect: "SIGABRT"},
+		{ExitCode: 137, Expect: "SIGKILL"},
+		{ExitCode: 139, Expect: "SIGSEGV"},
+		{ExitCode: 143, Expect: "SIGTERM"},
+		// Signals without a name
+		{ExitCode: 138, Expect: "SIG10"},
+		{ExitCode: 192, Expect: "SIG64"},
+		{ExitCode: 193, Expect: ""},
+		{ExitCode: 255, Expect: ""},
+		{ExitCode: -1, Expect: ""},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, exitSignal(tc.ExitCode), "exitCode=%d", tc.ExitCode)
+	}
+}
+
+func Test_ContainerExitRestarts(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Exit   ContainerExit
+		Expect bool
+	}{
+		{Name: "no restart policy", Exit: ContainerExit{}, Expect: false},
+		{Name: "restart policy no", Exit: ContainerExit{RestartPolicy: "no"}, Expect: false},
+		{Name: "always", Exit: ContainerExit{RestartPolicy: "always", RestartCount: 100}, Expect: true},
+		{Name: "unless-stopped", Exit: ContainerExit{RestartPolicy: "unless-stopped"}, Expect: true},
+		{Name: "on-failure without a maximum", Exit: ContainerExit{RestartPolicy: "on-failure", RestartCount: 100}, Expect: true},
+		{Name: "on-failure below the maximum", Exit: ContainerExit{RestartPolicy: "on-failure", RestartCount: 2, maximumRetryCount: 3}, Expect: true},
+		{Name: "on-failure reached the maximum", Exit: ContainerExit{RestartPolicy: "on-failure", RestartCount: 3, maximumRetryCount: 3}, Expect: false},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, tc.Exit.Restarts(), tc.Name)
+	}
+}
+
+func Test_ExitRequests(t *testing.T) {
+	requests := exitRequests{}
+	assert.False(t, requests.Expected("c1"))
+
+	requests.Record("c1")
+	assert.True(t, requests.Expected("c1"))
+	// A request only applies to a single exit
+	assert.False(t, requests.Expected("c1"))
+
+	// Requests are ignored after the window
+	requests.Record("c2")
+	requests.requests["c2"] = time.Now().Add(-expectedExitWindow - time.Second)
+	assert.False(t, requests.Expected("c2"))
+
+	// Expired requests are removed when a new request is recorded
+	requests.Record("c3")
+	requests.requests["c3"] = time.Now().Add(-expectedExitWindow - time.Second)
+	requests.Record("c4")
+	assert.NotContains(t, requests.requests, "c3")
+	assert.Contains(t, requests.requests, "c4")
+}
+
+func Test_InspectContainerExit(t *testing.T) {
+	started := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	stopped := containerSDK.InspectResponse{
+		ContainerJSONBase: &containerSDK.ContainerJSONBase{
+			ID:           "c1",
+			RestartCount: 2,
+			State: &containerSDK.State{
+				ExitCode:   137,
+				OOMKilled:  true,
+				StartedAt:  started.Format(time.RFC3339Nano),
+				FinishedAt: started.Add(90 * time.Second).Format(time.RFC3339Nano),
+			},
+			HostConfig: &containerSDK.HostConfig{
+				RestartPolicy: containerSDK.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3},
+				Resources:     containerSDK.Resources{Memory: 1024},
+			},
+		},
+	}
+	restarted := inspectResponse("c2", 5, containerSDK.State{Running: true, ExitCode: 0})
+	restarted.HostConfig = &containerSDK.HostConfig{AutoRemove: true, RestartPolicy: containerSDK.RestartPolicy{Name: "always"}}
+
+	a := &App{engines: []*containerEngine{newFakeEngine(t, nil, map[string]containerSDK.InspectResponse{
+		"c1": stopped,
+		"c2": restarted,
+	})}}
+
+	// The state is used if the event does not include the exit code
+	exit := a.inspectContainerExit(context.Background(), "c1", map[string]string{})
+	assert.Equal(t, 137, *exit.ExitCode)
+	assert.Equal(t, "SIGKILL", exit.Signal)
+	assert.True(t, exit.OOMKilled)
+	assert.Equal(t, 90.0, *exit.Uptime)
+	assert.Equal(t, "on-failure", exit.RestartPolicy)
+	assert.Equal(t, 2, exit.RestartCount)
+	assert.Equal(t, 3, exit.maximumRetryCount)
+	assert.Equal(t, int64(1024), exit.memoryLimit)
+	assert.True(t, exit.Restarts())
+
+	// The event attributes take precedence, and the state of a restarted container is ignored
+	exit = a.inspectContainerExit(context.Background(), "c2", map[string]string{"exitCode": "1", "oomKilled": "true"})
+	assert.Equal(t, 1, *exit.ExitCode)
+	assert.Equal(t, "", exit.Signal)
+	assert.True(t, exit.OOMKilled)
+	assert.Nil(t, exit.Uptime)
+	assert.True(t, exit.autoRemove)
+
+	// Only the event attributes are used if the container can't be inspected
+	exit = a.inspectContainerExit(context.Background(), "unknown", map[string]string{"exitCode": "143"})
+	assert.Equal(t, 143, *exit.ExitCode)
+	assert.Equal(t, "SIGTERM", exit.Signal)
+	assert.Equal(t, "", exit.RestartPolicy)
+}
+
+func Test_CheckUnexpectedExit(t *testing.T) {
+	exitCode := func(v int) *int {
+		return &v
+	}
+	testcases := []struct {
+		Name      string
+		Disabled  bool
+		Requested bool
+		Exit      ContainerExit
+		Expect    bool
+	}{
+		{Name: "non-zero exit code", Exit: ContainerExit{ExitCode: exitCode(1)}, Expect: true},
+		{Name: "signal", Exit: ContainerExit{ExitCode: exitCode(139), Signal: "SIGSEGV"}, Expect: true},
+		{Name: "on-failure gave up restarting", Exit: ContainerExit{ExitCode: exitCode(1), RestartPolicy: "on-failure", RestartCount: 3, maximumRetryCount: 3}, Expect: true},
+		{Name: "disabled", Disabled: true, Exit: ContainerExit{ExitCode: exitCode(1)}, Expect: false},
+		{Name: "zero exit code", Exit: ContainerExit{ExitCode: exitCode(0)}, Expect: false},
+		{Name: "unknown exit code", Exit: ContainerExit{}, Expect: false},
+		{Name: "restarted by the engine", Exit: ContainerExit{ExitCode: exitCode(1), RestartPolicy: "always"}, Expect: false},
+		{Name: "auto removed", Exit: ContainerExit{ExitCode: exitCode(1), autoRemove: true}, Expect: false},
+		{Name: "requested to stop", Requested: true, Exit: ContainerExit{ExitCode: exitCode(137), Signal: "SIGKILL"}, Expect: false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mqttClient := newFakeMQTTClient()
+			a := newTestApp(t, nil, mqttClient)
+			a.exitAlarms = make(map[string]struct{})
+			a.config.EnableExitAlarm = !tc.Disabled
+			a.config.ExitAlarmSeverity = "MAJOR"
+			if tc.Requested {
+				a.exitRequests.Record("c1")
+			}
+
+			a.checkUnexpectedExit("nodered", "c1", tc.Exit)
+
+			topic := tedge.GetTopic(*a.serviceTarget("nodered"), "a", AlarmTypeExited)
+			payload, raised := mqttClient.Message(topic)
+			assert.Equal(t, tc.Expect, raised)
+			assert.Equal(t, tc.Expect, len(a.exitAlarms) == 1)
+			if tc.Expect {
+				assert.Contains(t, payload, `"severity":"MAJOR"`)
+				if tc.Exit.Signal != "" {
+					assert.Contains(t, payload, "signal="+tc.Exit.Signal)
+				}
+			}
+			// The stop request is only used once
+			assert.False(t, a.exitRequests.Expected("c1"))
+		})
+	}
+}
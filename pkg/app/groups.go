@@ -0,0 +1,320 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/reubenmiller/go-c8y/pkg/c8y"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// How container-groups are represented in thin-edge.io
+const (
+	// ContainerGroupModeService registers each container-group service as a service
+	// of the main device, named "project@service"
+	ContainerGroupModeService = "service"
+	// ContainerGroupModeDevice registers each container-group as a child device
+	// with its services beneath it
+	ContainerGroupModeDevice = "device"
+)
+
+// groupDeviceIDPrefix is the prefix of the topic id of the container-group child devices,
+// so that they don't collide with other child devices
+const groupDeviceIDPrefix = "container-group-"
+
+// ContainerGroupInfo is the twin information of a container-group child device
+type ContainerGroupInfo struct {
+	Project  string   `json:"project"`
+	Services []string `json:"services"`
+	Running  int      `json:"running"`
+	Total    int      `json:"total"`
+}
+
+// ValidContainerGroupMode checks if the container-group mode is supported
+func ValidContainerGroupMode(mode string) bool {
+	return mode == ContainerGroupModeService || mode == ContainerGroupModeDevice
+}
+
+func (a *App) groupDevicesEnabled() bool {
+	return a.config.ContainerGroupMode == ContainerGroupModeDevice
+}
+
+// splitGroupServiceName splits a container-group service name, "project@service"
+func splitGroupServiceName(name string) (project string, service string, ok bool) {
+	project, service, ok = strings.Cut(name, "@")
+	return project, service, ok && project != "" && service != ""
+}
+
+// groupDeviceTarget returns the child device of a container-group
+func (a *App) groupDeviceTarget(project string) *tedge.Target {
+	target := tedge.NewTarget(a.Device.RootPrefix, "device/"+groupDeviceIDPrefix+project+"//")
+	target.CloudIdentity = a.Device.CloudIdentity
+	target.Name = project
+	return target
+}
+
+// serviceTarget returns the thin-edge.io entity of a container or container-group service
+func (a *App) serviceTarget(name string) *tedge.Target {
+	if a.groupDevicesEnabled() {
+		if project, service, ok := splitGroupServiceName(name); ok {
+			return a.groupDeviceTarget(project).Service(service)
+		}
+	}
+	return a.Device.Service(name)
+}
+
+// serviceParentTopicID returns the topic id of the parent of a service entity
+func (a *App) serviceParentTopicID(name string) string {
+	if a.groupDevicesEnabled() {
+		if project, _, ok := splitGroupServiceName(name); ok {
+			return a.groupDeviceTarget(project).TopicID
+		}
+	}
+	return a.client.Parent.TopicID
+}
+
+// serviceTopicPattern returns the (wildcard) target matching all of the services managed by the plugin.
+// Use serviceNameFromTopicID to get the service name from a matching topic.
+func (a *App) serviceTopicPattern() *tedge.Target {
+	if a.groupDevicesEnabled() {
+		return tedge.NewTarget(a.Device.RootPrefix, "device/+/service/+")
+	}
+	return a.Device.Service("+")
+}
+
+// serviceNameFromTopicID returns the service name (see serviceTarget) of a service topic id,
+// or an empty string if the topic id is not a service managed by the plugin
+func (a *App) serviceNameFromTopicID(topicID string) string {
+	parts := strings.Split(topicID, "/")
+	if len(parts) != 4 || parts[0] != "device" || parts[2] != "service" || parts[3] == "" {
+		return ""
+	}
+	if project, ok := strings.CutPrefix(parts[1], groupDeviceIDPrefix); ok && a.groupDevicesEnabled() {
+		return project + "@" + parts[3]
+	}
+	if parts[1] != strings.Split(a.Device.TopicID, "/")[1] {
+		return ""
+	}
+	return parts[3]
+}
+
+// serviceEntity returns the registration of a container or container-group service
+func (a *App) serviceEntity(name string, serviceType string) tedge.Entity {
+	target := a.serviceTarget(name)
+	return tedge.Entity{
+		TedgeType:    tedge.EntityTypeService,
+		TedgeTopicID: target.TopicID,
+		// The project is not included in the name of the services beneath a container-group device
+		Name:          target.Name,
+		Type:          serviceType,
+		TedgeParentID: a.serviceParentTopicID(name),
+	}
+}
+
+// groupDeviceEntity returns the registration of a container-group child device
+func (a *App) groupDeviceEntity(project string) tedge.Entity {
+	return tedge.Entity{
+		TedgeType:     tedge.EntityTypeChildDevice,
+		TedgeTopicID:  a.groupDeviceTarget(project).TopicID,
+		Name:          project,
+		Type:          container.ContainerGroupType,
+		TedgeParentID: a.client.Parent.TopicID,
+	}
+}
+
+// ensureGroupDevice registers the child device of a container-group service (if enabled)
+func (a *App) ensureGroupDevice(name string) {
+	if !a.groupDevicesEnabled() {
+		return
+	}
+	project, _, ok := splitGroupServiceName(name)
+	if !ok {
+		return
+	}
+	if _, err := a.client.TedgeAPI.CreateEntity(context.Background(), a.groupDeviceEntity(project)); err != nil {
+		slog.Warn("Could not register container-group device.", "project", project, "err", err)
+	}
+}
+
+// groupContainers groups the container-group services by project
+func groupContainers(items []container.TedgeContainer) map[string][]container.TedgeContainer {
+	groups := make(map[string][]container.TedgeContainer)
+	for _, item := range items {
+		if project, _, ok := splitGroupServiceName(item.Name); ok && item.ServiceType == container.ContainerGroupType {
+			groups[project] = append(groups[project], item)
+		}
+	}
+	return groups
+}
+
+// registerGroupDevices registers the container-group child devices and publishes their twin information
+func (a *App) registerGroupDevices(items []container.TedgeContainer, entities map[string]tedge.Entity, existingServices map[string]struct{}) {
+	for project, members := range groupContainers(items) {
+		entity := a.groupDeviceEntity(project)
+		if _, registered := existingServices[entity.TedgeTopicID]; !registered {
+			a.publishCache.Forget(entity.TedgeTopicID)
+		}
+		delete(existingServices, entity.TedgeTopicID)
+		if _, err := a.client.TedgeAPI.CreateEntity(context.Background(), entity); err != nil {
+			slog.Error("Failed to register container-group device.", "project", project, "err", err)
+		} else {
+			slog.Info("Registered container-group device.", "project", project, "topic-id", entity.TedgeTopicID)
+		}
+		entities[entity.TedgeTopicID] = entity
+
+		info := ContainerGroupInfo{
+			Project:  project,
+			Services: make([]string, 0, len(members)),
+			Total:    len(members),
+		}
+		for _, member := range members {
+			_, service, _ := splitGroupServiceName(member.Name)
+			info.Services = append(info.Services, service)
+			if member.Status == tedge.StatusUp {
+				info.Running++
+			}
+		}
+		sort.Strings(info.Services)
+		key := twinCacheKey(entity.TedgeTopicID, "containerGroup")
+		hash, changed := a.publishCache.Changed(key, info)
+		if !changed {
+			continue
+		}
+		if _, err := a.client.TedgeAPI.UpdateTwin(context.Background(), entity, "containerGroup", info); err != nil {
+			slog.Error("Could not publish container-group information.", "project", project, "err", err)
+		} else {
+			a.publishCache.Record(key, hash)
+		}
+	}
+}
+
+// removeEmptyGroupDevice removes the child device of a container-group once it has no services left
+func (a *App) removeEmptyGroupDevice(name string, items []container.TedgeContainer) bool {
+	if !a.groupDevicesEnabled() {
+		return true
+	}
+	project, _, ok := splitGroupServiceName(name)
+	if !ok {
+		return true
+	}
+	if _, exists := groupContainers(items)[project]; exists {
+		return true
+	}
+	target := a.groupDeviceTarget(project)
+	if _, err := a.client.TedgeAPI.GetEntity(context.Background(), *target); err != nil {
+		return true
+	}
+	slog.Info("Removing container-group device without services.", "project", project, "topic-id", target.TopicID)
+	return a.removeService(target.TopicID)
+}
+
+// groupResourceUsage sums the resource usage of the services of each container-group. The memory
+// percentage is calculated against the summed memory limits, as the services can have different limits
+func groupResourceUsage(usage map[string]container.StatsEntry) map[string]container.StatsEntry {
+	totals := make(map[string]container.StatsEntry)
+	for name, stats := range usage {
+		project, _, ok := splitGroupServiceName(name)
+		if !ok {
+			continue
+		}
+		total := totals[project]
+		total.CPUPercentage += stats.CPUPercentage
+		total.Memory += stats.Memory
+		total.MemoryLimit += stats.MemoryLimit
+		total.NetworkTx += stats.NetworkTx
+		totals[project] = total
+	}
+	for project, total := range totals {
+		if total.MemoryLimit > 0 {
+			total.MemoryPercentage = total.Memory / total.MemoryLimit * 100
+		}
+		totals[project] = total
+	}
+	return totals
+}
+
+// publishGroupMetrics publishes the total resource usage of the services of each container-group
+// on its child device
+func (a *App) publishGroupMetrics(usage map[string]container.StatsEntry) {
+	for project, total := range groupResourceUsage(usage) {
+		target := a.groupDeviceTarget(project)
+		payload, err := json.Marshal(container.NewContainerTelemetryMessage(total))
+		if err != nil {
+			continue
+		}
+		if err := a.client.Publish(tedge.GetTopic(*target, "m", "resource_usage"), 1, false, payload); err != nil {
+			slog.Warn("Failed to publish container-group metrics.", "project", project, "err", err)
+		}
+	}
+}
+
+// sortStaleEntities orders the entities so that the services are removed before
+// the container-group devices which they belong to
+func sortStaleEntities(topicIDs map[string]struct{}) []string {
+	out := make([]string, 0, len(topicIDs))
+	for topicID := range topicIDs {
+		out = append(out, topicID)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		iService := strings.Contains(out[i], "/service/")
+		jService := strings.Contains(out[j], "/service/")
+		if iService != jService {
+			return iService
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// deleteOrphanedGroupDevices deletes the container-group devices (and their services) from the cloud
+// which are not registered locally
+func (a *App) deleteOrphanedGroupDevices(deviceID string, tedgeEntities map[string]tedge.Entity) error {
+	refs, _, err := a.client.CumulocityClient.Inventory.GetChildDevices(context.Background(), deviceID, c8y.NewPaginationOptions(100))
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, ref := range refs.References {
+		if ref.ManagedObject.Type != container.ContainerGroupType {
+			continue
+		}
+		project := ref.ManagedObject.Name
+		deviceTarget := a.groupDeviceTarget(project)
+		_, deviceRegistered := tedgeEntities[deviceTarget.TopicID]
+
+		services, _, err := a.client.CumulocityClient.Inventory.GetChildAdditions(context.Background(), ref.ManagedObject.ID, &c8y.ManagedObjectOptions{
+			Query:             "type eq 'c8y_Service'",
+			PaginationOptions: *c8y.NewPaginationOptions(100),
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, service := range services.References {
+			target := deviceTarget.Service(service.ManagedObject.Name)
+			if _, found := tedgeEntities[target.TopicID]; found {
+				continue
+			}
+			slog.Info("Found orphaned cloud service.", "project", project, "service", service.ManagedObject.Name, "moID", service.ManagedObject.ID)
+			if _, respErr := a.client.CumulocityClient.Inventory.Delete(context.Background(), service.ManagedObject.ID); respErr != nil {
+				slog.Warn("Could not delete orphaned cloud service.", "err", respErr)
+				errs = append(errs, respErr)
+			}
+		}
+
+		if !deviceRegistered {
+			slog.Info("Found orphaned cloud container-group device.", "project", project, "moID", ref.ManagedObject.ID)
+			if _, respErr := a.client.CumulocityClient.Inventory.Delete(context.Background(), ref.ManagedObject.ID); respErr != nil {
+				slog.Warn("Could not delete orphaned cloud container-group device.", "err", respErr)
+				errs = append(errs, respErr)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
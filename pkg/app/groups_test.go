@@ -0,0 +1,28 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+func Test_GroupResourceUsage(t *testing.T) {
+	usage := map[string]container.StatsEntry{
+		"app@web":    {CPUPercentage: 10, Memory: 90, MemoryLimit: 100, MemoryPercentage: 90, NetworkTx: 1000},
+		"app@worker": {CPUPercentage: 5, Memory: 60, MemoryLimit: 900, MemoryPercentage: 6.67, NetworkTx: 500},
+		"other@db":   {CPUPercentage: 1, Memory: 0, MemoryLimit: 0},
+		"standalone": {CPUPercentage: 50, Memory: 10, MemoryLimit: 10, MemoryPercentage: 100},
+	}
+	totals := groupResourceUsage(usage)
+
+	assert.Len(t, totals, 2)
+	assert.Equal(t, 15.0, totals["app"].CPUPercentage)
+	assert.Equal(t, 150.0, totals["app"].Memory)
+	assert.Equal(t, 1000.0, totals["app"].MemoryLimit)
+	// The percentage is against the summed limits, not the sum of the percentages
+	assert.InDelta(t, 15.0, totals["app"].MemoryPercentage, 0.001)
+	assert.Equal(t, 1500.0, totals["app"].NetworkTx)
+
+	assert.Equal(t, 0.0, totals["other"].MemoryPercentage)
+}
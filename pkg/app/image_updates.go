@@ -0,0 +1,254 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/registry"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// ImageUpdateInfo is published as the "imageUpdate" twin fragment of each service
+type ImageUpdateInfo struct {
+	Image           string `json:"image"`
+	CurrentDigest   string `json:"currentDigest"`
+	LatestDigest    string `json:"latestDigest"`
+	UpdateAvailable bool   `json:"updateAvailable"`
+	AutoUpdate      bool   `json:"autoUpdate"`
+	CheckedAt       string `json:"checkedAt"`
+}
+
+// sameState checks if the info has changed, ignoring the time it was checked
+func (i ImageUpdateInfo) sameState(other ImageUpdateInfo) bool {
+	i.CheckedAt = ""
+	other.CheckedAt = ""
+	return i == other
+}
+
+// CheckImageUpdates resolves the image tag of each container against its registry
+// (without pulling the image) to check if a newer image is available.
+// Containers which have opted in via the tedge.autoupdate=digest label are
+// updated if the current time is within the configured auto update window.
+func (a *App) CheckImageUpdates(ctx context.Context, filterOptions container.FilterOptions) error {
+	if !a.imageUpdatesRunning.CompareAndSwap(false, true) {
+		slog.Info("Image update check is already running.")
+		return nil
+	}
+	defer a.imageUpdatesRunning.Store(false)
+
+	items, err := a.listContainers(ctx, filterOptions)
+	if err != nil {
+		return err
+	}
+	items = a.applyServiceNamePolicy(items)
+
+	slog.Info("Checking for image updates.", "containers", len(items))
+	for _, item := range items {
+		if err := a.checkImageUpdate(ctx, item); err != nil {
+			slog.Warn("Could not check for image update.", "container", item.Name, "image", item.Container.Image, "err", err)
+		}
+	}
+	return nil
+}
+
+func (a *App) checkImageUpdate(ctx context.Context, item container.TedgeContainer) error {
+	cli := a.clientForContainer(item.Container.Id)
+	con, err := cli.Client.ContainerInspect(ctx, item.Container.Id)
+	if err != nil {
+		return err
+	}
+	imageRef := con.Config.Image
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return err
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		slog.Debug("Skipping image update check as the image is pinned by digest.", "container", item.Name, "image", imageRef)
+		return nil
+	}
+
+	imageInspect, err := cli.Client.ImageInspect(ctx, con.Image)
+	if err != nil {
+		return err
+	}
+	localDigests := make([]digest.Digest, 0)
+	for _, repoDigest := range imageInspect.RepoDigests {
+		if ref, err := reference.ParseNormalizedNamed(repoDigest); err == nil && ref.Name() == named.Name() {
+			if canonical, ok := ref.(reference.Canonical); ok {
+				localDigests = append(localDigests, canonical.Digest())
+			}
+		}
+	}
+	if len(localDigests) == 0 {
+		slog.Debug("Skipping image update check as the image does not have a registry digest.", "container", item.Name, "image", imageRef)
+		return nil
+	}
+
+	credentials := registry.Credentials{}
+	if a.config.RegistryAuthFunc != nil {
+		authCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		encoded, authErr := a.config.RegistryAuthFunc(imageRef)(authCtx, 1)
+		cancel()
+		if authErr != nil {
+			slog.Warn("Could not get registry credentials. Trying without credentials.", "image", imageRef, "err", authErr)
+		}
+		credentials = registry.CredentialsFromRegistryAuth(encoded)
+	}
+	client, err := registry.NewClient(imageRef, credentials)
+	if err != nil {
+		return err
+	}
+	repository, tag, err := registry.ParseReference(imageRef)
+	if err != nil {
+		return err
+	}
+	resolveCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	desc, err := client.Resolve(resolveCtx, repository, tag)
+	if err != nil {
+		return err
+	}
+
+	updateAvailable := true
+	for _, d := range localDigests {
+		if d == desc.Digest {
+			updateAvailable = false
+		}
+	}
+	info := ImageUpdateInfo{
+		Image:           imageRef,
+		CurrentDigest:   localDigests[0].String(),
+		LatestDigest:    desc.Digest.String(),
+		UpdateAvailable: updateAvailable,
+		AutoUpdate:      item.Container.Labels[container.LabelAutoUpdate] == container.AutoUpdateDigest,
+		CheckedAt:       time.Now().UTC().Format(time.RFC3339),
+	}
+	slog.Info("Checked for image update.", "container", item.Name, "image", imageRef, "current", info.CurrentDigest, "latest", info.LatestDigest, "updateAvailable", updateAvailable)
+	a.publishImageUpdateInfo(item.Name, info)
+
+	if !info.UpdateAvailable || !info.AutoUpdate {
+		return nil
+	}
+	if item.ServiceType != container.ContainerType {
+		slog.Info("Automatic image updates are only supported for containers.", "service", item.Name, "serviceType", item.ServiceType)
+		return nil
+	}
+	if !a.config.ImageAutoUpdateWindow.Contains(time.Now()) {
+		slog.Info("Image update is available but outside of the auto update window.", "container", item.Name, "window", a.config.ImageAutoUpdateWindow.String())
+		return nil
+	}
+	return a.autoUpdateContainer(ctx, item, imageRef, info.LatestDigest)
+}
+
+// forgetImageUpdate drops the last image update information of a removed service
+func (a *App) forgetImageUpdate(topicID string) {
+	a.imageUpdatesMu.Lock()
+	defer a.imageUpdatesMu.Unlock()
+	for name := range a.imageUpdates {
+		if a.serviceTarget(name).TopicID == topicID {
+			delete(a.imageUpdates, name)
+		}
+	}
+}
+
+// publishImageUpdateInfo updates the twin information and publishes an event
+// when a new update is detected. Unchanged information is not republished.
+func (a *App) publishImageUpdateInfo(name string, info ImageUpdateInfo) {
+	a.imageUpdatesMu.Lock()
+	previous, found := a.imageUpdates[name]
+	a.imageUpdates[name] = info
+	a.imageUpdatesMu.Unlock()
+
+	if found && previous.sameState(info) {
+		return
+	}
+
+	target := a.serviceTarget(name)
+	if _, err := a.client.TedgeAPI.UpdateTwin(context.Background(), tedge.Entity{TedgeTopicID: target.TopicID}, "imageUpdate", info); err != nil {
+		slog.Warn("Could not publish image update information.", "service", name, "err", err)
+	}
+
+	if info.UpdateAvailable && (!found || previous.LatestDigest != info.LatestDigest) {
+		a.publishImageUpdateEvent(name, "image_update_available", fmt.Sprintf("Image update available. image=%s", info.Image), info.Image, info.LatestDigest)
+	}
+}
+
+func (a *App) publishImageUpdateEvent(name string, eventType string, text string, imageRef string, latestDigest string) {
+	target := a.serviceTarget(name)
+	payload := mustMarshalJSON(map[string]any{
+		"text":   text,
+		"image":  imageRef,
+		"digest": latestDigest,
+		"time":   time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := a.client.Publish(tedge.GetTopic(*target, "e", eventType), 1, false, payload); err != nil {
+		slog.Warn("Could not publish image update event.", "service", name, "type", eventType, "err", err)
+	}
+}
+
+// autoUpdateContainer pulls the new image and replaces the container using the same clone logic
+// as the self update, so the previous container is restored if the new one does not become healthy
+func (a *App) autoUpdateContainer(ctx context.Context, item container.TedgeContainer, imageRef string, latestDigest string) error {
+	cli := a.clientForContainer(item.Container.Id)
+
+	if container.IsInsideContainer() {
+		if self, err := cli.Self(ctx); err == nil && self.ID == item.Container.Id {
+			slog.Info("Skipping automatic update of the current container. Use the self update instead.", "container", item.Name)
+			return nil
+		}
+	}
+
+	slog.Info("Automatically updating container.", "container", item.Name, "image", imageRef, "digest", latestDigest)
+	pullOptions := container.ImagePullOptions{
+		MaxAttempts: 2,
+		Wait:        5 * time.Second,
+	}
+	if a.config.RegistryAuthFunc != nil {
+		pullOptions.AuthFunc = a.config.RegistryAuthFunc(imageRef)
+	}
+	newImage, err := cli.ImagePullWithRetries(ctx, imageRef, true, pullOptions)
+	if err != nil {
+		a.publishImageUpdateEvent(item.Name, "image_auto_update_failed", fmt.Sprintf("Automatic image update failed. Could not pull image. %s", err), imageRef, latestDigest)
+		return err
+	}
+
+	if a.config.VerifyImage != nil {
+		if err := a.config.VerifyImage(ctx, cli, imageRef); err != nil {
+			a.publishImageUpdateEvent(item.Name, "image_auto_update_failed", fmt.Sprintf("Automatic image update failed. %s", err), imageRef, latestDigest)
+			return err
+		}
+	}
+
+	if err := cli.CloneContainer(ctx, item.Container.Id, container.CloneOptions{
+		Image:        imageRef,
+		HealthyAfter: a.config.ImageAutoUpdateHealthyAfter,
+	}); err != nil {
+		a.publishImageUpdateEvent(item.Name, "image_auto_update_failed", fmt.Sprintf("Automatic image update failed. %s", err), imageRef, latestDigest)
+		return err
+	}
+
+	// The clone restores the previous container (without an error) if the new container is not healthy
+	con, err := cli.Client.ContainerInspect(ctx, item.Container.Name)
+	if err != nil {
+		return err
+	}
+	if con.Image != newImage.ID {
+		slog.Warn("Automatic image update was rolled back as the new container was not healthy.", "container", item.Name, "image", imageRef)
+		a.publishImageUpdateEvent(item.Name, "image_auto_update_failed", "Automatic image update was rolled back as the new container was not healthy", imageRef, latestDigest)
+		return nil
+	}
+
+	slog.Info("Automatically updated container.", "container", item.Name, "image", imageRef, "digest", latestDigest)
+	a.publishImageUpdateEvent(item.Name, "image_auto_updated", fmt.Sprintf("Container updated to the latest image. image=%s", imageRef), imageRef, latestDigest)
+
+	// Refresh the image update information
+	a.imageUpdatesMu.Lock()
+	delete(a.imageUpdates, item.Name)
+	a.imageUpdatesMu.Unlock()
+	return nil
+}
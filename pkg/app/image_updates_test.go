@@ -0,0 +1,25 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+func Test_ForgetImageUpdate(t *testing.T) {
+	a := &App{
+		Device:       tedge.NewTarget("te", "device/main//"),
+		imageUpdates: make(map[string]ImageUpdateInfo),
+	}
+	a.imageUpdates["nodered"] = ImageUpdateInfo{Image: "nodered/node-red:latest"}
+	a.imageUpdates["app@web"] = ImageUpdateInfo{Image: "nginx:latest"}
+
+	a.forgetImageUpdate(a.serviceTarget("nodered").TopicID)
+	assert.NotContains(t, a.imageUpdates, "nodered")
+	assert.Contains(t, a.imageUpdates, "app@web")
+
+	// Unknown services are ignored
+	a.forgetImageUpdate("device/main/service/other")
+	assert.Len(t, a.imageUpdates, 1)
+}
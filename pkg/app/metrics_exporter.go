@@ -0,0 +1,194 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/openmetrics"
+)
+
+// pluginMetrics are counters of the plugin's internals
+type pluginMetrics struct {
+	updates         atomic.Uint64
+	updateErrors    atomic.Uint64
+	crashLoopAlarms atomic.Uint64
+	// durations in nanoseconds
+	updateDuration     atomic.Int64
+	lastUpdateDuration atomic.Int64
+}
+
+func (m *pluginMetrics) observeUpdate(d time.Duration, err error) {
+	m.updates.Add(1)
+	if err != nil {
+		m.updateErrors.Add(1)
+	}
+	m.updateDuration.Add(int64(d))
+	m.lastUpdateDuration.Store(int64(d))
+}
+
+// storeStatsSamples replaces the resource usage samples served by the metrics exporter
+func (a *App) storeStatsSamples(samples map[string]container.StatsEntry) {
+	a.statsSamplesMu.Lock()
+	defer a.statsSamplesMu.Unlock()
+	a.statsSamples = samples
+}
+
+// statsSample returns the last resource usage sample of a container
+func (a *App) statsSample(containerID string) (container.StatsEntry, bool) {
+	a.statsSamplesMu.Lock()
+	defer a.statsSamplesMu.Unlock()
+	stats, ok := a.statsSamples[containerID]
+	return stats, ok
+}
+
+func boolValue(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// WriteMetrics writes the container and plugin metrics in the OpenMetrics text format.
+// The resource usage is served from the samples of the last metrics update, so scraping
+// doesn't request the stats of each container from the engine.
+func (a *App) WriteMetrics(ctx context.Context, out io.Writer, filterOptions container.FilterOptions) error {
+	items, err := a.listContainers(ctx, filterOptions)
+	if err != nil {
+		return err
+	}
+	items = a.applyServiceNamePolicy(items)
+
+	a.crashLoopAlarmsMu.Lock()
+	crashLoops := make(map[string]struct{}, len(a.crashLoopAlarms))
+	for name := range a.crashLoopAlarms {
+		crashLoops[name] = struct{}{}
+	}
+	a.crashLoopAlarmsMu.Unlock()
+
+	// The container label keeps the series unique, as scaled compose services share the same service name
+	labels := func(item container.TedgeContainer, extra ...string) []openmetrics.Label {
+		return openmetrics.Labels(append([]string{
+			"service", item.Name,
+			"container", item.Container.Name,
+			"project", item.Container.ProjectName,
+			"image", item.Container.Image,
+		}, extra...)...)
+	}
+
+	w := openmetrics.NewWriter(out)
+
+	// Container state
+	w.Family("tedge_container_up", openmetrics.TypeGauge, "Whether the container service is up (1) or down (0)")
+	for _, item := range items {
+		w.Sample("tedge_container_up", labels(item, "type", item.ServiceType), boolValue(item.Status == "up"))
+	}
+	w.Family("tedge_container_state", openmetrics.TypeGauge, "Current state of the container")
+	for _, item := range items {
+		w.Sample("tedge_container_state", labels(item, "state", item.Container.State), 1)
+	}
+	w.Family("tedge_container_health", openmetrics.TypeGauge, "Current health check state of the container")
+	for _, item := range items {
+		w.Sample("tedge_container_health", labels(item, "health", container.ParseHealth(item.Container.Status)), 1)
+	}
+	w.Family("tedge_container_crash_loop", openmetrics.TypeGauge, "Whether the container is in a crash loop")
+	for _, item := range items {
+		_, inCrashLoop := crashLoops[item.Name]
+		w.Sample("tedge_container_crash_loop", labels(item), boolValue(inCrashLoop))
+	}
+
+	// Resource usage (only running containers)
+	type statsMetric struct {
+		name       string
+		metricType string
+		help       string
+		value      func(s container.StatsEntry) float64
+	}
+	statsMetrics := []statsMetric{
+		{"tedge_container_cpu_percent", openmetrics.TypeGauge, "CPU usage in percent", func(s container.StatsEntry) float64 { return s.CPUPercentage }},
+		{"tedge_container_memory_bytes", openmetrics.TypeGauge, "Memory usage in bytes (excluding the cache)", func(s container.StatsEntry) float64 { return s.Memory }},
+		{"tedge_container_memory_limit_bytes", openmetrics.TypeGauge, "Memory limit in bytes", func(s container.StatsEntry) float64 { return s.MemoryLimit }},
+		{"tedge_container_memory_percent", openmetrics.TypeGauge, "Memory usage in percent of the limit", func(s container.StatsEntry) float64 { return s.MemoryPercentage }},
+		{"tedge_container_network_receive_bytes", openmetrics.TypeCounter, "Bytes received over the network", func(s container.StatsEntry) float64 { return s.NetworkRx }},
+		{"tedge_container_network_transmit_bytes", openmetrics.TypeCounter, "Bytes transmitted over the network", func(s container.StatsEntry) float64 { return s.NetworkTx }},
+		{"tedge_container_block_read_bytes", openmetrics.TypeCounter, "Bytes read from block devices", func(s container.StatsEntry) float64 { return s.BlockRead }},
+		{"tedge_container_block_write_bytes", openmetrics.TypeCounter, "Bytes written to block devices", func(s container.StatsEntry) float64 { return s.BlockWrite }},
+		{"tedge_container_pids", openmetrics.TypeGauge, "Number of processes", func(s container.StatsEntry) float64 { return float64(s.PidsCurrent) }},
+	}
+	for _, m := range statsMetrics {
+		w.Family(m.name, m.metricType, m.help)
+		sampleName := m.name
+		if m.metricType == openmetrics.TypeCounter {
+			sampleName += "_total"
+		}
+		for _, item := range items {
+			if item.Container.State != "running" {
+				continue
+			}
+			if stats, ok := a.statsSample(item.Container.Id); ok {
+				w.Sample(sampleName, labels(item), m.value(stats))
+			}
+		}
+	}
+
+	// Plugin internals
+	w.Family("tedge_container_plugin_updates", openmetrics.TypeCounter, "Full updates (registration, twin, health and stale service removal) processed")
+	w.Sample("tedge_container_plugin_updates_total", nil, float64(a.metrics.updates.Load()))
+	w.Family("tedge_container_plugin_update_errors", openmetrics.TypeCounter, "Full updates which failed")
+	w.Sample("tedge_container_plugin_update_errors_total", nil, float64(a.metrics.updateErrors.Load()))
+	w.Family("tedge_container_plugin_update_duration_seconds", openmetrics.TypeSummary, "Duration of the full updates")
+	w.Sample("tedge_container_plugin_update_duration_seconds_count", nil, float64(a.metrics.updates.Load()))
+	w.Sample("tedge_container_plugin_update_duration_seconds_sum", nil, time.Duration(a.metrics.updateDuration.Load()).Seconds())
+	w.Family("tedge_container_plugin_last_update_duration_seconds", openmetrics.TypeGauge, "Duration of the last full update")
+	w.Sample("tedge_container_plugin_last_update_duration_seconds", nil, time.Duration(a.metrics.lastUpdateDuration.Load()).Seconds())
+
+	a.syncStateMu.Lock()
+	lastSync := a.syncState.LastSync
+	pendingDeletions := len(a.syncState.PendingCloudDeletions)
+	a.syncStateMu.Unlock()
+	if !lastSync.IsZero() {
+		w.Family("tedge_container_plugin_last_sync_timestamp_seconds", openmetrics.TypeGauge, "Time of the last full update")
+		w.Sample("tedge_container_plugin_last_sync_timestamp_seconds", nil, float64(lastSync.UnixMilli())/1000)
+	}
+	w.Family("tedge_container_plugin_pending_cloud_deletions", openmetrics.TypeGauge, "Stale services which could not be deleted from the cloud yet")
+	w.Sample("tedge_container_plugin_pending_cloud_deletions", nil, float64(pendingDeletions))
+
+	requests, dispatched := a.debouncer.Counts()
+	w.Family("tedge_container_plugin_debounced_requests", openmetrics.TypeCounter, "Update requests received by the debouncer")
+	w.Sample("tedge_container_plugin_debounced_requests_total", nil, float64(requests))
+	w.Family("tedge_container_plugin_debounced_dispatches", openmetrics.TypeCounter, "Merged update requests dispatched by the debouncer")
+	w.Sample("tedge_container_plugin_debounced_dispatches_total", nil, float64(dispatched))
+
+	w.Family("tedge_container_plugin_rate_limited_events", openmetrics.TypeCounter, "Container engine events suppressed by the rate limiter")
+	w.Sample("tedge_container_plugin_rate_limited_events_total", nil, float64(a.eventLimiter.Suppressed()))
+
+	w.Family("tedge_container_plugin_crash_loop_alarms", openmetrics.TypeCounter, "Crash loop alarms raised")
+	w.Sample("tedge_container_plugin_crash_loop_alarms_total", nil, float64(a.metrics.crashLoopAlarms.Load()))
+	w.Family("tedge_container_plugin_active_crash_loop_alarms", openmetrics.TypeGauge, "Crash loop alarms which are currently active")
+	w.Sample("tedge_container_plugin_active_crash_loop_alarms", nil, float64(len(crashLoops)))
+
+	return w.Close()
+}
+
+// MetricsHandler returns the http handler of the metrics exporter (GET /metrics)
+func (a *App) MetricsHandler(filterOptions container.FilterOptions) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		w.Header().Set("Content-Type", openmetrics.ContentType)
+		if err := a.WriteMetrics(ctx, w, filterOptions); err != nil {
+			slog.Warn("Could not write metrics.", "err", err)
+		}
+	})
+	return mux
+}
+
+// ServeMetrics serves the metrics exporter on the given address until the context is cancelled
+func (a *App) ServeMetrics(ctx context.Context, address string, filterOptions container.FilterOptions) error {
+	return serveHTTP(ctx, "metrics exporter", address, a.MetricsHandler(filterOptions))
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+func Test_StatsSamples(t *testing.T) {
+	a := &App{}
+	_, ok := a.statsSample("abc")
+	assert.False(t, ok)
+
+	a.storeStatsSamples(map[string]container.StatsEntry{
+		"abc": {CPUPercentage: 10},
+		"def": {CPUPercentage: 20},
+	})
+	stats, ok := a.statsSample("abc")
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, stats.CPUPercentage)
+
+	// The samples of the removed containers are dropped by the next update
+	a.storeStatsSamples(map[string]container.StatsEntry{
+		"def": {CPUPercentage: 30},
+	})
+	_, ok = a.statsSample("abc")
+	assert.False(t, ok)
+	stats, ok = a.statsSample("def")
+	assert.True(t, ok)
+	assert.Equal(t, 30.0, stats.CPUPercentage)
+}
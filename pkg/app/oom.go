@@ -0,0 +1,218 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// AlarmTypeOOMKilled is the alarm raised when a container is killed by the kernel OOM killer
+const AlarmTypeOOMKilled = "ContainerOOMKilled"
+
+// oomAlarmDedupWindow is the period in which an OOM kill is only reported once
+const oomAlarmDedupWindow = 10 * time.Second
+
+// recordMemoryUsage stores the last observed memory usage of a container so that
+// it can be included in the OOM alarm (the usage can't be read once the container died)
+func (a *App) recordMemoryUsage(name string, stats container.StatsEntry) {
+	if stats.IsInvalid {
+		return
+	}
+	a.lastMemoryUsageMu.Lock()
+	defer a.lastMemoryUsageMu.Unlock()
+	a.lastMemoryUsage[name] = stats
+}
+
+func (a *App) getLastMemoryUsage(name string) (container.StatsEntry, bool) {
+	a.lastMemoryUsageMu.Lock()
+	defer a.lastMemoryUsageMu.Unlock()
+	stats, ok := a.lastMemoryUsage[name]
+	return stats, ok
+}
+
+// checkOOMKilled raises the OOM alarm if the container was killed by the OOM killer
+func (a *App) checkOOMKilled(name string, containerID string, exit ContainerExit) {
+	if !exit.OOMKilled {
+		return
+	}
+	exitCode := -1
+	if exit.ExitCode != nil {
+		exitCode = *exit.ExitCode
+	}
+	slog.Warn("Container was killed by the OOM killer.", "container", name, "exitCode", exitCode, "memoryLimit", exit.memoryLimit)
+	a.publishOOMAlarm(name, containerID, exitCode, exit.memoryLimit)
+}
+
+// publishOOMAlarm raises the OOM alarm of a service. Any pending clearing of the alarm is rescheduled
+func (a *App) publishOOMAlarm(name string, containerID string, exitCode int, memoryLimit int64) {
+	a.oomAlarmsMu.Lock()
+	if raisedAt, ok := a.oomAlarmRaisedAt[name]; ok && time.Since(raisedAt) < oomAlarmDedupWindow {
+		// Already raised for the same kill (both the oom and die events are received)
+		a.oomAlarmsMu.Unlock()
+		return
+	}
+	a.oomAlarmRaisedAt[name] = time.Now()
+	if timer := a.oomAlarms[name]; timer != nil {
+		timer.Stop()
+	}
+	a.oomAlarms[name] = nil
+	a.oomAlarmsMu.Unlock()
+
+	usage, hasUsage := a.getLastMemoryUsage(name)
+	limit := float64(memoryLimit)
+	if limit <= 0 && hasUsage {
+		// No container limit, so the limit is the memory available to the engine
+		limit = usage.MemoryLimit
+	}
+
+	textParts := []string{"Container was killed by the OOM killer."}
+	if limit > 0 {
+		textParts = append(textParts, fmt.Sprintf("memoryLimit=%s", units.BytesSize(limit)))
+	}
+	if hasUsage {
+		textParts = append(textParts, fmt.Sprintf("lastMemoryUsage=%s", units.BytesSize(usage.Memory)))
+	}
+	if exitCode >= 0 {
+		textParts = append(textParts, fmt.Sprintf("exitCode=%d", exitCode))
+	}
+
+	alarm := map[string]any{
+		"severity":    "MAJOR",
+		"text":        strings.Join(textParts, " "),
+		"containerID": containerID,
+		"time":        time.Now().UTC().Format(time.RFC3339),
+	}
+	if limit > 0 {
+		alarm["memoryLimit"] = limit
+	}
+	if hasUsage {
+		alarm["memoryUsage"] = usage.Memory
+	}
+	if exitCode >= 0 {
+		alarm["exitCode"] = exitCode
+	}
+
+	topic := tedge.GetTopic(*a.serviceTarget(name), "a", AlarmTypeOOMKilled)
+	if err := a.client.Publish(topic, 1, true, mustMarshalJSON(alarm)); err != nil {
+		slog.Warn("Could not publish OOM alarm.", "container", name, "err", err)
+	}
+
+	// The alarm is published asynchronously, so the start event of a container which is restarted
+	// by its restart policy may have been processed before the alarm was recorded. The scheduled
+	// check only clears the alarm if the container is running again, and a later start event
+	// reschedules it
+	a.scheduleOOMAlarmClear(name, containerID)
+}
+
+// scheduleOOMAlarmClear clears the OOM alarm of the service once the container has been
+// running healthily for the configured period. It is a no-op if no alarm is active.
+func (a *App) scheduleOOMAlarmClear(name string, containerID string) {
+	a.oomAlarmsMu.Lock()
+	defer a.oomAlarmsMu.Unlock()
+	timer, active := a.oomAlarms[name]
+	if !active {
+		return
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	a.oomAlarms[name] = time.AfterFunc(a.config.OOMClearAfter, func() {
+		con, err := a.clientForContainer(containerID).Client.ContainerInspect(context.Background(), containerID)
+		if err != nil {
+			slog.Debug("Could not inspect container to clear the OOM alarm.", "container", name, "err", err)
+			return
+		}
+		if isRunningHealthily(con.State, a.config.OOMClearAfter) {
+			a.clearOOMAlarm(name)
+			return
+		}
+		if con.State != nil && con.State.Running && !con.State.Restarting {
+			// e.g. the health check is still starting, so check again later
+			a.scheduleOOMAlarmClear(name, containerID)
+		}
+	})
+}
+
+// clearOOMAlarm clears the OOM alarm of the service. It is a no-op when no alarm is active
+func (a *App) clearOOMAlarm(name string) {
+	a.oomAlarmsMu.Lock()
+	timer, active := a.oomAlarms[name]
+	if timer != nil {
+		timer.Stop()
+	}
+	delete(a.oomAlarms, name)
+	delete(a.oomAlarmRaisedAt, name)
+	a.oomAlarmsMu.Unlock()
+
+	if !active {
+		return
+	}
+	topic := tedge.GetTopic(*a.serviceTarget(name), "a", AlarmTypeOOMKilled)
+	slog.Info("Clearing OOM alarm.", "container", name, "topic", topic)
+	if err := a.client.Publish(topic, 1, true, ""); err != nil {
+		slog.Warn("Failed to clear OOM alarm.", "err", err)
+	}
+}
+
+// subscribeOOMAlarms tracks the retained OOM alarms so that alarms raised before the
+// plugin was restarted are still cleared
+func (a *App) subscribeOOMAlarms() error {
+	return a.subscribeServiceAlarms(AlarmTypeOOMKilled, func(name string, active bool) {
+		if !active {
+			return
+		}
+		a.oomAlarmsMu.Lock()
+		defer a.oomAlarmsMu.Unlock()
+		if _, exists := a.oomAlarms[name]; !exists {
+			a.oomAlarms[name] = nil
+		}
+	})
+}
+
+// reconcileOOMAlarms clears the OOM alarms of removed containers, and schedules
+// the clearing of the alarms of running containers
+func (a *App) reconcileOOMAlarms(items []container.TedgeContainer) {
+	byName := make(map[string]container.TedgeContainer, len(items))
+	for _, item := range items {
+		byName[item.Name] = item
+	}
+
+	a.oomAlarmsMu.Lock()
+	names := make([]string, 0, len(a.oomAlarms))
+	for name := range a.oomAlarms {
+		names = append(names, name)
+	}
+	a.oomAlarmsMu.Unlock()
+
+	for _, name := range names {
+		item, exists := byName[name]
+		if !exists {
+			slog.Info("Clearing OOM alarm of removed container.", "container", name)
+			a.clearOOMAlarm(name)
+			continue
+		}
+		a.scheduleOOMAlarmClear(name, item.Container.Id)
+	}
+}
+
+// isRunningHealthily checks if a container is running, not unhealthy, and was started at least the given duration ago
+func isRunningHealthily(state *containerSDK.State, period time.Duration) bool {
+	if state == nil || !state.Running || state.Restarting {
+		return false
+	}
+	if state.Health != nil && state.Health.Status != "" && state.Health.Status != "none" && state.Health.Status != container.ContainerStatusHealthy {
+		return false
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil {
+		return false
+	}
+	return time.Since(startedAt) >= period
+}
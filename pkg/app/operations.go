@@ -0,0 +1,380 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mattn/go-shellwords"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// Operations which can be requested on the container services
+const (
+	OperationContainerStart   = "container_start"
+	OperationContainerStop    = "container_stop"
+	OperationContainerRestart = "container_restart"
+	OperationContainerPause   = "container_pause"
+	OperationContainerUnpause = "container_unpause"
+	OperationContainerExec    = "container_exec"
+	// OperationContainerDiagnostics uploads a diagnostics bundle of the service's containers
+	OperationContainerDiagnostics = "container_diagnostics"
+)
+
+// containerOperations are the operations declared on each container service
+var containerOperations = []string{
+	OperationContainerStart,
+	OperationContainerStop,
+	OperationContainerRestart,
+	OperationContainerPause,
+	OperationContainerUnpause,
+	OperationContainerDiagnostics,
+}
+
+// execInlineOutputLimit is the maximum size of the container_exec output which is included in the
+// command result. Larger output is uploaded to the file transfer service
+const execInlineOutputLimit = 4 * 1024
+
+// Command status values of the thin-edge.io command flow
+const (
+	CommandStatusInit       = "init"
+	CommandStatusExecuting  = "executing"
+	CommandStatusSuccessful = "successful"
+	CommandStatusFailed     = "failed"
+)
+
+// containerOperationTimeout is the maximum duration of a container operation
+const containerOperationTimeout = 2 * time.Minute
+
+// declaredOperations tracks the services (topic ids) which have declared the container operations,
+// so that the capabilities are only published once
+type declaredOperations struct {
+	mu       sync.Mutex
+	services map[string]struct{}
+}
+
+// Add marks the service as declared, and returns false if it was already declared
+func (d *declaredOperations) Add(topicID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.services == nil {
+		d.services = make(map[string]struct{})
+	}
+	if _, exists := d.services[topicID]; exists {
+		return false
+	}
+	d.services[topicID] = struct{}{}
+	return true
+}
+
+func (d *declaredOperations) Remove(topicID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.services, topicID)
+}
+
+// supportedOperations returns the operations which are declared on the container services
+func (a *App) supportedOperations() []string {
+	if !a.config.EnableContainerOperations {
+		return nil
+	}
+	operations := append([]string{}, containerOperations...)
+	if a.config.EnableContainerExec {
+		operations = append(operations, OperationContainerExec)
+	}
+	return operations
+}
+
+// declareContainerOperations publishes the container operations as the (retained) command
+// capabilities of the service
+func (a *App) declareContainerOperations(target tedge.Target) {
+	if !a.config.EnableContainerOperations || !a.declaredOperations.Add(target.TopicID) {
+		return
+	}
+	for _, operation := range a.supportedOperations() {
+		topic := tedge.GetTopic(target, "cmd", operation)
+		if err := a.client.Publish(topic, 1, true, "{}"); err != nil {
+			slog.Warn("Could not declare container operation.", "topic", topic, "err", err)
+			a.declaredOperations.Remove(target.TopicID)
+		}
+	}
+}
+
+// removeContainerOperations clears the command capabilities of a removed service
+func (a *App) removeContainerOperations(target tedge.Target) {
+	a.declaredOperations.Remove(target.TopicID)
+	if !a.config.EnableContainerOperations {
+		return
+	}
+	for _, operation := range a.supportedOperations() {
+		if err := a.client.Publish(tedge.GetTopic(target, "cmd", operation), 1, true, ""); err != nil {
+			slog.Warn("Could not clear container operation.", "operation", operation, "err", err)
+		}
+	}
+}
+
+// subscribeContainerOperations handles the container operations requested on the services
+func (a *App) subscribeContainerOperations() error {
+	for _, operation := range a.supportedOperations() {
+		topic := tedge.GetTopic(*a.serviceTopicPattern(), "cmd", operation, "+")
+		slog.Info("Listening to container operations.", "topic", topic)
+		err := a.client.Subscribe(topic, 1, func(c mqtt.Client, m mqtt.Message) {
+			if len(m.Payload()) == 0 {
+				return
+			}
+			command := make(map[string]any)
+			if err := json.Unmarshal(m.Payload(), &command); err != nil {
+				slog.Warn("Could not parse container operation.", "topic", m.Topic(), "err", err)
+				return
+			}
+			if command["status"] != CommandStatusInit {
+				return
+			}
+			target, err := tedge.NewTargetFromTopic(m.Topic())
+			if err != nil {
+				return
+			}
+			name := a.serviceNameFromTopicID(target.TopicID)
+			if name == "" {
+				return
+			}
+			// Don't block the mqtt client's message handler
+			go a.runContainerOperation(m.Topic(), operation, name, command)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runContainerOperation executes the operation on all of the containers of the service,
+// and reports the progress using the command status
+func (a *App) runContainerOperation(topic string, operation string, name string, command map[string]any) {
+	publishStatus := func(status string, reason string) {
+		command["status"] = status
+		if reason != "" {
+			command["reason"] = reason
+		}
+		if err := a.client.Publish(topic, 1, true, mustMarshalJSON(command)); err != nil {
+			slog.Warn("Could not publish container operation status.", "topic", topic, "status", status, "err", err)
+		}
+	}
+
+	slog.Info("Executing container operation.", "operation", operation, "service", name)
+	publishStatus(CommandStatusExecuting, "")
+
+	timeout := containerOperationTimeout
+	if operation == OperationContainerExec && a.config.ContainerExecTimeout > 0 {
+		timeout = a.config.ContainerExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := a.doContainerOperation(ctx, topic, operation, name, command); err != nil {
+		slog.Warn("Container operation failed.", "operation", operation, "service", name, "err", err)
+		publishStatus(CommandStatusFailed, err.Error())
+		return
+	}
+	slog.Info("Container operation was successful.", "operation", operation, "service", name)
+	publishStatus(CommandStatusSuccessful, "")
+}
+
+func (a *App) doContainerOperation(ctx context.Context, topic string, operation string, name string, command map[string]any) error {
+	containers, err := a.serviceContainers(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no container found for the service. service=%s", name)
+	}
+	switch operation {
+	case OperationContainerExec:
+		return a.execContainerCommand(ctx, topic, containers, command)
+	case OperationContainerDiagnostics:
+		return a.uploadDiagnostics(ctx, topic, containers, command)
+	}
+
+	for _, item := range containers {
+		cli := a.clientForContainer(item.Container.Id)
+		var opErr error
+		switch operation {
+		case OperationContainerStart:
+			opErr = cli.StartContainer(ctx, item.Container.Id)
+		case OperationContainerStop:
+			opErr = cli.StopContainer(ctx, item.Container.Id)
+		case OperationContainerRestart:
+			opErr = cli.RestartContainer(ctx, item.Container.Id)
+		case OperationContainerPause:
+			opErr = cli.PauseContainer(ctx, item.Container.Id)
+		case OperationContainerUnpause:
+			opErr = cli.UnpauseContainer(ctx, item.Container.Id)
+		default:
+			return fmt.Errorf("unknown container operation. operation=%s", operation)
+		}
+		if opErr != nil {
+			return fmt.Errorf("%s failed. container=%s, err=%w", operation, item.Container.Name, opErr)
+		}
+	}
+	return nil
+}
+
+// serviceContainers returns the containers of a service (using the same service names as the registration)
+func (a *App) serviceContainers(ctx context.Context, name string) ([]container.TedgeContainer, error) {
+	items, err := a.listContainers(ctx, container.FilterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	items = a.applyServiceNamePolicy(items)
+	out := make([]container.TedgeContainer, 0)
+	for _, item := range items {
+		if item.Name == name {
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+// parseExecCommand reads the command to execute from the container_exec command. The command
+// can either be a list of arguments, or a string which is split into arguments (it is not run in a shell)
+func parseExecCommand(command map[string]any) ([]string, error) {
+	switch v := command["command"].(type) {
+	case string:
+		args, err := shellwords.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command. %w", err)
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	case []any:
+		args := make([]string, 0, len(v))
+		for _, arg := range v {
+			value, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid command. arguments must be strings")
+			}
+			args = append(args, value)
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+	return nil, fmt.Errorf("missing command")
+}
+
+// execAllowed checks if the executable is in the list of allowed commands. The executable must match
+// exactly, so a command listed by its absolute path can't be run from another directory.
+// All commands are allowed if the list is empty
+func execAllowed(args []string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	return slices.Contains(allowed, args[0])
+}
+
+// execContainerCommand runs the command of the container_exec operation in the (first running) container
+// of the service. The combined output and exit code is added to the command, or the output is uploaded
+// to the file transfer service if it is too large
+func (a *App) execContainerCommand(ctx context.Context, topic string, containers []container.TedgeContainer, command map[string]any) error {
+	args, err := parseExecCommand(command)
+	if err != nil {
+		return err
+	}
+	if !execAllowed(args, a.config.ContainerExecAllowedCommands) {
+		return fmt.Errorf("command is not allowed. command=%s", args[0])
+	}
+
+	index := slices.IndexFunc(containers, func(item container.TedgeContainer) bool {
+		return item.Container.State == "running"
+	})
+	if index < 0 {
+		return fmt.Errorf("container is not running")
+	}
+	item := containers[index]
+
+	output := container.NewLimitedBuffer(a.config.ContainerExecMaxOutput)
+	exitCode, err := a.clientForContainer(item.Container.Id).Exec(ctx, item.Container.Id, args, output)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command timed out. timeout=%s", a.config.ContainerExecTimeout)
+		}
+		return err
+	}
+
+	command["containerID"] = item.Container.Id
+	command["exitCode"] = exitCode
+	command["outputSize"] = output.Len()
+	command["truncated"] = output.Truncated()
+	if output.Len() <= execInlineOutputLimit {
+		command["output"] = strings.ToValidUTF8(string(output.Bytes()), "")
+		return nil
+	}
+
+	// Use the command id so that the file is unique
+	cmdID := topic[strings.LastIndex(topic, "/")+1:]
+	filePath := fmt.Sprintf("%s/%s/%s.log", a.config.ServiceName, OperationContainerExec, cmdID)
+	// The upload should not be limited by the (remaining) command timeout
+	uploadCtx, cancel := context.WithTimeout(context.Background(), containerOperationTimeout)
+	defer cancel()
+	url, _, err := a.client.TedgeAPI.UploadFile(uploadCtx, filePath, "text/plain", bytes.NewReader(output.Bytes()))
+	if err != nil {
+		return fmt.Errorf("could not upload the command output. %w", err)
+	}
+	command["outputUrl"] = url
+	return nil
+}
+
+// uploadDiagnostics collects the diagnostics bundle of the containers of the service, or of all of the
+// containers of the project for container-group services, and uploads it to the command's tedgeUrl
+// (or to a new file of the file transfer service)
+func (a *App) uploadDiagnostics(ctx context.Context, topic string, containers []container.TedgeContainer, command map[string]any) error {
+	cli := a.clientForContainer(containers[0].Container.Id)
+	containerIDs := make([]string, 0, len(containers))
+	if project := containers[0].Container.ProjectName; containers[0].ServiceType == container.ContainerGroupType && project != "" {
+		ids, err := cli.ListProjectContainers(ctx, project, "")
+		if err != nil {
+			return err
+		}
+		containerIDs = append(containerIDs, ids...)
+	} else {
+		for _, item := range containers {
+			containerIDs = append(containerIDs, item.Container.Id)
+		}
+	}
+
+	bundle := &bytes.Buffer{}
+	if err := cli.WriteDiagnostics(ctx, bundle, containerIDs, container.DiagnosticsOptions{
+		LogLines:   a.config.DiagnosticsLogLines,
+		ComposeDir: a.config.ComposeDir,
+	}); err != nil {
+		return fmt.Errorf("could not collect diagnostics. %w", err)
+	}
+
+	// The upload should not be limited by the (remaining) command timeout
+	uploadCtx, cancel := context.WithTimeout(context.Background(), containerOperationTimeout)
+	defer cancel()
+	if url, _ := command["tedgeUrl"].(string); url != "" {
+		if _, err := a.client.TedgeAPI.UploadToURL(uploadCtx, url, "application/gzip", bundle); err != nil {
+			return fmt.Errorf("could not upload the diagnostics. %w", err)
+		}
+	} else {
+		// Use the command id so that the file is unique
+		cmdID := topic[strings.LastIndex(topic, "/")+1:]
+		filePath := fmt.Sprintf("%s/%s/%s.tar.gz", a.config.ServiceName, OperationContainerDiagnostics, cmdID)
+		url, _, err := a.client.TedgeAPI.UploadFile(uploadCtx, filePath, "application/gzip", bundle)
+		if err != nil {
+			return fmt.Errorf("could not upload the diagnostics. %w", err)
+		}
+		command["tedgeUrl"] = url
+	}
+	command["containers"] = len(containerIDs)
+	return nil
+}
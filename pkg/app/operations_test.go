@@ -0,0 +1,73 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseExecCommand(t *testing.T) {
+	testcases := []struct {
+		Command map[string]any
+		Expect  []string
+		Error   bool
+	}{
+		{Command: map[string]any{"command": "ls -l /data"}, Expect: []string{"ls", "-l", "/data"}},
+		{Command: map[string]any{"command": `sh -c "echo hello; id"`}, Expect: []string{"sh", "-c", "echo hello; id"}},
+		{Command: map[string]any{"command": "cat '/etc/my file.conf'"}, Expect: []string{"cat", "/etc/my file.conf"}},
+		{Command: map[string]any{"command": []any{"cat", "/etc/my file.conf"}}, Expect: []string{"cat", "/etc/my file.conf"}},
+		{Command: map[string]any{"command": []any{"echo", "$HOME;", "`id`"}}, Expect: []string{"echo", "$HOME;", "`id`"}},
+
+		// Quoting errors
+		{Command: map[string]any{"command": `echo "unterminated`}, Error: true},
+		{Command: map[string]any{"command": "echo 'unterminated"}, Error: true},
+
+		// Empty or missing commands
+		{Command: map[string]any{"command": ""}, Error: true},
+		{Command: map[string]any{"command": "   "}, Error: true},
+		{Command: map[string]any{"command": []any{}}, Error: true},
+		{Command: map[string]any{}, Error: true},
+
+		// Invalid types
+		{Command: map[string]any{"command": []any{"sleep", 10}}, Error: true},
+		{Command: map[string]any{"command": 10}, Error: true},
+	}
+	for _, tc := range testcases {
+		args, err := parseExecCommand(tc.Command)
+		if tc.Error {
+			assert.Error(t, err, "command=%v", tc.Command["command"])
+			continue
+		}
+		assert.NoError(t, err, "command=%v", tc.Command["command"])
+		assert.Equal(t, tc.Expect, args, "command=%v", tc.Command["command"])
+	}
+}
+
+func Test_ExecAllowed(t *testing.T) {
+	testcases := []struct {
+		Args    []string
+		Allowed []string
+		Expect  bool
+	}{
+		// All commands are allowed if the list is empty
+		{Args: []string{"rm", "-rf", "/"}, Allowed: nil, Expect: true},
+		{Args: []string{"cat", "/etc/hosts"}, Allowed: []string{}, Expect: true},
+
+		{Args: []string{"cat", "/etc/hosts"}, Allowed: []string{"cat", "ls"}, Expect: true},
+		{Args: []string{"rm", "/etc/hosts"}, Allowed: []string{"cat", "ls"}, Expect: false},
+
+		// The executable must match exactly
+		{Args: []string{"/bin/cat", "/etc/hosts"}, Allowed: []string{"cat"}, Expect: false},
+		{Args: []string{"cat", "/etc/hosts"}, Allowed: []string{"/bin/cat"}, Expect: false},
+		{Args: []string{"/bin/cat", "/etc/hosts"}, Allowed: []string{"/bin/cat"}, Expect: true},
+		{Args: []string{"/tmp/cat", "/etc/hosts"}, Allowed: []string{"/bin/cat"}, Expect: false},
+		{Args: []string{"./cat"}, Allowed: []string{"cat"}, Expect: false},
+		{Args: []string{"CAT"}, Allowed: []string{"cat"}, Expect: false},
+
+		// Only the executable is checked, not the arguments
+		{Args: []string{"sh", "-c", "cat /etc/hosts"}, Allowed: []string{"cat"}, Expect: false},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, execAllowed(tc.Args, tc.Allowed), "args=%v, allowed=%v", tc.Args, tc.Allowed)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// PluginInfo is the information about the plugin which is published as twin information of the plugin service
+type PluginInfo struct {
+	Version string         `json:"version,omitempty"`
+	Branch  string         `json:"branch,omitempty"`
+	Config  map[string]any `json:"config,omitempty"`
+}
+
+// PublishPluginInfo publishes the information about the container engines, the compose backend and the
+// plugin (version and configuration) as twin information of the plugin service. Unchanged information
+// is only republished after the refresh interval, unless force is set (e.g. after reconnecting).
+func (a *App) PublishPluginInfo(force bool) error {
+	fragments := make(map[string]any)
+	errs := make([]error, 0)
+
+	engines := make([]container.EngineInfo, 0, len(a.engines))
+	for i, engine := range a.engines {
+		ctx, cancel := context.WithTimeout(context.Background(), tedgeAPITimeout)
+		info, err := engine.client.Load().GetEngineInfo(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("Could not read the container engine info.", "engine", engine.Name(), "err", err)
+			errs = append(errs, fmt.Errorf("engine=%s, err=%w", engine.Name(), err))
+			continue
+		}
+		if i == 0 {
+			fragments["containerEngine"] = info
+		}
+		engines = append(engines, info)
+	}
+	if a.multipleEngines() {
+		fragments["containerEngines"] = engines
+	}
+
+	if compose, err := container.GetComposeInfo(); err == nil {
+		fragments["containerCompose"] = compose
+	} else {
+		slog.Info("Could not detect the compose backend.", "err", err)
+	}
+
+	fragments["containerPlugin"] = PluginInfo{
+		Version: a.config.PluginVersion,
+		Branch:  a.config.PluginBranch,
+		Config:  a.config.PluginSettings,
+	}
+
+	topicID := a.client.Target.TopicID
+	for name, value := range fragments {
+		key := twinCacheKey(topicID, name)
+		hash, changed := a.publishCache.Changed(key, value)
+		if !changed && !force {
+			slog.Debug("Skipping unchanged plugin twin.", "name", name)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), tedgeAPITimeout)
+		_, err := a.client.TedgeAPI.UpdateTwin(ctx, tedge.Entity{TedgeTopicID: topicID}, name, value)
+		cancel()
+		if err != nil {
+			slog.Warn("Could not publish plugin twin.", "name", name, "err", err)
+			errs = append(errs, fmt.Errorf("update twin %s. %w", name, err))
+			continue
+		}
+		a.publishCache.Record(key, hash)
+	}
+	return errors.Join(errs...)
+}
+
+// refreshPluginInfo republishes the plugin information, e.g. after the plugin reconnected to
+// the broker or container engine, as the engine may have been updated in the meantime
+func (a *App) refreshPluginInfo() {
+	slog.Info("Refreshing the plugin information.")
+	if err := a.PublishPluginInfo(true); err != nil {
+		slog.Warn("Could not refresh the plugin information.", "err", err)
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publishedEntry is the fingerprint of a published message, and when it was published
+type publishedEntry struct {
+	Hash string    `json:"hash"`
+	At   time.Time `json:"at"`
+}
+
+// publishCache records the last published twin and health messages of the entities, so that
+// unchanged messages are not republished on each update. Messages are republished after the
+// refresh interval (even when unchanged), so that the cloud recovers from any lost messages.
+// The keys are prefixed by the entity's topic id, so that all entries of an entity can be removed.
+type publishCache struct {
+	// RefreshInterval is the maximum time between publishing an unchanged message.
+	// All messages are published if it is 0
+	RefreshInterval time.Duration
+
+	mu    sync.Mutex
+	items map[string]publishedEntry
+}
+
+func newPublishCache(refreshInterval time.Duration) *publishCache {
+	return &publishCache{
+		RefreshInterval: refreshInterval,
+		items:           make(map[string]publishedEntry),
+	}
+}
+
+func twinCacheKey(topicID string, name string) string {
+	return topicID + "/twin/" + name
+}
+
+func healthCacheKey(topicID string) string {
+	return topicID + "/status/health"
+}
+
+// fingerprint returns the hash of the value
+func fingerprint(v any) string {
+	sum := sha256.Sum256(mustMarshalJSON(v))
+	return hex.EncodeToString(sum[:])
+}
+
+// Changed checks if a value should be published as it differs from the last published value (or
+// the refresh interval has elapsed). The returned hash should be recorded once the value is published
+func (c *publishCache) Changed(key string, v any) (string, bool) {
+	hash := fingerprint(v)
+	if c.RefreshInterval <= 0 {
+		return hash, true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, found := c.items[key]
+	if !found || previous.Hash != hash {
+		return hash, true
+	}
+	return hash, time.Since(previous.At) >= c.RefreshInterval
+}
+
+// Record records a published value
+func (c *publishCache) Record(key string, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = publishedEntry{
+		Hash: hash,
+		At:   time.Now(),
+	}
+}
+
+// Forget removes all entries of an entity, so that its messages are published on the next update
+func (c *publishCache) Forget(topicID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.items {
+		if strings.HasPrefix(key, topicID+"/") {
+			delete(c.items, key)
+		}
+	}
+}
+
+// ForgetAll removes all entries, so that all messages are published on the next update
+func (c *publishCache) ForgetAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]publishedEntry)
+}
+
+// Snapshot returns a copy of the entries, e.g. to persist them
+func (c *publishCache) Snapshot() map[string]publishedEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]publishedEntry, len(c.items))
+	for key, value := range c.items {
+		out[key] = value
+	}
+	return out
+}
+
+// Restore adds the persisted entries
+func (c *publishCache) Restore(items map[string]publishedEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range items {
+		c.items[key] = value
+	}
+}
+
+// containerTwinFingerprint is the container twin without the properties which change on
+// every update (e.g. "Up 5 minutes"), so that they alone don't cause the twin to be republished
+func containerTwinFingerprint(twin any) any {
+	b := mustMarshalJSON(twin)
+	values := make(map[string]any)
+	if err := json.Unmarshal(b, &values); err != nil {
+		return twin
+	}
+	delete(values, "containerStatus")
+	delete(values, "runningFor")
+	return values
+}
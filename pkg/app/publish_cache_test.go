@@ -0,0 +1,101 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+func Test_PublishCacheChanged(t *testing.T) {
+	cache := newPublishCache(time.Hour)
+	key := healthCacheKey("device/main/service/nodered")
+
+	// Values which were not published yet
+	hash, changed := cache.Changed(key, "up")
+	assert.True(t, changed)
+	cache.Record(key, hash)
+
+	// Unchanged values are skipped
+	_, changed = cache.Changed(key, "up")
+	assert.False(t, changed)
+
+	// Changed values are published
+	_, changed = cache.Changed(key, "down")
+	assert.True(t, changed)
+
+	// Unchanged values are published again after the refresh interval
+	cache.items[key] = publishedEntry{Hash: hash, At: time.Now().Add(-time.Hour)}
+	_, changed = cache.Changed(key, "up")
+	assert.True(t, changed)
+}
+
+func Test_PublishCacheWithoutRefreshInterval(t *testing.T) {
+	cache := newPublishCache(0)
+	key := healthCacheKey("device/main/service/nodered")
+	hash, changed := cache.Changed(key, "up")
+	assert.True(t, changed)
+	cache.Record(key, hash)
+
+	// All values are published
+	_, changed = cache.Changed(key, "up")
+	assert.True(t, changed)
+}
+
+func Test_PublishCacheForget(t *testing.T) {
+	cache := newPublishCache(time.Hour)
+	keys := []string{
+		healthCacheKey("device/main/service/nodered"),
+		twinCacheKey("device/main/service/nodered", "container"),
+		healthCacheKey("device/main/service/nodered2"),
+		healthCacheKey("device/main/service/app@web"),
+	}
+	for _, key := range keys {
+		cache.Record(key, "abc")
+	}
+
+	// Only the entries of the entity are removed (not of entities with the same prefix)
+	cache.Forget("device/main/service/nodered")
+	snapshot := cache.Snapshot()
+	assert.NotContains(t, snapshot, keys[0])
+	assert.NotContains(t, snapshot, keys[1])
+	assert.Contains(t, snapshot, keys[2])
+	assert.Contains(t, snapshot, keys[3])
+
+	cache.ForgetAll()
+	assert.Empty(t, cache.Snapshot())
+}
+
+func Test_PublishCacheSnapshotRestore(t *testing.T) {
+	cache := newPublishCache(time.Hour)
+	key := healthCacheKey("device/main/service/nodered")
+	hash, _ := cache.Changed(key, "up")
+	cache.Record(key, hash)
+
+	restored := newPublishCache(time.Hour)
+	restored.Restore(cache.Snapshot())
+	_, changed := restored.Changed(key, "up")
+	assert.False(t, changed)
+
+	// The snapshot is a copy
+	snapshot := cache.Snapshot()
+	delete(snapshot, key)
+	assert.Contains(t, cache.Snapshot(), key)
+}
+
+func Test_ContainerTwinFingerprint(t *testing.T) {
+	twin := container.Container{
+		Id:     "c1",
+		Name:   "nodered",
+		State:  "running",
+		Status: "Up 2 seconds",
+		Image:  "nodered/node-red",
+	}
+	updated := twin
+	updated.Status = "Up 5 minutes"
+	assert.Equal(t, fingerprint(containerTwinFingerprint(twin)), fingerprint(containerTwinFingerprint(updated)))
+
+	updated.State = "exited"
+	assert.NotEqual(t, fingerprint(containerTwinFingerprint(twin)), fingerprint(containerTwinFingerprint(updated)))
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+	"github.com/thin-edge/tedge-container-plugin/pkg/utils"
+)
+
+// configUpdateCommand is the subset of the thin-edge.io config_update command
+// payload which is required to process the delivered registry credentials
+type configUpdateCommand struct {
+	Status string `json:"status"`
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+}
+
+// subscribeRegistryCredentials listens to the config_update commands of the main device
+// so that registry credentials which are delivered from the cloud (via the
+// dedicated config type) are imported once the tedge-agent has written the file
+func (a *App) subscribeRegistryCredentials() error {
+	if a.config.RegistryCredentialsConfigType == "" || a.config.ImportRegistryCredentials == nil {
+		return nil
+	}
+	topic := tedge.GetTopic(*a.Device, "cmd", "config_update", "+")
+	slog.Info("Listening for registry credentials delivered from the cloud.", "topic", topic, "type", a.config.RegistryCredentialsConfigType)
+	return a.client.Subscribe(topic, 1, func(c mqtt.Client, m mqtt.Message) {
+		if len(m.Payload()) == 0 {
+			return
+		}
+		command := configUpdateCommand{}
+		if err := json.Unmarshal(m.Payload(), &command); err != nil {
+			slog.Warn("Could not parse config_update command.", "topic", m.Topic(), "err", err)
+			return
+		}
+		if command.Type != a.config.RegistryCredentialsConfigType || command.Status != "successful" {
+			return
+		}
+
+		// Only the configured file is imported (and removed afterwards), so a command can't
+		// be used to read or delete other files
+		path := a.config.RegistryCredentialsPath
+		if command.Path != "" && !utils.SamePath(command.Path, path) {
+			slog.Warn("Ignoring registry credentials delivered to an unexpected path.", "path", command.Path, "expected", path)
+			return
+		}
+
+		// Don't block the mqtt client's message handler
+		go a.importRegistryCredentials(path)
+	})
+}
+
+func (a *App) importRegistryCredentials(path string) {
+	topic := tedge.GetTopic(a.client.Target, "a", "RegistryCredentialsInvalid")
+	registries, err := a.config.ImportRegistryCredentials(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// The file has already been imported, e.g. the retained command
+			// was received again after reconnecting
+			slog.Debug("Registry credentials file does not exist. Ignoring.", "path", path)
+			return
+		}
+		slog.Error("Invalid registry credentials delivered from the cloud.", "path", path, "err", err)
+		payload := mustMarshalJSON(map[string]any{
+			"severity": "MAJOR",
+			"text":     fmt.Sprintf("Invalid registry credentials delivered from the cloud. %s", strings.ReplaceAll(err.Error(), "\n", "; ")),
+			"time":     time.Now().UTC().Format(time.RFC3339),
+		})
+		if err := a.client.Publish(topic, 1, true, payload); err != nil {
+			slog.Warn("Failed to publish registry credentials alarm.", "err", err)
+		}
+		return
+	}
+
+	if err := a.client.Publish(topic, 1, true, ""); err != nil {
+		slog.Warn("Failed to clear registry credentials alarm.", "err", err)
+	}
+	payload := mustMarshalJSON(map[string]any{
+		"text":       fmt.Sprintf("Registry credentials updated. registries=%s", strings.Join(registries, ",")),
+		"registries": registries,
+		"time":       time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := a.client.Publish(tedge.GetTopic(a.client.Target, "e", "registry_credentials_updated"), 1, false, payload); err != nil {
+		slog.Warn("Failed to publish registry credentials event.", "err", err)
+	}
+}
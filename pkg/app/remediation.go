@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// Actions which are taken when a container is in a crash loop
+const (
+	// CrashLoopActionNone only raises the alarm
+	CrashLoopActionNone = "none"
+	// CrashLoopActionStop stops the container
+	CrashLoopActionStop = "stop"
+	// CrashLoopActionDisableRestart disables the restart policy, so the container stays stopped after its next exit
+	CrashLoopActionDisableRestart = "disable_restart"
+	// CrashLoopActionRollback recreates the container using the image it replaced at install time
+	CrashLoopActionRollback = "rollback"
+	// CrashLoopActionRestartProject restarts all containers of the compose project (container-groups only)
+	CrashLoopActionRestartProject = "restart_project"
+)
+
+// rollbackHealthyAfter is how long the rolled back container must be healthy,
+// otherwise the crashing container is restored
+const rollbackHealthyAfter = 60 * time.Second
+
+// ValidCrashLoopAction checks if the crash loop action is supported
+func ValidCrashLoopAction(action string) bool {
+	switch action {
+	case CrashLoopActionNone, CrashLoopActionStop, CrashLoopActionDisableRestart, CrashLoopActionRollback, CrashLoopActionRestartProject:
+		return true
+	}
+	return false
+}
+
+// remediateCrashLoop runs the crash loop action of the container (from its label, or the global setting)
+// and reports the outcome as an event on the service
+func (a *App) remediateCrashLoop(ctx context.Context, name string, containerID string) {
+	cli := a.clientForContainer(containerID)
+	con, err := cli.Client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		slog.Warn("Could not inspect crash-looping container.", "container", name, "err", err)
+		return
+	}
+
+	action := a.config.CrashLoopAction
+	if con.Config != nil {
+		if v := strings.TrimSpace(con.Config.Labels[container.LabelCrashLoopAction]); v != "" {
+			action = v
+		}
+	}
+	if action == "" || action == CrashLoopActionNone {
+		return
+	}
+	if !ValidCrashLoopAction(action) {
+		slog.Warn("Unknown crash loop action. Ignoring it.", "container", name, "action", action)
+		return
+	}
+
+	if container.IsInsideContainer() {
+		if self, err := cli.Self(ctx); err == nil && self.ID == con.ID {
+			slog.Info("Skipping crash loop action of the current container.", "container", name, "action", action)
+			return
+		}
+	}
+
+	slog.Warn("Running crash loop action.", "container", name, "action", action)
+	text, err := a.runCrashLoopAction(ctx, action, con)
+	if err != nil {
+		slog.Warn("Crash loop action failed.", "container", name, "action", action, "err", err)
+		text = fmt.Sprintf("Crash loop action failed. action=%s, error=%s", action, err)
+	} else {
+		slog.Info("Crash loop action completed.", "container", name, "action", action)
+	}
+
+	target := a.serviceTarget(name)
+	payload := mustMarshalJSON(map[string]any{
+		"text":        text,
+		"action":      action,
+		"success":     err == nil,
+		"containerID": containerID,
+		"time":        time.Now().UTC().Format(time.RFC3339),
+	})
+	if pubErr := a.client.Publish(tedge.GetTopic(*target, "e", "crash_loop_action"), 1, false, payload); pubErr != nil {
+		slog.Warn("Could not publish crash loop action event.", "container", name, "err", pubErr)
+	}
+}
+
+func (a *App) runCrashLoopAction(ctx context.Context, action string, con containerSDK.InspectResponse) (string, error) {
+	cli := a.clientForContainer(con.ID)
+	labels := map[string]string{}
+	if con.Config != nil {
+		labels = con.Config.Labels
+	}
+
+	switch action {
+	case CrashLoopActionStop:
+		if err := cli.StopContainer(ctx, con.ID); err != nil {
+			return "", err
+		}
+		return "Stopped crash-looping container", nil
+
+	case CrashLoopActionDisableRestart:
+		_, err := cli.Client.ContainerUpdate(ctx, con.ID, containerSDK.UpdateConfig{
+			RestartPolicy: containerSDK.RestartPolicy{
+				Name: containerSDK.RestartPolicyDisabled,
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return "Disabled the restart policy of the crash-looping container", nil
+
+	case CrashLoopActionRollback:
+		if _, isGroup := labels["com.docker.compose.project"]; isGroup {
+			return "", fmt.Errorf("rollback is not supported for container-groups")
+		}
+		previousImage := labels[container.LabelPreviousImage]
+		if previousImage == "" {
+			return "", fmt.Errorf("no previous image was recorded when the container was installed")
+		}
+
+		pullOptions := container.ImagePullOptions{
+			MaxAttempts: 2,
+			Wait:        5 * time.Second,
+		}
+		if a.config.RegistryAuthFunc != nil {
+			pullOptions.AuthFunc = a.config.RegistryAuthFunc(previousImage)
+		}
+		prevImage, err := cli.ImagePullWithRetries(ctx, previousImage, false, pullOptions)
+		if err != nil {
+			return "", err
+		}
+		if a.config.VerifyImage != nil {
+			if err := a.config.VerifyImage(ctx, cli, previousImage); err != nil {
+				return "", err
+			}
+		}
+
+		if err := cli.CloneContainer(ctx, con.ID, container.CloneOptions{
+			Image:        previousImage,
+			HealthyAfter: rollbackHealthyAfter,
+			// Don't roll back to the crashing image again, and report the image which is actually running
+			Labels: map[string]string{
+				container.LabelPreviousImage: "",
+				container.LabelModuleVersion: "",
+			},
+		}); err != nil {
+			return "", err
+		}
+
+		// The clone restores the crashing container (without an error) if the previous image is not healthy either
+		next, err := cli.Client.ContainerInspect(ctx, con.Name)
+		if err != nil {
+			return "", err
+		}
+		if next.Image != prevImage.ID {
+			return "", fmt.Errorf("container using the previous image was not healthy. image=%s", previousImage)
+		}
+		return fmt.Sprintf("Rolled back the crash-looping container to the previous image. image=%s", previousImage), nil
+
+	case CrashLoopActionRestartProject:
+		project := labels["com.docker.compose.project"]
+		if project == "" {
+			return "", fmt.Errorf("restart_project is only supported for container-groups")
+		}
+		containers, err := cli.Client.ContainerList(ctx, containerSDK.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", "com.docker.compose.project="+project)),
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, item := range containers {
+			if err := cli.RestartContainer(ctx, item.ID); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("Restarted all containers of the compose project. project=%s, containers=%d", project, len(containers)), nil
+	}
+	return "", fmt.Errorf("unknown crash loop action. action=%s", action)
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// monitorState is the crash loop detection state which is persisted across
+// restarts of the plugin (or the device)
+type monitorState struct {
+	RestartBaseline map[string]int       `json:"restartBaseline"`
+	CrashLoopAlarms []string             `json:"crashLoopAlarms"`
+	EventLimiter    map[string]time.Time `json:"eventLimiter"`
+	// Published are the fingerprints of the published twin and health messages
+	Published map[string]publishedEntry `json:"published,omitempty"`
+	SavedAt   time.Time                 `json:"savedAt"`
+}
+
+// loadState restores the persisted state. A missing or invalid state file is ignored
+func (a *App) loadState() {
+	if a.config.StateFile == "" {
+		return
+	}
+	b, err := os.ReadFile(a.config.StateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Could not read monitor state.", "path", a.config.StateFile, "err", err)
+		}
+		return
+	}
+	state := monitorState{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		slog.Warn("Ignoring invalid monitor state.", "path", a.config.StateFile, "err", err)
+		return
+	}
+
+	a.restartBaselineMu.Lock()
+	for name, count := range state.RestartBaseline {
+		a.restartBaseline[name] = count
+	}
+	a.restartBaselineMu.Unlock()
+
+	a.crashLoopAlarmsMu.Lock()
+	for _, name := range state.CrashLoopAlarms {
+		a.crashLoopAlarms[name] = struct{}{}
+	}
+	a.crashLoopAlarmsMu.Unlock()
+
+	a.eventLimiter.Restore(state.EventLimiter)
+	a.publishCache.Restore(state.Published)
+	slog.Info("Restored monitor state.", "path", a.config.StateFile, "savedAt", state.SavedAt, "baselines", len(state.RestartBaseline), "crashLoopAlarms", len(state.CrashLoopAlarms))
+}
+
+// saveState writes the state to the state file (atomically)
+func (a *App) saveState() {
+	if a.config.StateFile == "" {
+		return
+	}
+	state := monitorState{
+		RestartBaseline: make(map[string]int),
+		CrashLoopAlarms: make([]string, 0),
+		EventLimiter:    a.eventLimiter.Snapshot(),
+		Published:       a.publishCache.Snapshot(),
+		SavedAt:         time.Now(),
+	}
+	a.restartBaselineMu.Lock()
+	for name, count := range a.restartBaseline {
+		state.RestartBaseline[name] = count
+	}
+	a.restartBaselineMu.Unlock()
+	a.crashLoopAlarmsMu.Lock()
+	for name := range a.crashLoopAlarms {
+		state.CrashLoopAlarms = append(state.CrashLoopAlarms, name)
+	}
+	a.crashLoopAlarmsMu.Unlock()
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		slog.Warn("Could not marshal monitor state.", "err", err)
+		return
+	}
+
+	a.stateFileMu.Lock()
+	defer a.stateFileMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.config.StateFile), 0755); err != nil {
+		slog.Warn("Could not create monitor state directory.", "err", err)
+		return
+	}
+	tmpFile := a.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, b, 0644); err != nil {
+		slog.Warn("Could not write monitor state.", "path", tmpFile, "err", err)
+		return
+	}
+	if err := os.Rename(tmpFile, a.config.StateFile); err != nil {
+		slog.Warn("Could not write monitor state.", "path", a.config.StateFile, "err", err)
+	}
+}
+
+// markStateChanged schedules the state to be saved. Changes are batched so that
+// bursts of container events only cause a single write
+func (a *App) markStateChanged() {
+	if a.config.StateFile == "" {
+		return
+	}
+	if !a.stateSaveScheduled.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(2*time.Second, func() {
+		a.stateSaveScheduled.Store(false)
+		a.saveState()
+	})
+}
+
+// subscribeServiceAlarms calls the handler with the service name for each (retained) alarm
+// of the given type published on the services, and whether the alarm is active or was cleared
+func (a *App) subscribeServiceAlarms(alarmType string, handler func(name string, active bool)) error {
+	topic := tedge.GetTopic(*a.serviceTopicPattern(), "a", alarmType)
+	return a.client.Subscribe(topic, 1, func(c mqtt.Client, m mqtt.Message) {
+		target, err := tedge.NewTargetFromTopic(m.Topic())
+		if err != nil {
+			return
+		}
+		if name := a.serviceNameFromTopicID(target.TopicID); name != "" {
+			handler(name, len(m.Payload()) > 0)
+		}
+	})
+}
+
+// subscribeCrashLoopAlarms tracks the retained crash loop alarms of the services, so that
+// alarms raised before the plugin was restarted can be reconciled
+func (a *App) subscribeCrashLoopAlarms() error {
+	return a.subscribeServiceAlarms("ContainerCrashLoop", func(name string, active bool) {
+		a.retainedCrashLoopAlarmsMu.Lock()
+		defer a.retainedCrashLoopAlarmsMu.Unlock()
+		if active {
+			a.retainedCrashLoopAlarms[name] = struct{}{}
+		} else {
+			delete(a.retainedCrashLoopAlarms, name)
+		}
+	})
+}
+
+// reconcileCrashLoopState compares the restored (or retained) crash loop state with the current
+// state of the containers. Alarms of containers which were removed or have recovered are cleared,
+// alarms of containers which are still crashing are kept (and republished if they were lost),
+// and baselines of recreated containers are reset.
+func (a *App) reconcileCrashLoopState(ctx context.Context, items []container.TedgeContainer) {
+	byName := make(map[string]container.TedgeContainer, len(items))
+	for _, item := range items {
+		byName[item.Name] = item
+	}
+
+	a.retainedCrashLoopAlarmsMu.Lock()
+	retained := make(map[string]struct{}, len(a.retainedCrashLoopAlarms))
+	for name := range a.retainedCrashLoopAlarms {
+		retained[name] = struct{}{}
+	}
+	a.retainedCrashLoopAlarmsMu.Unlock()
+
+	candidates := make(map[string]struct{})
+	a.crashLoopAlarmsMu.Lock()
+	for name := range a.crashLoopAlarms {
+		candidates[name] = struct{}{}
+	}
+	a.crashLoopAlarmsMu.Unlock()
+	for name := range retained {
+		candidates[name] = struct{}{}
+	}
+
+	// Restart baselines
+	a.restartBaselineMu.Lock()
+	baselines := make(map[string]int, len(a.restartBaseline))
+	for name, count := range a.restartBaseline {
+		baselines[name] = count
+	}
+	a.restartBaselineMu.Unlock()
+
+	for name, baseline := range baselines {
+		item, exists := byName[name]
+		if !exists {
+			slog.Info("Removing restart baseline of removed container.", "container", name)
+			a.restartBaselineMu.Lock()
+			delete(a.restartBaseline, name)
+			a.restartBaselineMu.Unlock()
+			continue
+		}
+		rc, err := a.clientForContainer(item.Container.Id).GetRestartCount(ctx, item.Container.Id)
+		if err != nil {
+			continue
+		}
+		if rc < baseline {
+			// The container was recreated, so the restart count was reset
+			slog.Info("Resetting restart baseline of recreated container.", "container", name, "baseline", baseline, "restartCount", rc)
+			a.restartBaselineMu.Lock()
+			a.restartBaseline[name] = rc
+			a.restartBaselineMu.Unlock()
+		}
+	}
+
+	// Crash loop alarms
+	for name := range candidates {
+		_, isRetained := retained[name]
+		item, exists := byName[name]
+		if !exists {
+			slog.Info("Clearing crash-loop alarm of removed container.", "container", name)
+			a.forceClearCrashLoopAlarm(name)
+			continue
+		}
+
+		con, err := a.clientForContainer(item.Container.Id).Client.ContainerInspect(ctx, item.Container.Id)
+		if err != nil {
+			slog.Warn("Could not inspect container to reconcile crash-loop state.", "container", name, "err", err)
+			continue
+		}
+		if isContainerStable(con.State) {
+			slog.Info("Clearing crash-loop alarm of recovered container.", "container", name, "restartCount", con.RestartCount)
+			a.restartBaselineMu.Lock()
+			a.restartBaseline[name] = con.RestartCount
+			a.restartBaselineMu.Unlock()
+			a.forceClearCrashLoopAlarm(name)
+			continue
+		}
+
+		if isRetained {
+			// The alarm is still active, so adopt it
+			a.crashLoopAlarmsMu.Lock()
+			a.crashLoopAlarms[name] = struct{}{}
+			a.crashLoopAlarmsMu.Unlock()
+		} else {
+			// The alarm was lost (e.g. the broker's retained messages were not persisted)
+			slog.Info("Republishing crash-loop alarm.", "container", name)
+			a.crashLoopAlarmsMu.Lock()
+			delete(a.crashLoopAlarms, name)
+			a.crashLoopAlarmsMu.Unlock()
+			a.publishCrashLoopAlarm(name, con.RestartCount)
+		}
+	}
+	a.markStateChanged()
+}
+
+// isContainerStable checks if a container has recovered, i.e. it is healthy, or (if it has no health check)
+// it has been running for at least a minute without restarting
+func isContainerStable(state *containerSDK.State) bool {
+	if state == nil || !state.Running || state.Restarting {
+		return false
+	}
+	if state.Health != nil && state.Health.Status != "" && state.Health.Status != "none" {
+		return state.Health.Status == container.ContainerStatusHealthy
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil {
+		return false
+	}
+	return time.Since(startedAt) >= time.Minute
+}
+
+// forceClearCrashLoopAlarm clears the alarm even if it was not raised by this instance of the plugin
+func (a *App) forceClearCrashLoopAlarm(name string) {
+	a.crashLoopAlarmsMu.Lock()
+	a.crashLoopAlarms[name] = struct{}{}
+	a.crashLoopAlarmsMu.Unlock()
+	a.clearCrashLoopAlarm(name)
+}
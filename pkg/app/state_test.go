@@ -0,0 +1,242 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+func inspectResponse(id string, restartCount int, state containerSDK.State) containerSDK.InspectResponse {
+	return containerSDK.InspectResponse{
+		ContainerJSONBase: &containerSDK.ContainerJSONBase{
+			ID:           id,
+			RestartCount: restartCount,
+			State:        &state,
+		},
+	}
+}
+
+func Test_ReconcileCrashLoopState(t *testing.T) {
+	startedAt := func(ago time.Duration) string {
+		return time.Now().Add(-ago).Format(time.RFC3339Nano)
+	}
+	crashing := containerSDK.State{Restarting: true}
+	healthy := containerSDK.State{Running: true, StartedAt: startedAt(time.Second), Health: &containerSDK.Health{Status: container.ContainerStatusHealthy}}
+	unhealthy := containerSDK.State{Running: true, StartedAt: startedAt(time.Hour), Health: &containerSDK.Health{Status: "unhealthy"}}
+	runningLong := containerSDK.State{Running: true, StartedAt: startedAt(2 * time.Minute)}
+	runningShort := containerSDK.State{Running: true, StartedAt: startedAt(5 * time.Second)}
+
+	nodered := containerSDK.Summary{ID: "c1", Names: []string{"/nodered"}, State: "running"}
+
+	testcases := []struct {
+		Name       string
+		Containers []containerSDK.Summary
+		Inspect    map[string]containerSDK.InspectResponse
+		Baselines  map[string]int
+		Alarms     []string
+		Retained   []string
+
+		ExpectBaselines map[string]int
+		ExpectAlarms    []string
+		// services whose alarm is cleared
+		ExpectCleared []string
+		// services whose alarm is (re)published
+		ExpectRaised []string
+	}{
+		{
+			Name:            "baseline of removed container is removed",
+			Baselines:       map[string]int{"nodered": 3},
+			ExpectBaselines: map[string]int{},
+		},
+		{
+			Name:            "baseline of recreated container is reset",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 1, runningShort)},
+			Baselines:       map[string]int{"nodered": 4},
+			ExpectBaselines: map[string]int{"nodered": 1},
+		},
+		{
+			Name:            "baseline is kept",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 6, runningShort)},
+			Baselines:       map[string]int{"nodered": 4},
+			ExpectBaselines: map[string]int{"nodered": 4},
+		},
+		{
+			Name:            "baseline is kept if the container can't be inspected",
+			Containers:      []containerSDK.Summary{nodered},
+			Baselines:       map[string]int{"nodered": 4},
+			ExpectBaselines: map[string]int{"nodered": 4},
+		},
+		{
+			Name:            "restored alarm of removed container is cleared",
+			Alarms:          []string{"nodered"},
+			ExpectBaselines: map[string]int{},
+			ExpectCleared:   []string{"nodered"},
+		},
+		{
+			Name:            "retained alarm of removed container is cleared",
+			Retained:        []string{"nodered"},
+			ExpectBaselines: map[string]int{},
+			ExpectCleared:   []string{"nodered"},
+		},
+		{
+			Name:            "alarm of healthy container is cleared",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 7, healthy)},
+			Alarms:          []string{"nodered"},
+			Retained:        []string{"nodered"},
+			ExpectBaselines: map[string]int{"nodered": 7},
+			ExpectCleared:   []string{"nodered"},
+		},
+		{
+			Name:            "alarm of container without health check running for over a minute is cleared",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 2, runningLong)},
+			Retained:        []string{"nodered"},
+			ExpectBaselines: map[string]int{"nodered": 2},
+			ExpectCleared:   []string{"nodered"},
+		},
+		{
+			Name:            "retained alarm of unhealthy container is adopted",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 2, unhealthy)},
+			Retained:        []string{"nodered"},
+			ExpectBaselines: map[string]int{},
+			ExpectAlarms:    []string{"nodered"},
+		},
+		{
+			Name:            "retained alarm of crashing container is adopted",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 9, crashing)},
+			Alarms:          []string{"nodered"},
+			Retained:        []string{"nodered"},
+			ExpectBaselines: map[string]int{},
+			ExpectAlarms:    []string{"nodered"},
+		},
+		{
+			Name:            "lost alarm of crashing container is republished",
+			Containers:      []containerSDK.Summary{nodered},
+			Inspect:         map[string]containerSDK.InspectResponse{"c1": inspectResponse("c1", 9, crashing)},
+			Alarms:          []string{"nodered"},
+			ExpectBaselines: map[string]int{},
+			ExpectAlarms:    []string{"nodered"},
+			ExpectRaised:    []string{"nodered"},
+		},
+		{
+			Name:            "alarm is kept if the container can't be inspected",
+			Containers:      []containerSDK.Summary{nodered},
+			Alarms:          []string{"nodered"},
+			ExpectBaselines: map[string]int{},
+			ExpectAlarms:    []string{"nodered"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mqttClient := newFakeMQTTClient()
+			a := newTestApp(t, newFakeEngine(t, tc.Containers, tc.Inspect), mqttClient)
+			for name, count := range tc.Baselines {
+				a.restartBaseline[name] = count
+			}
+			for _, name := range tc.Alarms {
+				a.crashLoopAlarms[name] = struct{}{}
+			}
+			for _, name := range tc.Retained {
+				a.retainedCrashLoopAlarms[name] = struct{}{}
+			}
+
+			items, err := a.listContainers(context.Background(), container.FilterOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.reconcileCrashLoopState(context.Background(), items)
+
+			assert.Equal(t, tc.ExpectBaselines, a.restartBaseline)
+			alarms := make([]string, 0)
+			for name := range a.crashLoopAlarms {
+				alarms = append(alarms, name)
+			}
+			assert.ElementsMatch(t, tc.ExpectAlarms, alarms)
+
+			for _, name := range tc.ExpectCleared {
+				payload, ok := mqttClient.Message(tedge.GetTopic(*a.serviceTarget(name), "a", "ContainerCrashLoop"))
+				assert.True(t, ok, "alarm should be cleared. service=%s", name)
+				assert.Empty(t, payload)
+			}
+			for _, name := range tc.ExpectRaised {
+				topic := tedge.GetTopic(*a.serviceTarget(name), "a", "ContainerCrashLoop")
+				deadline := time.After(5 * time.Second)
+				for raised := false; !raised; {
+					select {
+					case published := <-mqttClient.notify:
+						raised = published == topic
+					case <-deadline:
+						t.Fatalf("alarm should be published. service=%s", name)
+					}
+				}
+				payload, _ := mqttClient.Message(topic)
+				assert.Contains(t, payload, `"severity":"CRITICAL"`)
+			}
+			if len(tc.ExpectCleared) == 0 && len(tc.ExpectRaised) == 0 {
+				assert.Empty(t, mqttClient.notify, "no messages should be published")
+			}
+		})
+	}
+}
+
+func Test_SaveAndLoadState(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state", "monitor.json")
+
+	a := newTestApp(t, nil, nil)
+	a.config.StateFile = stateFile
+	a.restartBaseline["nodered"] = 3
+	a.crashLoopAlarms["app@web"] = struct{}{}
+	a.eventLimiter.Allow("nodered/die")
+	a.publishCache.Record(healthCacheKey("device/main/service/nodered"), "abc")
+	a.saveState()
+
+	// The temporary file is renamed
+	entries, err := os.ReadDir(filepath.Dir(stateFile))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	restored := newTestApp(t, nil, nil)
+	restored.config.StateFile = stateFile
+	restored.loadState()
+	assert.Equal(t, map[string]int{"nodered": 3}, restored.restartBaseline)
+	assert.Equal(t, map[string]struct{}{"app@web": {}}, restored.crashLoopAlarms)
+	assert.False(t, restored.eventLimiter.Allow("nodered/die"), "the rate limit should be restored")
+	assert.Contains(t, restored.publishCache.Snapshot(), healthCacheKey("device/main/service/nodered"))
+}
+
+func Test_LoadStateIgnoresMissingOrInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stateFile := range []string{"", filepath.Join(dir, "missing.json"), invalid} {
+		a := newTestApp(t, nil, nil)
+		a.config.StateFile = stateFile
+		a.loadState()
+		assert.Empty(t, a.restartBaseline)
+		assert.Empty(t, a.crashLoopAlarms)
+	}
+
+	// Saving is disabled without a state file
+	a := newTestApp(t, nil, nil)
+	a.restartBaseline["nodered"] = 1
+	a.saveState()
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
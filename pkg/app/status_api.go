@@ -0,0 +1,298 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+// SyncState is the outcome of the last full update
+type SyncState struct {
+	LastSync  time.Time `json:"lastSync,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+	// PendingCloudDeletions are the topic ids of stale services which could
+	// not be removed from the cloud yet, and will be retried
+	PendingCloudDeletions []string `json:"pendingCloudDeletions"`
+}
+
+// ContainerStatus is the monitor's view of a managed container
+type ContainerStatus struct {
+	container.TedgeContainer
+	TopicID         string `json:"topicId"`
+	CrashLoop       bool   `json:"crashLoop"`
+	RestartBaseline *int   `json:"restartBaseline,omitempty"`
+}
+
+// EngineStatus describes the container engine the monitor is connected to
+type EngineStatus struct {
+	Type    container.EngineType `json:"type"`
+	Version string               `json:"version"`
+	Host    string               `json:"host"`
+}
+
+// Status is the state of the monitor exposed by the status API
+type Status struct {
+	Service string       `json:"service"`
+	Device  string       `json:"device"`
+	Engine  EngineStatus `json:"engine"`
+	// Engines are all of the monitored engines (only included when monitoring more than one engine)
+	Engines    []EngineStatus    `json:"engines,omitempty"`
+	Sync       SyncState         `json:"sync"`
+	CrashLoops []string          `json:"crashLoops"`
+	Containers []ContainerStatus `json:"containers"`
+}
+
+func (a *App) recordSyncResult(err error) {
+	a.syncStateMu.Lock()
+	defer a.syncStateMu.Unlock()
+	a.syncState.LastSync = time.Now()
+	a.syncState.LastError = ""
+	if err != nil {
+		a.syncState.LastError = err.Error()
+	}
+}
+
+// Status returns the managed containers (with their resolved service names) along
+// with the crash loop, restart baseline and cloud sync state of the monitor
+func (a *App) Status(ctx context.Context, filterOptions container.FilterOptions) (*Status, error) {
+	cli := a.getContainerClient()
+	items, err := a.listContainers(ctx, filterOptions)
+	if err != nil {
+		return nil, err
+	}
+	items = a.applyServiceNamePolicy(items)
+
+	status := &Status{
+		Service: a.config.ServiceName,
+		Device:  a.Device.TopicID,
+		Engine: EngineStatus{
+			Type:    cli.Engine.Type,
+			Version: cli.Engine.Version,
+			Host:    cli.Client.DaemonHost(),
+		},
+		CrashLoops: make([]string, 0),
+		Containers: make([]ContainerStatus, 0, len(items)),
+	}
+	if a.multipleEngines() {
+		for _, engine := range a.engines {
+			engineCli := engine.client.Load()
+			status.Engines = append(status.Engines, EngineStatus{
+				Type:    engineCli.Engine.Type,
+				Version: engineCli.Engine.Version,
+				Host:    engineCli.Client.DaemonHost(),
+			})
+		}
+	}
+
+	a.syncStateMu.Lock()
+	status.Sync = a.syncState
+	status.Sync.PendingCloudDeletions = append([]string{}, a.syncState.PendingCloudDeletions...)
+	a.syncStateMu.Unlock()
+
+	a.crashLoopAlarmsMu.Lock()
+	for name := range a.crashLoopAlarms {
+		status.CrashLoops = append(status.CrashLoops, name)
+	}
+	a.crashLoopAlarmsMu.Unlock()
+	sort.Strings(status.CrashLoops)
+
+	a.restartBaselineMu.Lock()
+	baselines := make(map[string]int, len(a.restartBaseline))
+	for name, count := range a.restartBaseline {
+		baselines[name] = count
+	}
+	a.restartBaselineMu.Unlock()
+
+	for _, item := range items {
+		containerStatus := ContainerStatus{
+			TedgeContainer: item,
+			TopicID:        a.serviceTarget(item.Name).TopicID,
+		}
+		for _, name := range status.CrashLoops {
+			if name == item.Name {
+				containerStatus.CrashLoop = true
+			}
+		}
+		if count, ok := baselines[item.Name]; ok {
+			containerStatus.RestartBaseline = &count
+		}
+		status.Containers = append(status.Containers, containerStatus)
+	}
+	return status, nil
+}
+
+// StatusHandler returns the http handler of the local status API
+//
+//	GET  /api/v1/status          state of the monitor
+//	GET  /api/v1/containers      managed containers
+//	POST /api/v1/update          trigger a full update
+//	POST /api/v1/update/metrics  trigger a metrics update
+func (a *App) StatusHandler(filterOptions container.FilterOptions) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := a.Status(r.Context(), filterOptions)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("GET /api/v1/containers", func(w http.ResponseWriter, r *http.Request) {
+		status, err := a.Status(r.Context(), filterOptions)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status.Containers)
+	})
+	mux.HandleFunc("POST /api/v1/update", func(w http.ResponseWriter, r *http.Request) {
+		if err := checkLocalRequest(r); err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		slog.Info("Update requested via the status API")
+		if err := a.Update(filterOptions); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /api/v1/update/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if err := checkLocalRequest(r); err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		slog.Info("Metrics update requested via the status API")
+		if err := a.UpdateMetrics(filterOptions); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return mux
+}
+
+// checkLocalRequest checks that a request which changes the state of the monitor is sent by a
+// local client. The json content type can't be sent by a cross-origin form post, and the loopback
+// host rejects requests of web pages which rebind their domain to the loopback address
+func checkLocalRequest(r *http.Request) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return fmt.Errorf("the content type must be application/json")
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("the host must be a loopback address. host=%s", r.Host)
+	}
+	return nil
+}
+
+// isLoopbackHost checks if the host is localhost or a loopback ip address
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkStatusAPIAddress checks that the status API is only served on a unix socket or the loopback
+// interface, as the API is not authenticated
+func checkStatusAPIAddress(address string) error {
+	if strings.HasPrefix(address, "unix://") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(strings.TrimPrefix(address, "tcp://"))
+	if err != nil {
+		return fmt.Errorf("invalid status API address. address=%s: %w", address, err)
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("the status API must be served on a unix socket or a loopback address. address=%s", address)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Could not write response.", "err", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// Listen creates a listener for an address which is either a unix socket,
+// e.g. "unix:///run/tedge-container-plugin/status.sock", or a tcp address, e.g. "127.0.0.1:8091".
+// An existing unix socket file is replaced.
+func Listen(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		// Only the owner and group are allowed to access the api
+		if err := os.Chmod(path, 0660); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+		return listener, nil
+	}
+	return net.Listen("tcp", strings.TrimPrefix(address, "tcp://"))
+}
+
+// ServeStatusAPI serves the local status API on the given address until the context is cancelled
+func (a *App) ServeStatusAPI(ctx context.Context, address string, filterOptions container.FilterOptions) error {
+	if err := checkStatusAPIAddress(address); err != nil {
+		return err
+	}
+	return serveHTTP(ctx, "status API", address, a.StatusHandler(filterOptions))
+}
+
+// serveHTTP serves the handler on the given address until the context is cancelled
+func serveHTTP(ctx context.Context, name string, address string, handler http.Handler) error {
+	listener, err := Listen(address)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s address. address=%s: %w", name, address, err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Starting http server.", "name", name, "address", address)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckStatusAPIAddress(t *testing.T) {
+	testcases := []struct {
+		Address string
+		Valid   bool
+	}{
+		{Address: "unix:///run/tedge-container-plugin/status.sock", Valid: true},
+		{Address: "127.0.0.1:8091", Valid: true},
+		{Address: "tcp://127.0.0.1:8091", Valid: true},
+		{Address: "localhost:8091", Valid: true},
+		{Address: "[::1]:8091", Valid: true},
+		{Address: "0.0.0.0:8091", Valid: false},
+		{Address: ":8091", Valid: false},
+		{Address: "192.168.1.10:8091", Valid: false},
+		{Address: "127.0.0.1", Valid: false},
+	}
+	for _, tc := range testcases {
+		err := checkStatusAPIAddress(tc.Address)
+		if tc.Valid {
+			assert.NoError(t, err, "address=%s", tc.Address)
+		} else {
+			assert.Error(t, err, "address=%s", tc.Address)
+		}
+	}
+}
+
+func Test_CheckLocalRequest(t *testing.T) {
+	testcases := []struct {
+		Host        string
+		ContentType string
+		Valid       bool
+	}{
+		{Host: "127.0.0.1:8091", ContentType: "application/json", Valid: true},
+		{Host: "localhost", ContentType: "application/json; charset=utf-8", Valid: true},
+		{Host: "[::1]:8091", ContentType: "application/json", Valid: true},
+		{Host: "127.0.0.1:8091", ContentType: "", Valid: false},
+		{Host: "127.0.0.1:8091", ContentType: "text/plain", Valid: false},
+		{Host: "127.0.0.1:8091", ContentType: "application/x-www-form-urlencoded", Valid: false},
+		{Host: "attacker.example.com:8091", ContentType: "application/json", Valid: false},
+	}
+	for _, tc := range testcases {
+		r := httptest.NewRequest("POST", "/api/v1/update", nil)
+		r.Host = tc.Host
+		if tc.ContentType != "" {
+			r.Header.Set("Content-Type", tc.ContentType)
+		}
+		err := checkLocalRequest(r)
+		if tc.Valid {
+			assert.NoError(t, err, "host=%s, contentType=%s", tc.Host, tc.ContentType)
+		} else {
+			assert.Error(t, err, "host=%s, contentType=%s", tc.Host, tc.ContentType)
+		}
+	}
+}
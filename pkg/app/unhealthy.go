@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	containerSDK "github.com/docker/docker/api/types/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// AlarmTypeUnhealthy is the alarm raised when a container stays unhealthy
+const AlarmTypeUnhealthy = "ContainerUnhealthy"
+
+// Number of healthcheck log entries (and the maximum length of their output) included in the alarm
+const (
+	unhealthyAlarmLogEntries   = 3
+	unhealthyAlarmOutputLength = 500
+)
+
+// HealthcheckLogEntry is a single healthcheck probe result included in the unhealthy alarm
+type HealthcheckLogEntry struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exitCode"`
+	Output   string    `json:"output"`
+}
+
+// scheduleUnhealthyAlarm raises the unhealthy alarm if the container is still unhealthy
+// after the configured duration. It is a no-op if the alarm is already raised or pending.
+func (a *App) scheduleUnhealthyAlarm(name string, containerID string) {
+	if a.config.UnhealthyAlarmAfter <= 0 {
+		return
+	}
+	a.unhealthyAlarmsMu.Lock()
+	defer a.unhealthyAlarmsMu.Unlock()
+	if _, exists := a.unhealthyAlarms[name]; exists {
+		return
+	}
+	a.unhealthyAlarms[name] = time.AfterFunc(a.config.UnhealthyAlarmAfter, func() {
+		con, err := a.clientForContainer(containerID).Client.ContainerInspect(context.Background(), containerID)
+		if err != nil || con.State == nil || con.State.Health == nil || !con.State.Running || con.State.Health.Status != containerSDK.Unhealthy {
+			// The container recovered, stopped or was removed without the event being received
+			slog.Debug("Container is no longer unhealthy. Not raising alarm.", "container", name, "err", err)
+			a.unhealthyAlarmsMu.Lock()
+			delete(a.unhealthyAlarms, name)
+			a.unhealthyAlarmsMu.Unlock()
+			return
+		}
+		a.publishUnhealthyAlarm(name, containerID, con.State.Health)
+	})
+}
+
+// publishUnhealthyAlarm raises the unhealthy alarm including the last healthcheck results
+func (a *App) publishUnhealthyAlarm(name string, containerID string, health *containerSDK.Health) {
+	a.unhealthyAlarmsMu.Lock()
+	_, pending := a.unhealthyAlarms[name]
+	if pending {
+		// Mark the alarm as raised
+		a.unhealthyAlarms[name] = nil
+	}
+	a.unhealthyAlarmsMu.Unlock()
+	if !pending {
+		// The alarm was cleared in the meantime
+		return
+	}
+
+	logs := health.Log
+	if len(logs) > unhealthyAlarmLogEntries {
+		logs = logs[len(logs)-unhealthyAlarmLogEntries:]
+	}
+	entries := make([]HealthcheckLogEntry, 0, len(logs))
+	for _, result := range logs {
+		if result == nil {
+			continue
+		}
+		entries = append(entries, HealthcheckLogEntry{
+			Start:    result.Start,
+			End:      result.End,
+			ExitCode: result.ExitCode,
+			Output:   truncateString(strings.TrimSpace(result.Output), unhealthyAlarmOutputLength),
+		})
+	}
+
+	text := fmt.Sprintf("Container has been unhealthy for more than %s. failingStreak=%d", a.config.UnhealthyAlarmAfter, health.FailingStreak)
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		text += fmt.Sprintf(", exitCode=%d, output=%s", last.ExitCode, truncateString(strings.Join(strings.Fields(last.Output), " "), 100))
+	}
+
+	slog.Warn("Container is unhealthy.", "container", name, "failingStreak", health.FailingStreak)
+	topic := tedge.GetTopic(*a.serviceTarget(name), "a", AlarmTypeUnhealthy)
+	payload := mustMarshalJSON(map[string]any{
+		"severity":      "MAJOR",
+		"text":          text,
+		"containerID":   containerID,
+		"failingStreak": health.FailingStreak,
+		"healthcheck":   entries,
+		"time":          time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := a.client.Publish(topic, 1, true, payload); err != nil {
+		slog.Warn("Could not publish unhealthy alarm.", "container", name, "err", err)
+	}
+}
+
+// clearUnhealthyAlarm cancels the pending unhealthy alarm, or clears it if it was raised
+func (a *App) clearUnhealthyAlarm(name string) {
+	a.unhealthyAlarmsMu.Lock()
+	timer, exists := a.unhealthyAlarms[name]
+	delete(a.unhealthyAlarms, name)
+	a.unhealthyAlarmsMu.Unlock()
+
+	if !exists {
+		return
+	}
+	if timer != nil {
+		// The alarm was not raised yet
+		timer.Stop()
+		return
+	}
+	topic := tedge.GetTopic(*a.serviceTarget(name), "a", AlarmTypeUnhealthy)
+	slog.Info("Clearing unhealthy alarm.", "container", name, "topic", topic)
+	if err := a.client.Publish(topic, 1, true, ""); err != nil {
+		slog.Warn("Failed to clear unhealthy alarm.", "err", err)
+	}
+}
+
+// subscribeUnhealthyAlarms tracks the retained unhealthy alarms so that alarms raised before the
+// plugin was restarted are still cleared
+func (a *App) subscribeUnhealthyAlarms() error {
+	return a.subscribeServiceAlarms(AlarmTypeUnhealthy, func(name string, active bool) {
+		if !active {
+			return
+		}
+		a.unhealthyAlarmsMu.Lock()
+		defer a.unhealthyAlarmsMu.Unlock()
+		if _, exists := a.unhealthyAlarms[name]; !exists {
+			a.unhealthyAlarms[name] = nil
+		}
+	})
+}
+
+// reconcileUnhealthyAlarms clears the unhealthy alarms of containers which were removed or have recovered,
+// and schedules alarms for containers which were already unhealthy (as the health status event is only
+// sent when the status changes)
+func (a *App) reconcileUnhealthyAlarms(items []container.TedgeContainer) {
+	byName := make(map[string]container.TedgeContainer, len(items))
+	for _, item := range items {
+		byName[item.Name] = item
+	}
+
+	a.unhealthyAlarmsMu.Lock()
+	names := make([]string, 0, len(a.unhealthyAlarms))
+	for name := range a.unhealthyAlarms {
+		names = append(names, name)
+	}
+	a.unhealthyAlarmsMu.Unlock()
+
+	for _, name := range names {
+		item, exists := byName[name]
+		if !exists || container.ParseHealth(item.Container.Status) != container.HealthUnhealthy {
+			slog.Info("Clearing unhealthy alarm of removed or recovered container.", "container", name)
+			a.clearUnhealthyAlarm(name)
+		}
+	}
+
+	for _, item := range items {
+		if container.ParseHealth(item.Container.Status) == container.HealthUnhealthy {
+			a.scheduleUnhealthyAlarm(item.Name, item.Container.Id)
+		}
+	}
+}
+
+func truncateString(v string, maxLength int) string {
+	if len(v) <= maxLength {
+		return v
+	}
+	// Don't split a multi-byte character
+	return strings.ToValidUTF8(v[:maxLength], "") + "..."
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/tedge"
+)
+
+// updateWorkers is the maximum number of services which are updated concurrently
+const updateWorkers = 5
+
+// tedgeAPITimeout is the timeout of each request to the thin-edge.io (tedge-agent) API
+const tedgeAPITimeout = 10 * time.Second
+
+// forEachConcurrently calls fn for each item using a bounded number of workers, and
+// returns the errors of all of the calls
+func forEachConcurrently[T any](items []T, workers int, fn func(T) error) error {
+	jobs := make(chan T, len(items))
+	results := make(chan error, len(items))
+
+	for w := 1; w <= min(workers, len(items)); w++ {
+		go func() {
+			for item := range jobs {
+				results <- fn(item)
+			}
+		}()
+	}
+
+	for _, item := range items {
+		jobs <- item
+	}
+	close(jobs)
+
+	jobErrors := make([]error, 0)
+	for range items {
+		jobErrors = append(jobErrors, <-results)
+	}
+	return errors.Join(jobErrors...)
+}
+
+// updateService registers the service of a container, and publishes its twin information and
+// health status (if they changed). It returns the registered entity, and if any messages were published.
+func (a *App) updateService(item container.TedgeContainer) (tedge.Entity, bool, error) {
+	target := a.serviceTarget(item.Name)
+	entity := a.serviceEntity(item.Name, item.ServiceType)
+	published := false
+	errs := make([]error, 0)
+
+	// Register using HTTP API
+	ctx, cancel := context.WithTimeout(context.Background(), tedgeAPITimeout)
+	resp, err := a.client.TedgeAPI.CreateEntity(ctx, entity)
+	cancel()
+	if err == nil {
+		slog.Info("Registered container.", "topic", target.Topic(), "url", resp.RawResponse.Request.URL.String(), "status_code", resp.RawResponse.Status)
+		a.declareContainerOperations(*target)
+	} else {
+		slog.Error("Failed to register container.", "topic", target.Topic(), "err", err)
+		errs = append(errs, fmt.Errorf("register %s. %w", item.Name, err))
+	}
+
+	// update digital twin information
+	twin := item.Container
+	if a.config.TwinOptions.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), tedgeAPITimeout)
+		if err := a.clientForContainer(twin.Id).AddTwinDetails(ctx, &twin, a.config.TwinOptions); err != nil {
+			slog.Warn("Could not read container details.", "container", twin.Name, "err", err)
+		}
+		cancel()
+	}
+
+	twinKey := twinCacheKey(target.TopicID, "container")
+	if hash, changed := a.publishCache.Changed(twinKey, containerTwinFingerprint(twin)); changed {
+		ctx, cancel := context.WithTimeout(context.Background(), tedgeAPITimeout)
+		_, err := a.client.TedgeAPI.UpdateTwin(
+			ctx,
+			tedge.Entity{
+				TedgeTopicID: target.TopicID,
+			},
+			"container",
+			twin,
+		)
+		cancel()
+		if err != nil {
+			slog.Error("Could not publish container status", "err", err)
+			errs = append(errs, fmt.Errorf("update twin of %s. %w", item.Name, err))
+		} else {
+			a.publishCache.Record(twinKey, hash)
+			published = true
+		}
+	} else {
+		slog.Debug("Skipping unchanged container twin.", "topic", target.Topic())
+	}
+
+	// Publish the health message last so the health status always wins over any
+	// implicit status that the C8Y mapper may emit when processing the entity
+	// registration or twin update messages (which could otherwise race and
+	// override an explicit "down" status with "up").
+
+	// If this container is in a crash loop, report it as down regardless of
+	// what Docker currently reports — during a crash loop the container is
+	// transiently running between restarts when the scan fires.
+	status := item.Status
+	a.crashLoopAlarmsMu.Lock()
+	_, inCrashLoop := a.crashLoopAlarms[item.Name]
+	a.crashLoopAlarmsMu.Unlock()
+	if inCrashLoop {
+		status = tedge.StatusDown
+	}
+
+	healthKey := healthCacheKey(target.TopicID)
+	hash, changed := a.publishCache.Changed(healthKey, status)
+	if !changed {
+		slog.Debug("Skipping unchanged container health status.", "topic", target.Topic())
+		return entity, published, errors.Join(errs...)
+	}
+
+	payload := map[string]any{
+		"status": status,
+		"time":   item.Time,
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("Could not marshal registration message", "err", err)
+		return entity, published, errors.Join(errs...)
+	}
+	topic := tedge.GetHealthTopic(*target)
+	slog.Info("Publishing container health status", "topic", topic, "payload", b)
+	if err := a.client.Publish(topic, 1, true, b); err != nil {
+		slog.Error("Failed to update health status", "target", topic, "err", err)
+		errs = append(errs, fmt.Errorf("publish health of %s. %w", item.Name, err))
+	} else {
+		a.publishCache.Record(healthKey, hash)
+		published = true
+	}
+	return entity, published, errors.Join(errs...)
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+)
+
+func Test_ForEachConcurrently(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	var active, maxActive atomic.Int32
+	processed := sync.Map{}
+	err1 := errors.New("item 3 failed")
+	err2 := errors.New("item 7 failed")
+	err := forEachConcurrently(items, 3, func(item int) error {
+		n := active.Add(1)
+		for {
+			current := maxActive.Load()
+			if n <= current || maxActive.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		active.Add(-1)
+		processed.Store(item, true)
+		switch item {
+		case 3:
+			return err1
+		case 7:
+			return err2
+		}
+		return nil
+	})
+
+	// The errors of all of the items are returned
+	assert.ErrorIs(t, err, err1)
+	assert.ErrorIs(t, err, err2)
+
+	for _, item := range items {
+		_, ok := processed.Load(item)
+		assert.True(t, ok, "item=%d", item)
+	}
+	assert.LessOrEqual(t, maxActive.Load(), int32(3))
+	assert.Greater(t, maxActive.Load(), int32(1))
+}
+
+func Test_ForEachConcurrentlyWithoutItems(t *testing.T) {
+	err := forEachConcurrently([]string{}, 5, func(item string) error {
+		t.Fatal("should not be called")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// Fewer items than workers
+	count := atomic.Int32{}
+	err = forEachConcurrently([]string{"a"}, 5, func(item string) error {
+		count.Add(1)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), count.Load())
+}
+
+func Test_UpdateService(t *testing.T) {
+	mqttClient := newFakeMQTTClient()
+	a := newTestApp(t, nil, mqttClient)
+
+	item := container.TedgeContainer{
+		Name:        "nodered",
+		Status:      "up",
+		ServiceType: container.ContainerType,
+		Container: container.Container{
+			Id:     "c1",
+			Name:   "nodered",
+			State:  "running",
+			Status: "Up 2 seconds",
+			Image:  "nodered/node-red",
+		},
+	}
+	const (
+		register   = "POST /te/v1/entities"
+		updateTwin = "PUT /te/v1/entities/device/main/service/nodered/twin/container"
+		health     = "PUBLISH te/device/main/service/nodered/status/health"
+	)
+	update := func(item container.TedgeContainer) ([]string, bool, error) {
+		before := len(mqttClient.Calls())
+		_, published, err := a.updateService(item)
+		return mqttClient.Calls()[before:], published, err
+	}
+
+	// The twin is updated before the health status is published, so that the health status
+	// isn't overridden by the status which the mapper may derive from the twin update
+	calls, published, err := update(item)
+	assert.NoError(t, err)
+	assert.True(t, published)
+	assert.Equal(t, []string{register, updateTwin, health}, calls)
+	payload, _ := mqttClient.Message("te/device/main/service/nodered/status/health")
+	assert.Contains(t, payload, `"status":"up"`)
+
+	// Unchanged information is not republished, including the properties which change on each update
+	item.Container.Status = "Up 5 minutes"
+	calls, published, err = update(item)
+	assert.NoError(t, err)
+	assert.False(t, published)
+	assert.Equal(t, []string{register}, calls)
+
+	// Only the changed information is published
+	item.Status = "down"
+	item.Container.State = "exited"
+	calls, published, _ = update(item)
+	assert.True(t, published)
+	assert.Equal(t, []string{register, updateTwin, health}, calls)
+
+	// Only the health status changed
+	item.Container.State = "running"
+	item.Status = "up"
+	update(item)
+	item.Status = "down"
+	calls, _, _ = update(item)
+	assert.Equal(t, []string{register, health}, calls)
+	item.Status = "up"
+	update(item)
+
+	// A service in a crash loop is reported as down
+	a.crashLoopAlarms["nodered"] = struct{}{}
+	calls, _, _ = update(item)
+	assert.Equal(t, []string{register, health}, calls)
+	payload, _ = mqttClient.Message("te/device/main/service/nodered/status/health")
+	assert.Contains(t, payload, `"status":"down"`)
+	delete(a.crashLoopAlarms, "nodered")
+
+	// A forced refresh republishes everything
+	a.publishCache.Forget("device/main/service/nodered")
+	calls, _, _ = update(item)
+	assert.Equal(t, []string{register, updateTwin, health}, calls)
+}
+
+func Test_UpdateServiceRetriesFailedTwinUpdate(t *testing.T) {
+	mqttClient := newFakeMQTTClient()
+	a := newTestApp(t, nil, mqttClient)
+	item := container.TedgeContainer{
+		Name:        "nodered",
+		Status:      "up",
+		ServiceType: container.ContainerType,
+		Container:   container.Container{Id: "c1", Name: "nodered", State: "running"},
+	}
+	updateTwin := "PUT /te/v1/entities/device/main/service/nodered/twin/container"
+	mqttClient.httpErrors[updateTwin] = 500
+
+	// The health status is still published
+	_, published, err := a.updateService(item)
+	assert.Error(t, err)
+	assert.True(t, published)
+	assert.True(t, slices.Contains(mqttClient.Calls(), "PUBLISH te/device/main/service/nodered/status/health"))
+
+	// The twin is not recorded as published, so it is retried by the next update
+	delete(mqttClient.httpErrors, updateTwin)
+	before := len(mqttClient.Calls())
+	_, _, err = a.updateService(item)
+	assert.NoError(t, err)
+	assert.Contains(t, mqttClient.Calls()[before:], updateTwin)
+}
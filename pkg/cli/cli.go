@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/distribution/reference"
@@ -51,6 +55,10 @@ func (e ExitCodeError) ExitCode() int {
 
 type Cli struct {
 	ConfigFile string
+
+	// Version and Branch of the build
+	Version string
+	Branch  string
 }
 
 func (c *Cli) OnInit() {
@@ -60,7 +68,16 @@ func (c *Cli) OnInit() {
 	viper.SetDefault("delete_legacy", true)
 	viper.SetDefault("data_dir", []string{"/data/tedge-container-plugin", "/var/tedge-container-plugin"})
 	viper.SetDefault("registry.credentials_path", "/data/tedge-container-plugin/credentials.toml")
+	viper.SetDefault("registry.credentials_cache.enabled", true)
+	viper.SetDefault("registry.credentials_cache.ttl", "15m")
+	viper.SetDefault("registry.credentials_cache.persist", false)
+	viper.SetDefault("registry.cloud_credentials.type", DefaultRegistryCredentialsConfigType)
+	viper.SetDefault("registry.cloud_credentials.path", "/etc/tedge-container-plugin/registries.toml")
+	viper.SetDefault("image_verification.policy", VerificationPolicyOff)
+	viper.SetDefault("image_verification.public_keys", []string{})
+	viper.SetDefault("image_verification.registries", []string{})
 	viper.SetDefault("container_group.use_module_name", false)
+	viper.SetDefault("container_group.mode", "service")
 
 	// Default to the tedge plugins folder
 	if c.ConfigFile == "" {
@@ -116,9 +133,103 @@ func (c *Cli) GetContainerClientOptions() []container.Opt {
 	return options
 }
 
-// GetContainerHost get the container engine's host configuration (if manually defined by the user)
+// GetContainerHost get the container engine's host configuration (if manually defined by the user).
+// If multiple hosts are configured, then the first (primary) one is returned
 func (c *Cli) GetContainerHost() string {
-	return viper.GetString("container.host")
+	if hosts := c.GetContainerHosts(); len(hosts) > 0 {
+		return hosts[0]
+	}
+	return ""
+}
+
+// GetContainerHosts get the container engine hosts to monitor. The container.host setting
+// accepts a single host, a comma separated list of hosts, or a list of hosts
+func (c *Cli) GetContainerHosts() []string {
+	var values []string
+	switch v := viper.Get("container.host").(type) {
+	case string:
+		values = strings.Split(v, ",")
+	default:
+		values = viper.GetStringSlice("container.host")
+	}
+	hosts := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" && !slices.Contains(hosts, value) {
+			hosts = append(hosts, value)
+		}
+	}
+	return hosts
+}
+
+// GetContainerClientFor returns a client of the container engine which has a matching container,
+// e.g. a container with a given name. The primary engine is used if only one engine is configured,
+// or if none of the engines has a matching container
+func (c *Cli) GetContainerClientFor(ctx context.Context, match func(context.Context, *container.ContainerClient) bool) (*container.ContainerClient, error) {
+	hosts := c.GetContainerHosts()
+	if len(hosts) <= 1 {
+		return container.NewContainerClient(ctx, c.GetContainerClientOptions()...)
+	}
+
+	var primary *container.ContainerClient
+	for _, host := range hosts {
+		client, err := container.NewContainerClient(ctx, container.WithHost(host), container.WithAttempts(1))
+		if err != nil {
+			slog.Warn("Could not connect to container engine.", "host", host, "err", err)
+			continue
+		}
+		if match(ctx, client) {
+			return client, nil
+		}
+		if primary == nil && host == hosts[0] {
+			primary = client
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("could not connect to the primary container engine. host=%s", hosts[0])
+	}
+	return primary, nil
+}
+
+// GetContainerClients returns a client for each of the configured container engines. Engines which
+// can not be reached are skipped, and an error is only returned if none of the engines can be reached
+func (c *Cli) GetContainerClients(ctx context.Context) ([]*container.ContainerClient, error) {
+	hosts := c.GetContainerHosts()
+	if len(hosts) <= 1 {
+		client, err := container.NewContainerClient(ctx, c.GetContainerClientOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		return []*container.ContainerClient{client}, nil
+	}
+	clients := make([]*container.ContainerClient, 0, len(hosts))
+	errs := make([]error, 0)
+	for _, host := range hosts {
+		client, err := container.NewContainerClient(ctx, container.WithHost(host), container.WithAttempts(1))
+		if err != nil {
+			slog.Warn("Could not connect to container engine.", "host", host, "err", err)
+			errs = append(errs, fmt.Errorf("host=%s, err=%w", host, err))
+			continue
+		}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return clients, nil
+}
+
+// HasContainer matches the container engine which has a container with the given name or id
+func HasContainer(nameOrID string) func(context.Context, *container.ContainerClient) bool {
+	return func(ctx context.Context, client *container.ContainerClient) bool {
+		_, err := client.Client.ContainerInspect(ctx, nameOrID)
+		return err == nil
+	}
+}
+
+// IsSelf matches the container engine running the current process (if running inside a container)
+func IsSelf(ctx context.Context, client *container.ContainerClient) bool {
+	_, err := client.Self(ctx)
+	return err == nil
 }
 
 func (c *Cli) PrintConfig() {
@@ -129,6 +240,32 @@ func (c *Cli) PrintConfig() {
 	}
 }
 
+// secretSettingPattern matches the names of the settings of which the values are secret
+var secretSettingPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|private|api_?key|access_?key)`)
+
+// GetEffectiveSettings returns all of the settings (including the defaults), where the values
+// of any secret settings are redacted
+func (c *Cli) GetEffectiveSettings() map[string]any {
+	return redactSettings(viper.AllSettings())
+}
+
+func redactSettings(settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]any:
+			out[key] = redactSettings(v)
+		default:
+			if secretSettingPattern.MatchString(key) {
+				out[key] = container.RedactedValue
+			} else {
+				out[key] = value
+			}
+		}
+	}
+	return out
+}
+
 func (c *Cli) GetServiceName() string {
 	return viper.GetString("service_name")
 }
@@ -165,6 +302,134 @@ func (c *Cli) EngineEventsEnabled() bool {
 	return viper.GetBool("events.enabled")
 }
 
+// EngineUsageEnabled checks if the engine-wide usage measurements (and the disk usage alarm) are published
+func (c *Cli) EngineUsageEnabled() bool {
+	return viper.GetBool("engine_usage.enabled")
+}
+
+// GetEngineUsageInterval returns how often the engine-wide usage is published
+func (c *Cli) GetEngineUsageInterval() time.Duration {
+	interval := viper.GetDuration("engine_usage.interval")
+	if interval < 60*time.Second {
+		slog.Warn("engine_usage.interval is lower than allowed limit.", "old", interval, "new", 60*time.Second)
+		interval = 60 * time.Second
+	}
+	return interval
+}
+
+// GetEngineDataRoot returns the path used to read the free space of the engine's data root, e.g. if the
+// data root is mounted at a different path inside the plugin's container
+func (c *Cli) GetEngineDataRoot() string {
+	return viper.GetString("engine_usage.data_root")
+}
+
+// GetEngineDiskUsageThreshold returns the used space (in percent) of the engine's data root at which
+// the disk usage alarm is raised with the given severity (critical, major or minor). 0 disables the severity
+func (c *Cli) GetEngineDiskUsageThreshold(severity string) float64 {
+	return viper.GetFloat64("engine_usage.alarm." + severity)
+}
+
+// GetEngineUnavailableAfter returns how long a container engine can't be reached before the
+// engine unavailable alarm is raised. 0 disables the alarm
+func (c *Cli) GetEngineUnavailableAfter() time.Duration {
+	return viper.GetDuration("engine_availability.alarm_after")
+}
+
+// GetEngineUnavailableServiceStatus returns the health status which the services of an unavailable
+// engine are set to (unknown or down), or an empty string if the health status is not changed
+func (c *Cli) GetEngineUnavailableServiceStatus() string {
+	switch v := strings.ToLower(strings.TrimSpace(viper.GetString("engine_availability.service_status"))); v {
+	case "", tedge.StatusUnknown, tedge.StatusDown:
+		return v
+	default:
+		slog.Warn("Invalid engine unavailable service status. The health status of the services is not changed.", "value", v)
+		return ""
+	}
+}
+
+// GetTwinOptions returns the optional container details which are included in the container twin
+func (c *Cli) GetTwinOptions() container.TwinOptions {
+	fields := make([]string, 0)
+	for _, field := range viper.GetStringSlice("twin.fields") {
+		if !slices.Contains(container.TwinFields, field) {
+			slog.Warn("Ignoring unknown twin field.", "field", field, "supported", container.TwinFields)
+			continue
+		}
+		fields = append(fields, field)
+	}
+	redactPatterns, err := container.CompileRedactPatterns(viper.GetStringSlice("twin.env_redact_patterns"))
+	if err != nil && slices.Contains(fields, container.TwinFieldEnv) {
+		// Fail closed, otherwise the variables which should be redacted would be published
+		slog.Error("Excluding the env twin field as the redact patterns are invalid.", "err", err)
+		fields = slices.DeleteFunc(fields, func(field string) bool {
+			return field == container.TwinFieldEnv
+		})
+	}
+	return container.TwinOptions{
+		Fields:            fields,
+		Labels:            viper.GetStringSlice("twin.labels"),
+		EnvRedactPatterns: redactPatterns,
+	}
+}
+
+// GetEventTypes returns the engine event types which are published, e.g. container, image, network and volume
+func (c *Cli) GetEventTypes() []string {
+	return viper.GetStringSlice("events.types")
+}
+
+// GetEventActions returns the actions which are published per event type. The default actions
+// are used for the event types which are not included
+func (c *Cli) GetEventActions() map[string][]string {
+	return viper.GetStringMapStringSlice("events.actions")
+}
+
+// GetEventIncludeLabels returns the labels ("key" or "key=value") of which the events must have at least one
+func (c *Cli) GetEventIncludeLabels() []string {
+	return viper.GetStringSlice("events.include_labels")
+}
+
+// GetEventExcludeLabels returns the labels ("key" or "key=value") of the events which are not published
+func (c *Cli) GetEventExcludeLabels() []string {
+	return viper.GetStringSlice("events.exclude_labels")
+}
+
+// GetEventTypeNames returns the thin-edge event types per engine event type, e.g. "image_{action}"
+func (c *Cli) GetEventTypeNames() map[string]string {
+	return viper.GetStringMapString("events.type_names")
+}
+
+// ContainerExecEnabled checks if the container_exec operation (to run a command inside a container)
+// is declared and handled on the container services
+func (c *Cli) ContainerExecEnabled() bool {
+	return c.ContainerOperationsEnabled() && viper.GetBool("operations.exec.enabled")
+}
+
+func (c *Cli) GetContainerExecTimeout() time.Duration {
+	return viper.GetDuration("operations.exec.timeout")
+}
+
+// GetContainerExecMaxOutput returns the maximum size (in bytes) of the output of the container_exec operation
+func (c *Cli) GetContainerExecMaxOutput() int {
+	return viper.GetInt("operations.exec.max_output")
+}
+
+// GetContainerExecAllowedCommands returns the commands which can be run using the container_exec
+// operation. All commands are allowed if the list is empty
+func (c *Cli) GetContainerExecAllowedCommands() []string {
+	return viper.GetStringSlice("operations.exec.allowed_commands")
+}
+
+// GetDiagnosticsLogLines returns the number of log lines included for each container in the diagnostics
+func (c *Cli) GetDiagnosticsLogLines() int {
+	return viper.GetInt("operations.diagnostics.log_lines")
+}
+
+// ContainerOperationsEnabled checks if the container operations (e.g. container_restart) are
+// declared and handled on the container services
+func (c *Cli) ContainerOperationsEnabled() bool {
+	return viper.GetBool("operations.enabled")
+}
+
 func (c *Cli) DeleteFromCloud() bool {
 	return viper.GetBool("delete_from_cloud.enabled")
 }
@@ -190,10 +455,40 @@ func (c *Cli) GetCrashLoopThreshold() int {
 	return viper.GetInt("container.crash_loop_threshold")
 }
 
+func (c *Cli) GetCrashLoopAction() string {
+	return strings.TrimSpace(viper.GetString("container.crash_loop_action"))
+}
+
+func (c *Cli) GetOOMClearAfter() time.Duration {
+	return viper.GetDuration("container.oom_clear_after")
+}
+
+func (c *Cli) GetUnhealthyAlarmAfter() time.Duration {
+	return viper.GetDuration("container.unhealthy_alarm_after")
+}
+
+func (c *Cli) ExitAlarmEnabled() bool {
+	return viper.GetBool("container.exit_alarm")
+}
+
+func (c *Cli) GetExitAlarmSeverity() string {
+	switch v := strings.ToUpper(strings.TrimSpace(viper.GetString("container.exit_alarm_severity"))); v {
+	case "CRITICAL", "MAJOR", "MINOR", "WARNING":
+		return v
+	default:
+		slog.Warn("Invalid exit alarm severity. Using the default.", "value", v, "default", "MAJOR")
+		return "MAJOR"
+	}
+}
+
 func (c *Cli) UseModuleNameForService() bool {
 	return viper.GetBool("container_group.use_module_name")
 }
 
+func (c *Cli) GetContainerGroupMode() string {
+	return strings.ToLower(strings.TrimSpace(viper.GetString("container_group.mode")))
+}
+
 func (c *Cli) GetHTTPHost() string {
 	return viper.GetString("client.http.host")
 }
@@ -250,6 +545,18 @@ func (c *Cli) GetReconcileInterval() time.Duration {
 	return interval
 }
 
+// GetPublishRefreshInterval returns the maximum time between publishing unchanged twin and health
+// messages. The twin and health messages are only published when they change, however they are
+// periodically republished so that the cloud recovers from any lost messages.
+// A value of 0 (or less) publishes the messages on every update.
+func (c *Cli) GetPublishRefreshInterval() time.Duration {
+	interval := viper.GetDuration("reconcile.refresh_interval")
+	if interval <= 0 {
+		return 0
+	}
+	return interval
+}
+
 // GetSyncRetryInterval returns the delay before a failed cloud sync is
 // retried, e.g. a pending cloud service deletion which failed because the
 // local Cumulocity proxy was unavailable. The retry is scheduled by the
@@ -268,6 +575,65 @@ func (c *Cli) GetSyncRetryInterval() time.Duration {
 	return interval
 }
 
+// StatusAPIEnabled returns true if the local status API should be served by the run command
+func (c *Cli) StatusAPIEnabled() bool {
+	return viper.GetBool("status_api.enabled")
+}
+
+// GetStatusAPIAddress returns the address of the local status API, either a unix socket
+// (e.g. "unix:///run/tedge-container-plugin/status.sock") or a tcp address (e.g. "127.0.0.1:8091")
+func (c *Cli) GetStatusAPIAddress() string {
+	return viper.GetString("status_api.address")
+}
+
+// PrometheusEnabled returns true if the metrics should be exposed in the OpenMetrics format
+func (c *Cli) PrometheusEnabled() bool {
+	return viper.GetBool("prometheus.enabled")
+}
+
+// GetPrometheusAddress returns the address of the metrics exporter, either a tcp address
+// (e.g. "0.0.0.0:9464") or a unix socket (e.g. "unix:///run/tedge-container-plugin/metrics.sock")
+func (c *Cli) GetPrometheusAddress() string {
+	return viper.GetString("prometheus.address")
+}
+
+// GetImageUpdatesInterval returns how often the registries are checked for
+// newer images of the managed containers (by resolving the image tag, without pulling).
+// A value of 0 (or less), or disabling image_updates.enabled, turns off the check.
+func (c *Cli) GetImageUpdatesInterval() time.Duration {
+	if !viper.GetBool("image_updates.enabled") {
+		return 0
+	}
+	interval := viper.GetDuration("image_updates.interval")
+	if interval <= 0 {
+		return 0
+	}
+	if interval < 5*time.Minute {
+		slog.Warn("image_updates.interval is lower than allowed limit.", "old", interval, "new", 5*time.Minute)
+		interval = 5 * time.Minute
+	}
+	return interval
+}
+
+// GetImageAutoUpdateWindow returns the daily time window (local time) in which
+// containers which opted in to automatic updates are updated.
+// A nil window (the default) allows updates at any time.
+func (c *Cli) GetImageAutoUpdateWindow() *utils.TimeWindow {
+	window, err := utils.ParseTimeWindow(viper.GetString("image_updates.auto_update.window"))
+	if err != nil {
+		// Fail closed so that containers are not unexpectedly updated
+		slog.Warn("Invalid image_updates.auto_update.window. Automatic updates are disabled.", "err", err)
+		return &utils.TimeWindow{}
+	}
+	return window
+}
+
+// GetImageAutoUpdateHealthyAfter returns how long an automatically updated container
+// must remain healthy before the update is accepted
+func (c *Cli) GetImageAutoUpdateHealthyAfter() time.Duration {
+	return viper.GetDuration("image_updates.auto_update.healthy_after")
+}
+
 func (c *Cli) GetMQTTPort() uint16 {
 	v := viper.GetUint16("client.mqtt.port")
 	if v == 0 {
@@ -299,6 +665,18 @@ func (c *Cli) GetDeviceTarget() tedge.Target {
 	}
 }
 
+// GetMonitorStateFile returns the path to the file used to persist the crash loop detection
+// state of the run command across restarts. An empty string is returned if there is no
+// writable persistent directory
+func (c *Cli) GetMonitorStateFile() string {
+	dir, err := c.PersistentDir(true)
+	if err != nil {
+		slog.Warn("Could not find a writable directory to persist the monitor state.", "err", err)
+		return ""
+	}
+	return filepath.Join(dir, "monitor-state.json")
+}
+
 func (c *Cli) PersistentDir(check_writable bool) (string, error) {
 	paths := append(viper.GetStringSlice("data_dir"), filepath.Join(os.TempDir(), c.GetServiceName()))
 
@@ -328,18 +706,51 @@ func (c *Cli) PersistentDir(check_writable bool) (string, error) {
 	return "", fmt.Errorf("no writable working directory detected")
 }
 
+// GetComposeDir returns the directory of the container-groups (compose projects) which are deployed by the
+// plugin, or an empty string if there is no working directory
+func (c *Cli) GetComposeDir() string {
+	persistentDir, err := c.PersistentDir(true)
+	if err != nil {
+		slog.Warn("Could not find the working directory of the container-groups.", "err", err)
+		return ""
+	}
+	return filepath.Join(persistentDir, "compose")
+}
+
 func (c *Cli) GetRegistryCredentialsPath() string {
 	return viper.GetString("registry.credentials_path")
 }
 
+// GetDockerConfigPath returns the path to the docker config.json file used to
+// lookup registry credentials (auths, credHelpers and credsStore).
+// If not set, the location used by the docker cli is used.
+func (c *Cli) GetDockerConfigPath() string {
+	if v := viper.GetString("registry.docker_config"); v != "" {
+		return v
+	}
+	return DefaultDockerConfigPath()
+}
+
 type RepositoryAuth struct {
-	URL      string
-	Username string `json:"username"`
-	Password string `json:"password"`
+	URL           string
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"-"`
+
+	// ExpiresAt is an optional time when the credentials expire (e.g. for short lived tokens)
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 func (a *RepositoryAuth) IsSet() bool {
-	return a.Username != "" && a.Password != ""
+	return (a.Username != "" && a.Password != "") || a.IdentityToken != ""
+}
+
+// Encode the credentials so they can be used in the registry auth header
+func (a *RepositoryAuth) Encode() string {
+	if a.IdentityToken != "" {
+		return container.GetRegistryIdentityTokenAuth(a.IdentityToken)
+	}
+	return container.GetRegistryAuth(a.Username, a.Password)
 }
 
 func GetImageSource(v string) string {
@@ -389,10 +800,208 @@ func (c *Cli) GetRegistryCredentials(url string) RepositoryAuth {
 	return creds
 }
 
+// GetDockerConfigCredentials looks up the registry credentials for an image in the
+// docker config.json file. Empty credentials are returned if the file does not exist
+func (c *Cli) GetDockerConfigCredentials(ctx context.Context, imageRef string) (RepositoryAuth, error) {
+	configPath := c.GetDockerConfigPath()
+	if configPath == "" || !utils.PathExists(configPath) {
+		return RepositoryAuth{}, nil
+	}
+	dockerConfig, err := LoadDockerConfig(configPath)
+	if err != nil {
+		return RepositoryAuth{}, err
+	}
+	registry := GetImageSource(imageRef)
+	slog.Info("Looking for credentials in docker config.", "path", configPath, "registry", registry)
+	return dockerConfig.GetCredentials(ctx, registry)
+}
+
+func (c *Cli) CredentialsCacheEnabled() bool {
+	return viper.GetBool("registry.credentials_cache.enabled")
+}
+
+// GetCredentialsCacheTTL returns how long registry credentials are cached
+// when the credentials don't include an explicit expiration time
+func (c *Cli) GetCredentialsCacheTTL() time.Duration {
+	return viper.GetDuration("registry.credentials_cache.ttl")
+}
+
+// CredentialsCachePersist controls whether the credential cache is persisted
+// (encrypted) in the persistent directory so that it can be shared between
+// the short lived cli invocations
+func (c *Cli) CredentialsCachePersist() bool {
+	return viper.GetBool("registry.credentials_cache.persist")
+}
+
+var (
+	credentialCache     *CredentialCache
+	credentialCacheOnce sync.Once
+)
+
+// GetCredentialCache returns the process wide registry credential cache.
+// nil is returned if the cache is disabled
+func (c *Cli) GetCredentialCache() *CredentialCache {
+	if !c.CredentialsCacheEnabled() || c.GetCredentialsCacheTTL() <= 0 {
+		return nil
+	}
+	credentialCacheOnce.Do(func() {
+		credentialCache = NewCredentialCache(c.GetCredentialsCacheTTL())
+		if c.CredentialsCachePersist() {
+			if dir, err := c.PersistentDir(true); err == nil {
+				credentialCache.WithPersistence(
+					filepath.Join(dir, "credentials-cache.enc"),
+					filepath.Join(dir, SecretsKeyFile),
+				)
+			} else {
+				slog.Warn("Could not persist the credential cache.", "err", err)
+			}
+		}
+	})
+	return credentialCache
+}
+
+// GetCloudCredentialsConfigType returns the config type (used by the config_update operation)
+// which delivers registry credentials from the cloud. An empty value disables the feature
+func (c *Cli) GetCloudCredentialsConfigType() string {
+	return viper.GetString("registry.cloud_credentials.type")
+}
+
+// GetCloudCredentialsPath returns the path where the tedge-agent writes the
+// registry credentials delivered via the config_update operation. Only this
+// file is imported (and removed afterwards).
+func (c *Cli) GetCloudCredentialsPath() string {
+	return viper.GetString("registry.cloud_credentials.path")
+}
+
+// GetCloudCredentialStore returns the store of the registry credentials which
+// were delivered from the cloud
+func (c *Cli) GetCloudCredentialStore() (*CloudCredentialStore, error) {
+	dir, err := c.PersistentDir(false)
+	if err != nil {
+		return nil, err
+	}
+	return NewCloudCredentialStore(
+		filepath.Join(dir, CloudCredentialsFile),
+		filepath.Join(dir, SecretsKeyFile),
+	), nil
+}
+
+// ImportCloudRegistryCredentials validates the registry credentials file delivered
+// from the cloud and stores them (encrypted) in the persistent directory.
+// The plain text file is removed after it has been processed (even if it is invalid) so
+// that the secrets are not left on disk. The list of registries is returned.
+// Only the configured credentials file is accepted, and it must not be a symlink
+func (c *Cli) ImportCloudRegistryCredentials(path string) ([]string, error) {
+	if expected := c.GetCloudCredentialsPath(); !utils.SamePath(path, expected) {
+		return nil, fmt.Errorf("registry credentials file is not the configured file. path=%s, expected=%s", path, expected)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("registry credentials file is not a regular file. path=%s", path)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Could not remove registry credentials file.", "path", path, "err", err)
+		}
+	}()
+
+	credentials, err := ParseRegistryCredentials(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := c.PersistentDir(true)
+	if err != nil {
+		return nil, err
+	}
+	store := NewCloudCredentialStore(
+		filepath.Join(dir, CloudCredentialsFile),
+		filepath.Join(dir, SecretsKeyFile),
+	)
+	if err := store.Save(credentials); err != nil {
+		return nil, err
+	}
+
+	// Don't continue using any previously cached credentials
+	if cache := c.GetCredentialCache(); cache != nil {
+		cache.Clear()
+	}
+
+	registries := make([]string, 0, len(credentials))
+	for _, creds := range credentials {
+		registries = append(registries, creds.URL)
+	}
+	slog.Info("Stored registry credentials delivered from the cloud.", "registries", registries)
+	return registries, nil
+}
+
+// GetCloudRegistryCredentials returns the credentials delivered from the cloud
+// which match the registry of an image
+func (c *Cli) GetCloudRegistryCredentials(imageRef string) (RepositoryAuth, error) {
+	store, err := c.GetCloudCredentialStore()
+	if err != nil {
+		return RepositoryAuth{}, err
+	}
+	return store.Get(imageRef)
+}
+
+// InvalidateRepositoryCredentialsFunc returns a function which removes the cached
+// credentials of an image, e.g. when the registry rejected them
+func (c *Cli) InvalidateRepositoryCredentialsFunc(imageRef string) func(ctx context.Context, err error) {
+	return func(ctx context.Context, err error) {
+		if cache := c.GetCredentialCache(); cache != nil {
+			slog.Info("Registry authentication failed.", "ref", imageRef, "err", err)
+			cache.Invalidate(imageRef)
+		}
+	}
+}
+
 func (c *Cli) GetContainerRepositoryCredentialsFunc(imageRef string) func(ctx context.Context, attempt int) (string, error) {
 	return func(ctx context.Context, attempt int) (string, error) {
+		cache := c.GetCredentialCache()
+		if cache != nil {
+			if attempt > 1 {
+				// The previous attempt failed, so don't trust the cache
+				cache.Invalidate(imageRef)
+			} else if cachedCreds, ok := cache.Get(imageRef); ok {
+				slog.Info("Pulling image from private registry using cached credentials.", "ref", imageRef, "username", cachedCreds.Username)
+				return cachedCreds.Encode(), nil
+			}
+		}
+
+		// Check credentials delivered from the cloud, as they are more likely
+		// to be up to date than the static credentials config
+		creds, err := c.GetCloudRegistryCredentials(imageRef)
+		if err != nil {
+			slog.Warn("Failed to read registry credentials delivered from the cloud.", "err", err)
+		} else if creds.IsSet() {
+			slog.Info("Using registry credentials delivered from the cloud.", "url", creds.URL, "username", creds.Username)
+		}
+
 		// Check credentials config
-		creds := c.GetRegistryCredentials(imageRef)
+		if !creds.IsSet() {
+			creds = c.GetRegistryCredentials(imageRef)
+		}
+
+		// Check docker config (auths, credHelpers and credsStore)
+		if !creds.IsSet() {
+			helperCtx, cancelHelper := context.WithTimeout(ctx, 60*time.Second)
+			defer cancelHelper()
+			if dockerCreds, err := c.GetDockerConfigCredentials(helperCtx, imageRef); err != nil {
+				// Don't fail as the registry-credentials script might still provide the credentials
+				slog.Warn("Failed to get registry credentials from docker config.", "err", err)
+			} else if dockerCreds.IsSet() {
+				slog.Info("Using registry credentials from docker config.", "url", dockerCreds.URL, "username", dockerCreds.Username)
+				creds = dockerCreds
+			}
+		}
 
 		// Check credentials helper script
 		credentialsScript := "registry-credentials"
@@ -414,13 +1023,18 @@ func (c *Cli) GetContainerRepositoryCredentialsFunc(imageRef string) func(ctx co
 					slog.Info("Using registry credentials returned by a helper.", "script", credentialsScript, "username", customCreds.Username)
 					creds.Username = customCreds.Username
 					creds.Password = customCreds.Password
+					creds.IdentityToken = ""
+					creds.ExpiresAt = customCreds.ExpiresAt
 				}
 			}
 		}
 
 		if creds.IsSet() {
+			if cache != nil {
+				cache.Set(imageRef, creds)
+			}
 			slog.Info("Pulling image from private registry.", "ref", imageRef, "username", creds.Username)
-			return container.GetRegistryAuth(creds.Username, creds.Password), nil
+			return creds.Encode(), nil
 		} else {
 			slog.Info("Pulling image.", "ref", imageRef)
 		}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetContainerHosts(t *testing.T) {
+	testcases := []struct {
+		Input  any
+		Expect []string
+	}{
+		{Input: "", Expect: []string{}},
+		{Input: "unix:///run/podman/podman.sock", Expect: []string{"unix:///run/podman/podman.sock"}},
+		{Input: "unix:///var/run/docker.sock, unix:///run/podman/podman.sock", Expect: []string{"unix:///var/run/docker.sock", "unix:///run/podman/podman.sock"}},
+		{Input: []any{"unix:///var/run/docker.sock", "", "unix:///run/podman/podman.sock"}, Expect: []string{"unix:///var/run/docker.sock", "unix:///run/podman/podman.sock"}},
+		{Input: []string{"unix:///var/run/docker.sock", "unix:///var/run/docker.sock"}, Expect: []string{"unix:///var/run/docker.sock"}},
+	}
+	t.Cleanup(func() { viper.Set("container.host", nil) })
+	c := &Cli{}
+	for _, tc := range testcases {
+		viper.Set("container.host", tc.Input)
+		assert.Equal(t, tc.Expect, c.GetContainerHosts(), "input=%v", tc.Input)
+	}
+
+	viper.Set("container.host", []string{"unix:///var/run/docker.sock", "unix:///run/podman/podman.sock"})
+	assert.Equal(t, "unix:///var/run/docker.sock", c.GetContainerHost())
+}
+
+func Test_GetEffectiveSettings(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Set("client.key", nil)
+		viper.Set("test.registry.password", nil)
+		viper.Set("test.api_token", nil)
+	})
+	viper.Set("client.key", "/etc/tedge/device-certs/local-tedge.key")
+	viper.Set("test.registry.password", "example")
+	viper.Set("test.api_token", "example")
+
+	c := &Cli{}
+	settings := c.GetEffectiveSettings()
+	assert.Equal(t, "/etc/tedge/device-certs/local-tedge.key", settings["client"].(map[string]any)["key"])
+	testSettings := settings["test"].(map[string]any)
+	assert.Equal(t, "********", testSettings["registry"].(map[string]any)["password"])
+	assert.Equal(t, "********", testSettings["api_token"])
+}
+
+func Test_GetEngineUnavailableServiceStatus(t *testing.T) {
+	t.Cleanup(func() { viper.Set("engine_availability.service_status", nil) })
+	c := &Cli{}
+	for input, expect := range map[string]string{"": "", "Unknown": "unknown", " down ": "down", "up": ""} {
+		viper.Set("engine_availability.service_status", input)
+		assert.Equal(t, expect, c.GetEngineUnavailableServiceStatus(), "input=%s", input)
+	}
+}
+
+func Test_GetTwinOptionsInvalidRedactPattern(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Set("twin.fields", nil)
+		viper.Set("twin.env_redact_patterns", nil)
+	})
+	viper.Set("twin.fields", []string{"health", "env"})
+	c := &Cli{}
+
+	viper.Set("twin.env_redact_patterns", []string{"^CUSTOM_"})
+	assert.Equal(t, []string{"health", "env"}, c.GetTwinOptions().Fields)
+
+	// The env field is excluded rather than publishing values which should be redacted
+	viper.Set("twin.env_redact_patterns", []string{"^CUSTOM_", "(unclosed"})
+	assert.Equal(t, []string{"health"}, c.GetTwinOptions().Fields)
+}
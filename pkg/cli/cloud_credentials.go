@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Default config type used to deliver registry credentials via the
+// thin-edge.io config_update operation
+const DefaultRegistryCredentialsConfigType = "tedge-container-plugin-registries"
+
+// Name of the file (in the persistent directory) which stores the encryption key
+// secret used by the encrypted files, e.g. the credential cache
+const SecretsKeyFile = "secrets.key"
+
+// Name of the file (in the persistent directory) storing the registry credentials
+// which were delivered from the cloud
+const CloudCredentialsFile = "registry-credentials.enc"
+
+// CloudCredentialStore stores registry credentials which were delivered from the
+// cloud (e.g. via a config_update operation) in an encrypted file.
+// The file is read on each lookup so that new credentials are used without
+// restarting any of the processes.
+type CloudCredentialStore struct {
+	file *SecureFile
+}
+
+func NewCloudCredentialStore(path string, keyPath string) *CloudCredentialStore {
+	return &CloudCredentialStore{
+		file: NewSecureFile(path, keyPath),
+	}
+}
+
+// storedRegistryCredentials is the persisted representation of the credentials
+type storedRegistryCredentials struct {
+	Repo      string    `json:"repo"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ParseRegistryCredentials parses and validates registry credentials using the
+// same format as the credentials.toml file, e.g.
+//
+//	[registry1]
+//	repo = "docker.io"
+//	username = "example"
+//	password = "secret"
+//
+// Any number of sections are supported, and an optional expiresAt (RFC3339) can
+// be set per registry. The whole file is rejected if any of the entries are invalid.
+func ParseRegistryCredentials(contents []byte) ([]RepositoryAuth, error) {
+	config := viper.New()
+	config.SetConfigType("toml")
+	if err := config.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return nil, fmt.Errorf("invalid toml. %w", err)
+	}
+
+	sections := make([]string, 0)
+	for key, value := range config.AllSettings() {
+		if _, ok := value.(map[string]any); ok {
+			sections = append(sections, key)
+		}
+	}
+	slices.Sort(sections)
+
+	errs := make([]error, 0)
+	credentials := make([]RepositoryAuth, 0, len(sections))
+	repos := make(map[string]struct{})
+	for _, section := range sections {
+		repo := config.GetString(section + ".repo")
+		if repo == "" {
+			// Ignore any unrelated sections
+			continue
+		}
+		creds := RepositoryAuth{
+			URL:      repo,
+			Username: config.GetString(section + ".username"),
+			Password: config.GetString(section + ".password"),
+		}
+		if strings.ContainsAny(repo, "/ \t") {
+			errs = append(errs, fmt.Errorf("%s: repo must be a registry hostname without a scheme or path. got=%s", section, repo))
+		}
+		if creds.Username == "" || creds.Password == "" {
+			errs = append(errs, fmt.Errorf("%s: username and password are required", section))
+		}
+		if v := config.GetString(section + ".expiresAt"); v != "" {
+			expiresAt, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid expiresAt. %w", section, err))
+			}
+			creds.ExpiresAt = expiresAt
+		}
+		if _, exists := repos[strings.ToLower(repo)]; exists {
+			errs = append(errs, fmt.Errorf("%s: duplicate repo. repo=%s", section, repo))
+		}
+		repos[strings.ToLower(repo)] = struct{}{}
+		credentials = append(credentials, creds)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("no registry credentials found")
+	}
+	return credentials, nil
+}
+
+// Save replaces all of the stored credentials
+func (s *CloudCredentialStore) Save(credentials []RepositoryAuth) error {
+	data := make([]storedRegistryCredentials, 0, len(credentials))
+	for _, creds := range credentials {
+		data = append(data, storedRegistryCredentials{
+			Repo:      creds.URL,
+			Username:  creds.Username,
+			Password:  creds.Password,
+			ExpiresAt: creds.ExpiresAt,
+		})
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.file.Write(b)
+}
+
+// Load all of the stored credentials. An empty list is returned if no
+// credentials have been stored
+func (s *CloudCredentialStore) Load() ([]RepositoryAuth, error) {
+	b, err := s.file.Read()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []RepositoryAuth{}, nil
+		}
+		return nil, err
+	}
+	data := make([]storedRegistryCredentials, 0)
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	credentials := make([]RepositoryAuth, 0, len(data))
+	for _, item := range data {
+		credentials = append(credentials, RepositoryAuth{
+			URL:       item.Repo,
+			Username:  item.Username,
+			Password:  item.Password,
+			ExpiresAt: item.ExpiresAt,
+		})
+	}
+	return credentials, nil
+}
+
+// Get the stored credentials matching the registry of an image.
+// Expired credentials are ignored.
+func (s *CloudCredentialStore) Get(imageRef string) (RepositoryAuth, error) {
+	credentials, err := s.Load()
+	if err != nil {
+		return RepositoryAuth{}, err
+	}
+	registry := GetImageSource(imageRef)
+	for _, creds := range credentials {
+		if !strings.EqualFold(NormalizeRegistryHostname(creds.URL), registry) {
+			continue
+		}
+		if !creds.ExpiresAt.IsZero() && !time.Now().Before(creds.ExpiresAt) {
+			slog.Warn("Registry credentials delivered from the cloud have expired.", "url", creds.URL, "expiresAt", creds.ExpiresAt)
+			continue
+		}
+		return creds, nil
+	}
+	return RepositoryAuth{}, nil
+}
+
+// Clear removes all of the stored credentials
+func (s *CloudCredentialStore) Clear() error {
+	return s.file.Remove()
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseRegistryCredentials(t *testing.T) {
+	credentials, err := ParseRegistryCredentials([]byte(`
+[registry1]
+repo = "ghcr.io"
+username = "user1"
+password = "pass1"
+expiresAt = "2025-01-01T12:00:00Z"
+
+[registry5]
+repo = "quay.io"
+username = "user5"
+password = "pass5"
+`))
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 2)
+	assert.Equal(t, "ghcr.io", credentials[0].URL)
+	assert.Equal(t, "pass1", credentials[0].Password)
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), credentials[0].ExpiresAt.UTC())
+	assert.Equal(t, "quay.io", credentials[1].URL)
+	assert.True(t, credentials[1].ExpiresAt.IsZero())
+}
+
+func Test_ParseRegistryCredentialsInvalid(t *testing.T) {
+	testcases := []struct {
+		Name  string
+		Input string
+	}{
+		{Name: "missing password", Input: "[registry1]\nrepo = \"ghcr.io\"\nusername = \"user\"\n"},
+		{Name: "repo with scheme", Input: "[registry1]\nrepo = \"https://ghcr.io\"\nusername = \"user\"\npassword = \"pass\"\n"},
+		{Name: "invalid expiresAt", Input: "[registry1]\nrepo = \"ghcr.io\"\nusername = \"user\"\npassword = \"pass\"\nexpiresAt = \"tomorrow\"\n"},
+		{Name: "duplicate repo", Input: "[a]\nrepo = \"ghcr.io\"\nusername = \"user\"\npassword = \"pass\"\n[b]\nrepo = \"GHCR.io\"\nusername = \"user\"\npassword = \"pass\"\n"},
+		{Name: "empty", Input: ""},
+		{Name: "invalid toml", Input: "[registry1"},
+	}
+	for _, tc := range testcases {
+		_, err := ParseRegistryCredentials([]byte(tc.Input))
+		assert.Error(t, err, tc.Name)
+	}
+}
+
+func Test_CloudCredentialStore(t *testing.T) {
+	dir := t.TempDir()
+	storeFile := filepath.Join(dir, CloudCredentialsFile)
+	store := NewCloudCredentialStore(storeFile, filepath.Join(dir, SecretsKeyFile))
+
+	// No credentials
+	creds, err := store.Get("ghcr.io/thin-edge/tedge:latest")
+	assert.NoError(t, err)
+	assert.False(t, creds.IsSet())
+
+	assert.NoError(t, store.Save([]RepositoryAuth{
+		{URL: "ghcr.io", Username: "user", Password: "secret-password"},
+		{URL: "docker.io", Username: "hubuser", Password: "hubsecret"},
+		{URL: "quay.io", Username: "expired", Password: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+	}))
+
+	contents, err := os.ReadFile(storeFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(contents), "secret-password")
+
+	creds, err = store.Get("ghcr.io/thin-edge/tedge:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-password", creds.Password)
+
+	creds, err = store.Get("nginx:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "hubuser", creds.Username)
+
+	creds, err = store.Get("quay.io/other/app:1.0.0")
+	assert.NoError(t, err)
+	assert.False(t, creds.IsSet())
+
+	assert.NoError(t, store.Clear())
+	creds, err = store.Get("ghcr.io/thin-edge/tedge:latest")
+	assert.NoError(t, err)
+	assert.False(t, creds.IsSet())
+}
+
+func Test_ImportCloudRegistryCredentialsRejectsOtherPaths(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { viper.Set("registry.cloud_credentials.path", nil) })
+	viper.Set("registry.cloud_credentials.path", filepath.Join(dir, "registries.toml"))
+
+	c := &Cli{}
+	other := filepath.Join(dir, "tedge.toml")
+	assert.NoError(t, os.WriteFile(other, []byte("[device]\n"), 0644))
+	_, err := c.ImportCloudRegistryCredentials(other)
+	assert.Error(t, err)
+	_, err = c.ImportCloudRegistryCredentials(filepath.Join(dir, "sub", "..", "tedge.toml"))
+	assert.Error(t, err)
+
+	// The configured path must not be a symlink to another file
+	assert.NoError(t, os.Symlink(other, filepath.Join(dir, "registries.toml")))
+	_, err = c.ImportCloudRegistryCredentials(filepath.Join(dir, "registries.toml"))
+	assert.Error(t, err)
+
+	// Other files are never removed
+	assert.FileExists(t, other)
+}
@@ -0,0 +1,286 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+)
+
+// CredentialCache is an in-process cache of registry credentials so that the
+// credential helpers (e.g. the registry-credentials script) are not executed
+// for every image which is pulled, e.g. for each image in a container-group.
+//
+// Entries are keyed by the registry and path prefix of the image (e.g. "ghcr.io/thin-edge")
+// and expire either at the expiresAt time returned by the helper, or after the TTL.
+//
+// The cache can optionally be persisted (see SecureFile) to disk so that short lived
+// cli invocations (e.g. the software management plugins) can share the cache. The persisted
+// entries are merged with the in-memory entries (under a file lock) before the cache is written,
+// where the most recently updated entry wins. Invalidated entries are kept as tombstones until
+// they expire, so that another process can't restore credentials which were rejected.
+type CredentialCache struct {
+	mu      sync.Mutex
+	entries map[string]credentialCacheEntry
+	ttl     time.Duration
+	now     func() time.Time
+
+	// Optional persistence
+	file *SecureFile
+}
+
+type credentialCacheEntry struct {
+	Credentials RepositoryAuth
+	ExpiresAt   time.Time
+	UpdatedAt   time.Time
+	// Invalidated entries (tombstones) don't have any credentials
+	Invalidated bool
+}
+
+// persisted representation, as RepositoryAuth does not serialize all of its fields
+type persistedCredentials struct {
+	URL           string    `json:"url,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	Password      string    `json:"password,omitempty"`
+	IdentityToken string    `json:"identityToken,omitempty"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	Invalidated   bool      `json:"invalidated,omitempty"`
+}
+
+// NewCredentialCache creates an in-memory credential cache where entries
+// expire after ttl (unless the credentials have an explicit expiration time)
+func NewCredentialCache(ttl time.Duration) *CredentialCache {
+	return &CredentialCache{
+		entries: make(map[string]credentialCacheEntry),
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// WithPersistence enables the persistence of the cache to an encrypted file.
+// The encryption key secret is stored in keyPath (created if it does not exist).
+// Any existing entries are loaded from the file.
+func (c *CredentialCache) WithPersistence(path string, keyPath string) *CredentialCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file = NewSecureFile(path, keyPath)
+	entries, err := c.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Could not load credential cache. Ignoring existing cache.", "path", path, "err", err)
+	}
+	c.merge(entries)
+	slog.Info("Loaded credential cache.", "path", c.file.Path, "entries", len(c.entries))
+	return c
+}
+
+// CredentialCacheKey returns the cache key for an image reference, which is
+// the registry and the repository path excluding the image name,
+// e.g. "ghcr.io/thin-edge/tedge:1.0.0" => "ghcr.io/thin-edge"
+func CredentialCacheKey(imageRef string) string {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return GetImageSource(imageRef)
+	}
+	domain := reference.Domain(named)
+	path := reference.Path(named)
+	if i := strings.LastIndex(path, "/"); i > -1 {
+		return domain + "/" + path[:i]
+	}
+	return domain
+}
+
+// Get returns the cached credentials for an image (if they have not expired)
+func (c *CredentialCache) Get(imageRef string) (RepositoryAuth, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := CredentialCacheKey(imageRef)
+	entry, ok := c.entries[key]
+	if !ok {
+		return RepositoryAuth{}, false
+	}
+	if !c.now().Before(entry.ExpiresAt) {
+		if !entry.Invalidated {
+			slog.Info("Cached registry credentials have expired.", "key", key, "expiresAt", entry.ExpiresAt)
+		}
+		delete(c.entries, key)
+		c.save()
+		return RepositoryAuth{}, false
+	}
+	if entry.Invalidated {
+		return RepositoryAuth{}, false
+	}
+	return entry.Credentials, true
+}
+
+// Set stores the credentials for an image. The entry expires at the
+// credential's ExpiresAt time (if set), otherwise after the cache's TTL.
+func (c *CredentialCache) Set(imageRef string, creds RepositoryAuth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	expiresAt := creds.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(c.ttl)
+	}
+	key := CredentialCacheKey(imageRef)
+	slog.Info("Caching registry credentials.", "key", key, "expiresAt", expiresAt)
+	c.entries[key] = credentialCacheEntry{
+		Credentials: creds,
+		ExpiresAt:   expiresAt,
+		UpdatedAt:   now,
+	}
+	c.save()
+}
+
+// Invalidate removes any cached credentials related to an image,
+// e.g. after the registry rejected the credentials
+func (c *CredentialCache) Invalidate(imageRef string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := CredentialCacheKey(imageRef)
+	entry, ok := c.entries[key]
+	if (!ok || entry.Invalidated) && c.file == nil {
+		return
+	}
+	slog.Info("Invalidating cached registry credentials.", "key", key)
+	c.entries[key] = c.tombstone(entry)
+	c.save()
+}
+
+// Clear removes all cached credentials, e.g. after new credentials
+// were provided by the user
+func (c *CredentialCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) == 0 && c.file == nil {
+		return
+	}
+	slog.Info("Clearing cached registry credentials.")
+	if c.file == nil {
+		c.entries = make(map[string]credentialCacheEntry)
+		return
+	}
+	c.update(func() {
+		for key, entry := range c.entries {
+			if !entry.Invalidated {
+				c.entries[key] = c.tombstone(entry)
+			}
+		}
+	})
+}
+
+// tombstone returns the invalidated entry which replaces an entry. The tombstone is kept
+// at least as long as the entry, so that a process which still has the entry can't restore it
+func (c *CredentialCache) tombstone(entry credentialCacheEntry) credentialCacheEntry {
+	now := c.now()
+	expiresAt := now.Add(c.ttl)
+	if entry.ExpiresAt.After(expiresAt) {
+		expiresAt = entry.ExpiresAt
+	}
+	return credentialCacheEntry{
+		ExpiresAt:   expiresAt,
+		UpdatedAt:   now,
+		Invalidated: true,
+	}
+}
+
+// save writes the cache to disk (if persistence is enabled).
+// The caller must hold the lock.
+func (c *CredentialCache) save() {
+	if c.file == nil {
+		return
+	}
+	c.update(func() {})
+}
+
+// update merges the persisted entries, applies the change and writes the cache while
+// holding the file lock, so that the entries of other processes are not lost.
+// The caller must hold the lock.
+func (c *CredentialCache) update(change func()) {
+	unlock, err := lockFile(c.file.Path + ".lock")
+	if err != nil {
+		slog.Warn("Could not lock credential cache.", "path", c.file.Path, "err", err)
+		return
+	}
+	defer unlock()
+
+	entries, err := c.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Could not read credential cache. Replacing existing cache.", "path", c.file.Path, "err", err)
+	}
+	c.merge(entries)
+	change()
+	if err := c.write(); err != nil {
+		slog.Warn("Could not persist credential cache.", "path", c.file.Path, "err", err)
+	}
+}
+
+// merge adds the entries which are more recent than the in-memory entries, and
+// removes any expired entries. The caller must hold the lock.
+func (c *CredentialCache) merge(entries map[string]credentialCacheEntry) {
+	for key, entry := range entries {
+		if existing, ok := c.entries[key]; !ok || entry.UpdatedAt.After(existing.UpdatedAt) {
+			c.entries[key] = entry
+		}
+	}
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.ExpiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *CredentialCache) write() error {
+	data := make(map[string]persistedCredentials, len(c.entries))
+	for k, v := range c.entries {
+		data[k] = persistedCredentials{
+			URL:           v.Credentials.URL,
+			Username:      v.Credentials.Username,
+			Password:      v.Credentials.Password,
+			IdentityToken: v.Credentials.IdentityToken,
+			ExpiresAt:     v.ExpiresAt,
+			UpdatedAt:     v.UpdatedAt,
+			Invalidated:   v.Invalidated,
+		}
+	}
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.file.Write(plaintext)
+}
+
+// read the persisted entries. The caller must hold the lock.
+func (c *CredentialCache) read() (map[string]credentialCacheEntry, error) {
+	plaintext, err := c.file.Read()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]persistedCredentials)
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]credentialCacheEntry, len(data))
+	for k, v := range data {
+		entries[k] = credentialCacheEntry{
+			Credentials: RepositoryAuth{
+				URL:           v.URL,
+				Username:      v.Username,
+				Password:      v.Password,
+				IdentityToken: v.IdentityToken,
+				ExpiresAt:     v.ExpiresAt,
+			},
+			ExpiresAt:   v.ExpiresAt,
+			UpdatedAt:   v.UpdatedAt,
+			Invalidated: v.Invalidated,
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CredentialCacheKey(t *testing.T) {
+	testcases := []struct {
+		Input  string
+		Expect string
+	}{
+		{Input: "ghcr.io/thin-edge/tedge:1.0.0", Expect: "ghcr.io/thin-edge"},
+		{Input: "ghcr.io/thin-edge/sub/tedge@sha256:0000000000000000000000000000000000000000000000000000000000000000", Expect: "ghcr.io/thin-edge/sub"},
+		{Input: "nginx:latest", Expect: "docker.io/library"},
+		{Input: "localhost:5000/app", Expect: "localhost:5000"},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, CredentialCacheKey(tc.Input), "input=%s", tc.Input)
+	}
+}
+
+func Test_CredentialCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := NewCredentialCache(10 * time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("ghcr.io/thin-edge/tedge:1.0.0", RepositoryAuth{Username: "user", Password: "pass"})
+	cache.Set("quay.io/other/app:1.0.0", RepositoryAuth{Username: "user2", Password: "pass2", ExpiresAt: now.Add(time.Minute)})
+
+	// Same registry and path prefix share the credentials
+	creds, ok := cache.Get("ghcr.io/thin-edge/tedge-container-plugin:2.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, "user", creds.Username)
+
+	_, ok = cache.Get("ghcr.io/other/app:1.0.0")
+	assert.False(t, ok)
+
+	// explicit expiration
+	now = now.Add(2 * time.Minute)
+	_, ok = cache.Get("quay.io/other/app:1.0.0")
+	assert.False(t, ok)
+	_, ok = cache.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.True(t, ok)
+
+	// ttl expiration
+	now = now.Add(10 * time.Minute)
+	_, ok = cache.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.False(t, ok)
+}
+
+func Test_CredentialCacheInvalidate(t *testing.T) {
+	cache := NewCredentialCache(10 * time.Minute)
+	cache.Set("ghcr.io/thin-edge/tedge:1.0.0", RepositoryAuth{Username: "user", Password: "pass"})
+	cache.Invalidate("ghcr.io/thin-edge/tedge:latest")
+	_, ok := cache.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.False(t, ok)
+}
+
+func Test_CredentialCachePersistence(t *testing.T) {
+	dir := t.TempDir()
+	cacheFile := filepath.Join(dir, "credentials-cache.enc")
+	keyFile := filepath.Join(dir, "credentials-cache.key")
+
+	cache := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	cache.Set("ghcr.io/thin-edge/tedge:1.0.0", RepositoryAuth{Username: "user", Password: "secret-password"})
+
+	// The cache must not be stored in plain text
+	contents, err := os.ReadFile(cacheFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(contents), "secret-password")
+
+	info, err := os.Stat(cacheFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A new process can read the cache
+	cache2 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	creds, ok := cache2.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, "secret-password", creds.Password)
+
+	// A different key can not read the cache
+	assert.NoError(t, os.WriteFile(keyFile, []byte("another-key"), 0600))
+	cache3 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	_, ok = cache3.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.False(t, ok)
+}
+
+func Test_CredentialCachePersistenceMerge(t *testing.T) {
+	dir := t.TempDir()
+	cacheFile := filepath.Join(dir, "credentials-cache.enc")
+	keyFile := filepath.Join(dir, "credentials-cache.key")
+
+	// Two processes using the same cache
+	cache1 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	cache2 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+
+	cache1.Set("ghcr.io/thin-edge/tedge:1.0.0", RepositoryAuth{Username: "user1", Password: "pass1"})
+	cache2.Set("quay.io/example/app:1.0.0", RepositoryAuth{Username: "user2", Password: "pass2"})
+
+	// The entries of both processes are kept
+	cache3 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	creds, ok := cache3.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, "user1", creds.Username)
+	creds, ok = cache3.Get("quay.io/example/app:1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, "user2", creds.Username)
+
+	// An invalidation is not undone by a process which still has the rejected credentials
+	cache3.Invalidate("ghcr.io/thin-edge/tedge:1.0.0")
+	cache1.Set("docker.io/library/nginx:latest", RepositoryAuth{Username: "user3", Password: "pass3"})
+
+	cache4 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	_, ok = cache4.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.False(t, ok)
+	_, ok = cache4.Get("docker.io/library/nginx:latest")
+	assert.True(t, ok)
+
+	// The in-memory entry of the stale process is also replaced by the tombstone on its next write
+	_, ok = cache1.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.False(t, ok)
+
+	// New credentials replace the tombstone
+	cache2.Set("ghcr.io/thin-edge/tedge:1.0.0", RepositoryAuth{Username: "user1", Password: "newpass"})
+	cache5 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	creds, ok = cache5.Get("ghcr.io/thin-edge/tedge:1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, "newpass", creds.Password)
+
+	// Clearing the cache removes the entries of all processes
+	cache5.Clear()
+	cache2.Set("other.io/example/app:1.0.0", RepositoryAuth{Username: "user4", Password: "pass4"})
+	cache6 := NewCredentialCache(10*time.Minute).WithPersistence(cacheFile, keyFile)
+	for _, image := range []string{"ghcr.io/thin-edge/tedge:1.0.0", "quay.io/example/app:1.0.0", "docker.io/library/nginx:latest"} {
+		_, ok = cache6.Get(image)
+		assert.False(t, ok, image)
+	}
+	_, ok = cache6.Get("other.io/example/app:1.0.0")
+	assert.True(t, ok)
+}
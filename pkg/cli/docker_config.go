@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultDockerRegistry is the key used by the docker cli to store the credentials
+// of the Docker Hub registry (docker.io)
+const DefaultDockerRegistry = "https://index.docker.io/v1/"
+
+// DockerConfig is the subset of the docker cli's config.json which is related
+// to container registry authentication
+type DockerConfig struct {
+	Auths       map[string]DockerAuthEntry `json:"auths"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+	CredsStore  string                     `json:"credsStore"`
+}
+
+type DockerAuthEntry struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// Credential helper response. See https://github.com/docker/docker-credential-helpers
+type dockerCredentialHelperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// LoadDockerConfig reads a docker config.json file
+func LoadDockerConfig(p string) (*DockerConfig, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	config := &DockerConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("invalid docker config. path=%s, err=%w", p, err)
+	}
+	return config, nil
+}
+
+// NormalizeRegistryHostname converts a registry key as used in the docker config.json,
+// e.g. "https://ghcr.io/v1/", to its hostname, e.g. "ghcr.io".
+// The Docker Hub aliases are all normalized to "docker.io".
+func NormalizeRegistryHostname(v string) string {
+	v = strings.TrimPrefix(v, "https://")
+	v = strings.TrimPrefix(v, "http://")
+	v, _, _ = strings.Cut(v, "/")
+	switch strings.ToLower(v) {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return strings.ToLower(v)
+}
+
+// registryServerURL returns the server url to pass to the credential helpers
+// which matches the convention used by the docker cli
+func registryServerURL(registry string) string {
+	if registry == "docker.io" {
+		return DefaultDockerRegistry
+	}
+	return registry
+}
+
+// GetCredentials returns the credentials for a given registry (hostname).
+// The credentials are resolved in the same order as the docker cli, where
+// a registry specific credential helper (credHelpers) has the highest precedence,
+// followed by the default credential store (credsStore) and the static auths
+// entries.
+func (c *DockerConfig) GetCredentials(ctx context.Context, registry string) (RepositoryAuth, error) {
+	requested := registry
+	registry = NormalizeRegistryHostname(registry)
+
+	if key, ok := findRegistryKey(c.CredHelpers, requested); ok && c.CredHelpers[key] != "" {
+		return GetCredentialsFromHelper(ctx, c.CredHelpers[key], key)
+	}
+
+	if c.CredsStore != "" {
+		creds, err := GetCredentialsFromHelper(ctx, c.CredsStore, registryServerURL(registry))
+		if err != nil {
+			return creds, err
+		}
+		if creds.IsSet() {
+			return creds, nil
+		}
+	}
+
+	if key, ok := findRegistryKey(c.Auths, requested); ok {
+		return c.Auths[key].Decode(key)
+	}
+	return RepositoryAuth{}, nil
+}
+
+// findRegistryKey returns the key of the registry in a config.json section. A key which exactly
+// matches the registry is preferred, otherwise the first key (in sorted order) which normalizes
+// to the same hostname is used, so the result does not depend on the map order when multiple
+// aliases are present, e.g. "https://index.docker.io/v1/" and "docker.io"
+func findRegistryKey[T any](entries map[string]T, registry string) (string, bool) {
+	if _, ok := entries[registry]; ok {
+		return registry, true
+	}
+	hostname := NormalizeRegistryHostname(registry)
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		if NormalizeRegistryHostname(key) == hostname {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	return keys[0], true
+}
+
+// Decode the auths entry to registry credentials
+func (e DockerAuthEntry) Decode(url string) (RepositoryAuth, error) {
+	creds := RepositoryAuth{
+		URL:           url,
+		Username:      e.Username,
+		Password:      e.Password,
+		IdentityToken: e.IdentityToken,
+	}
+	if e.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return creds, fmt.Errorf("invalid auth value in docker config. registry=%s, err=%w", url, err)
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return creds, fmt.Errorf("invalid auth value in docker config, expected 'username:password'. registry=%s", url)
+		}
+		creds.Username = username
+		creds.Password = password
+	}
+	return creds, nil
+}
+
+// GetCredentialsFromHelper executes a docker credentials helper (docker-credential-<helper>)
+// using the standard protocol where the server url is passed via stdin and the
+// credentials are returned as json on stdout.
+// A helper not knowing the registry is not treated as an error, instead empty
+// credentials are returned.
+func GetCredentialsFromHelper(ctx context.Context, helper string, serverURL string) (RepositoryAuth, error) {
+	creds := RepositoryAuth{}
+	program := "docker-credential-" + helper
+	if _, err := exec.LookPath(program); err != nil {
+		return creds, fmt.Errorf("docker credential helper not found. helper=%s, err=%w", program, err)
+	}
+
+	cmd := exec.CommandContext(ctx, program, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+
+	slog.Info("Executing docker credential helper.", "cmd", program, "serverURL", serverURL)
+	if err := cmd.Run(); err != nil {
+		// Helpers print "credentials not found in native keychain" to stdout when
+		// they don't have any credentials for the given server
+		output := strings.TrimSpace(outb.String() + errb.String())
+		if strings.Contains(strings.ToLower(output), "credentials not found") {
+			slog.Info("Docker credential helper does not have credentials for the registry.", "cmd", program, "serverURL", serverURL)
+			return creds, nil
+		}
+		return creds, fmt.Errorf("docker credential helper failed. helper=%s, output=%s, err=%w", program, output, err)
+	}
+
+	resp := dockerCredentialHelperResponse{}
+	if err := json.Unmarshal(outb.Bytes(), &resp); err != nil {
+		return creds, fmt.Errorf("invalid docker credential helper response. helper=%s, err=%w", program, err)
+	}
+
+	creds.URL = serverURL
+	if resp.Username == "<token>" {
+		// The secret is an identity token rather than a password
+		creds.IdentityToken = resp.Secret
+	} else {
+		creds.Username = resp.Username
+		creds.Password = resp.Secret
+	}
+	return creds, nil
+}
+
+// DefaultDockerConfigPath returns the location of the docker config.json file
+// as used by the docker cli, respecting the DOCKER_CONFIG env variable
+func DefaultDockerConfigPath() string {
+	if v := os.Getenv("DOCKER_CONFIG"); v != "" {
+		return filepath.Join(v, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
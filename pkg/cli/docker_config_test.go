@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NormalizeRegistryHostname(t *testing.T) {
+	testcases := []struct {
+		Input  string
+		Expect string
+	}{
+		{Input: "https://index.docker.io/v1/", Expect: "docker.io"},
+		{Input: "docker.io", Expect: "docker.io"},
+		{Input: "registry-1.docker.io", Expect: "docker.io"},
+		{Input: "ghcr.io", Expect: "ghcr.io"},
+		{Input: "https://GHCR.io", Expect: "ghcr.io"},
+		{Input: "http://localhost:5000/v2/", Expect: "localhost:5000"},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, NormalizeRegistryHostname(tc.Input), "input=%s", tc.Input)
+	}
+}
+
+func Test_DockerAuthEntryDecode(t *testing.T) {
+	// base64("myuser:my:secret")
+	creds, err := DockerAuthEntry{Auth: "bXl1c2VyOm15OnNlY3JldA=="}.Decode("ghcr.io")
+	assert.NoError(t, err)
+	assert.Equal(t, "myuser", creds.Username)
+	assert.Equal(t, "my:secret", creds.Password)
+
+	_, err = DockerAuthEntry{Auth: "bm9jb2xvbg=="}.Decode("ghcr.io")
+	assert.Error(t, err)
+
+	creds, err = DockerAuthEntry{IdentityToken: "abc"}.Decode("ghcr.io")
+	assert.NoError(t, err)
+	assert.True(t, creds.IsSet())
+}
+
+func Test_DockerConfigGetCredentials(t *testing.T) {
+	dir := t.TempDir()
+	helper := `#!/bin/sh
+read -r server
+case "$server" in
+	example.com) echo '{"ServerURL":"example.com","Username":"helperuser","Secret":"helpersecret"}' ;;
+	token.example.com) echo '{"ServerURL":"token.example.com","Username":"<token>","Secret":"refresh"}' ;;
+	*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(helper), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	configFile := filepath.Join(dir, "config.json")
+	assert.NoError(t, os.WriteFile(configFile, []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"username": "hubuser", "password": "hubsecret"},
+			"quay.io": {"auth": "cXVheXVzZXI6cXVheXNlY3JldA=="}
+		},
+		"credHelpers": {
+			"example.com": "test",
+			"token.example.com": "test"
+		}
+	}`), 0644))
+
+	config, err := LoadDockerConfig(configFile)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	creds, err := config.GetCredentials(ctx, "docker.io")
+	assert.NoError(t, err)
+	assert.Equal(t, "hubuser", creds.Username)
+	assert.Equal(t, "hubsecret", creds.Password)
+
+	creds, err = config.GetCredentials(ctx, "quay.io")
+	assert.NoError(t, err)
+	assert.Equal(t, "quayuser", creds.Username)
+	assert.Equal(t, "quaysecret", creds.Password)
+
+	creds, err = config.GetCredentials(ctx, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "helperuser", creds.Username)
+	assert.Equal(t, "helpersecret", creds.Password)
+
+	creds, err = config.GetCredentials(ctx, "token.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh", creds.IdentityToken)
+	assert.Empty(t, creds.Username)
+
+	// Registries unknown to the config don't have any credentials
+	creds, err = config.GetCredentials(ctx, "other.io")
+	assert.NoError(t, err)
+	assert.False(t, creds.IsSet())
+
+	// Helpers without any stored credentials are not an error
+	config.CredsStore = "test"
+	creds, err = config.GetCredentials(ctx, "other.io")
+	assert.NoError(t, err)
+	assert.False(t, creds.IsSet())
+}
+
+func Test_DockerConfigGetCredentialsAliases(t *testing.T) {
+	config := &DockerConfig{
+		Auths: map[string]DockerAuthEntry{
+			"https://index.docker.io/v1/": {Username: "hubuser", Password: "hubsecret"},
+			"docker.io":                   {Username: "otheruser", Password: "othersecret"},
+			"registry-1.docker.io":        {Username: "thirduser", Password: "thirdsecret"},
+		},
+	}
+	ctx := context.Background()
+
+	// An exact match is preferred
+	for i := 0; i < 20; i++ {
+		creds, err := config.GetCredentials(ctx, "docker.io")
+		assert.NoError(t, err)
+		assert.Equal(t, "otheruser", creds.Username)
+	}
+
+	// Otherwise the first alias in sorted order is used
+	delete(config.Auths, "docker.io")
+	for i := 0; i < 20; i++ {
+		creds, err := config.GetCredentials(ctx, "docker.io")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://index.docker.io/v1/", creds.URL)
+		assert.Equal(t, "hubuser", creds.Username)
+	}
+}
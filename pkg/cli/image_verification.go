@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/viper"
+	"github.com/thin-edge/tedge-container-plugin/pkg/container"
+	"github.com/thin-edge/tedge-container-plugin/pkg/registry"
+	"github.com/thin-edge/tedge-container-plugin/pkg/signature"
+	"github.com/thin-edge/tedge-container-plugin/pkg/utils"
+)
+
+// Image signature verification policies
+const (
+	// VerificationPolicyOff does not verify the image signatures
+	VerificationPolicyOff = "off"
+	// VerificationPolicyWarn verifies the image signatures but only logs a warning on failure
+	VerificationPolicyWarn = "warn"
+	// VerificationPolicyEnforce rejects images which are not signed by a trusted key
+	VerificationPolicyEnforce = "enforce"
+)
+
+// GetImageVerificationPublicKeys returns the paths to the trusted public keys
+func (c *Cli) GetImageVerificationPublicKeys() []string {
+	return getExpandedStringSlice("image_verification.public_keys")
+}
+
+// GetImageVerificationPolicy returns the signature verification policy of an image.
+// Registry (or repository) specific policies are configured in the form "<prefix>=<policy>",
+// e.g. "ghcr.io/thin-edge=enforce", where the longest matching prefix is used.
+// The default policy is used if no prefix matches.
+func (c *Cli) GetImageVerificationPolicy(imageRef string) string {
+	name := imageRef
+	if named, err := reference.ParseNormalizedNamed(imageRef); err == nil {
+		name = named.Name()
+	}
+
+	policy := viper.GetString("image_verification.policy")
+	longestMatch := -1
+	for _, item := range getExpandedStringSlice("image_verification.registries") {
+		prefix, value, ok := strings.Cut(item, "=")
+		if !ok {
+			slog.Warn("Ignoring invalid image verification policy. Expected <prefix>=<policy>.", "value", item)
+			continue
+		}
+		prefix = strings.TrimSuffix(NormalizeRegistryHostname(strings.TrimSpace(prefix))+pathOf(prefix), "/")
+		if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+			continue
+		}
+		if len(prefix) > longestMatch {
+			longestMatch = len(prefix)
+			policy = strings.TrimSpace(value)
+		}
+	}
+
+	switch policy = strings.ToLower(policy); policy {
+	case VerificationPolicyOff, VerificationPolicyWarn, VerificationPolicyEnforce:
+		return policy
+	case "":
+		return VerificationPolicyOff
+	default:
+		// Fail closed if the user has made a typo
+		slog.Warn("Unknown image verification policy. Using enforce instead.", "policy", policy)
+		return VerificationPolicyEnforce
+	}
+}
+
+// ImageVerificationEnforced checks if the verification policy of any of the images is set to enforce
+func (c *Cli) ImageVerificationEnforced(images []string) bool {
+	for _, imageRef := range images {
+		if c.GetImageVerificationPolicy(imageRef) == VerificationPolicyEnforce {
+			return true
+		}
+	}
+	return false
+}
+
+// pathOf returns the repository path (including the leading slash) of a registry prefix,
+// e.g. "ghcr.io/thin-edge" => "/thin-edge"
+func pathOf(prefix string) string {
+	prefix = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(prefix), "https://"), "http://")
+	if i := strings.Index(prefix, "/"); i > -1 {
+		return prefix[i:]
+	}
+	return ""
+}
+
+func (c *Cli) loadVerificationKeys() ([]crypto.PublicKey, error) {
+	paths := c.GetImageVerificationPublicKeys()
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no public keys configured (image_verification.public_keys)")
+	}
+	return signature.LoadPublicKeys(paths...)
+}
+
+// applyVerificationPolicy converts the verification result into an error
+// depending on the policy
+func applyVerificationPolicy(policy string, imageRef string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if policy == VerificationPolicyWarn {
+		slog.Warn("Image signature verification failed. Continuing as the policy is set to warn.", "image", imageRef, "err", err)
+		return nil
+	}
+	slog.Error("Image signature verification failed.", "image", imageRef, "err", err)
+	return fmt.Errorf("image signature verification failed. image=%s: %w", imageRef, err)
+}
+
+// NewRegistryClient creates a client to the registry of an image, using the registry
+// credentials (if any) configured for the image
+func (c *Cli) NewRegistryClient(ctx context.Context, imageRef string) (*registry.Client, error) {
+	authCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	encoded, err := c.GetContainerRepositoryCredentialsFunc(imageRef)(authCtx, 1)
+	if err != nil {
+		slog.Warn("Could not get registry credentials. Trying without credentials.", "image", imageRef, "err", err)
+	}
+	return registry.NewClient(imageRef, registry.CredentialsFromRegistryAuth(encoded))
+}
+
+// VerifyImage verifies the signature of an image in the local container engine
+// against the image's registry, using the verification policy of the image.
+// An error is only returned if the verification failed and the policy is set to enforce.
+func (c *Cli) VerifyImage(ctx context.Context, containerCli *container.ContainerClient, imageRef string) error {
+	policy := c.GetImageVerificationPolicy(imageRef)
+	if policy == VerificationPolicyOff {
+		return nil
+	}
+	slog.Info("Verifying image signature.", "image", imageRef, "policy", policy)
+	return applyVerificationPolicy(policy, imageRef, c.verifyImage(ctx, containerCli, imageRef))
+}
+
+func (c *Cli) verifyImage(ctx context.Context, containerCli *container.ContainerClient, imageRef string) error {
+	keys, err := c.loadVerificationKeys()
+	if err != nil {
+		return err
+	}
+
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return err
+	}
+
+	// Use the digest(s) of the image which is stored locally rather than
+	// resolving the tag again, as the tag could have changed in the meantime
+	digests := make([]digest.Digest, 0)
+	if canonical, ok := named.(reference.Canonical); ok {
+		digests = append(digests, canonical.Digest())
+	}
+	imageInspect, err := containerCli.Client.ImageInspect(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	for _, repoDigest := range imageInspect.RepoDigests {
+		if ref, err := reference.ParseNormalizedNamed(repoDigest); err == nil && ref.Name() == named.Name() {
+			if canonical, ok := ref.(reference.Canonical); ok && !slices.Contains(digests, canonical.Digest()) {
+				digests = append(digests, canonical.Digest())
+			}
+		}
+	}
+	if len(digests) == 0 {
+		return fmt.Errorf("image does not have a registry digest, e.g. it was built or loaded locally. %w", signature.ErrNoSignature)
+	}
+
+	client, err := c.NewRegistryClient(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	repository, _, err := registry.ParseReference(imageRef)
+	if err != nil {
+		return err
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+	errs := make([]error, 0, len(digests))
+	for _, dgst := range digests {
+		err := signature.VerifyImage(verifyCtx, client, repository, dgst, keys)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyImageFile verifies the detached signature (e.g. created by "cosign sign-blob") of
+// an image archive before it is loaded. If signatureFile is empty, then "<file>.sig" is used.
+// An error is only returned if the verification failed and the policy is set to enforce.
+func (c *Cli) VerifyImageFile(file string, signatureFile string, imageRef string) error {
+	policy := c.GetImageVerificationPolicy(imageRef)
+	if policy == VerificationPolicyOff {
+		return nil
+	}
+	slog.Info("Verifying image archive signature.", "file", file, "image", imageRef, "policy", policy)
+	return applyVerificationPolicy(policy, imageRef, c.verifyImageFile(file, signatureFile))
+}
+
+func (c *Cli) verifyImageFile(file string, signatureFile string) error {
+	keys, err := c.loadVerificationKeys()
+	if err != nil {
+		return err
+	}
+	if signatureFile == "" {
+		signatureFile = file + ".sig"
+	}
+	if !utils.PathExists(signatureFile) {
+		return fmt.Errorf("detached signature file does not exist. path=%s: %w", signatureFile, signature.ErrNoSignature)
+	}
+	sig, err := os.ReadFile(signatureFile)
+	if err != nil {
+		return err
+	}
+	if err := signature.VerifyBlob(keys, file, sig); err != nil {
+		return err
+	}
+	slog.Info("Verified image archive signature.", "file", file, "signature", signatureFile)
+	return nil
+}
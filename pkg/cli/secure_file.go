@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SecureFile is a file which is encrypted at rest (AES-GCM) and only readable
+// by the owner. It is used to store secrets such as registry credentials in the
+// persistent directory.
+//
+// The encryption key is derived from a random secret (stored in KeyPath) and the
+// machine id (if available), so that copying the files to another device is not
+// enough to decrypt the contents.
+type SecureFile struct {
+	Path    string
+	KeyPath string
+}
+
+func NewSecureFile(path string, keyPath string) *SecureFile {
+	return &SecureFile{
+		Path:    path,
+		KeyPath: keyPath,
+	}
+}
+
+// Read and decrypt the file contents
+func (f *SecureFile) Read() ([]byte, error) {
+	ciphertext, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := f.encryptionKey(false)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(key, ciphertext)
+}
+
+// Write encrypts and writes the contents to the file. The file is replaced
+// atomically so that concurrent readers never see a partial file.
+func (f *SecureFile) Write(plaintext []byte) error {
+	key, err := f.encryptionKey(true)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := encrypt(key, plaintext)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+	// Use a unique temp file so that concurrent writers don't interleave
+	tmpFile, err := os.CreateTemp(filepath.Dir(f.Path), "."+filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if _, err := tmpFile.Write(ciphertext); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), f.Path)
+}
+
+// Remove the file (but not the key)
+func (f *SecureFile) Remove() error {
+	if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *SecureFile) encryptionKey(create bool) ([]byte, error) {
+	secret, err := os.ReadFile(f.KeyPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !create {
+			return nil, err
+		}
+		secret, err = f.createKeySecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+	h := sha256.New()
+	h.Write(secret)
+	for _, p := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if machineID, err := os.ReadFile(p); err == nil {
+			h.Write([]byte(strings.TrimSpace(string(machineID))))
+			break
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// createKeySecret creates the random key secret. The key file is shared by multiple files
+// and processes, so the file is only created if it does not exist, and the secret created by
+// another process is used if it was faster. Otherwise whatever was encrypted with the other
+// secret could no longer be decrypted
+func (f *SecureFile) createKeySecret() ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(f.KeyPath), 0755); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	// Write the secret to a temp file first, so other processes never read a partial secret
+	tmpFile, err := os.CreateTemp(filepath.Dir(f.KeyPath), "."+filepath.Base(f.KeyPath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if _, err := tmpFile.Write(secret); err != nil {
+		_ = tmpFile.Close()
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+	// Link fails if the key file already exists (like O_CREATE|O_EXCL), but the file
+	// is never visible without its contents
+	if err := os.Link(tmpFile.Name(), f.KeyPath); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return os.ReadFile(f.KeyPath)
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+func encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key []byte, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted file is corrupt")
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SecureFileConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, SecretsKeyFile)
+
+	// Multiple writers (e.g. the run service and the software management plugin)
+	// create the shared key at the same time
+	const writers = 50
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			f := NewSecureFile(filepath.Join(dir, fmt.Sprintf("file-%d.enc", i)), keyFile)
+			assert.NoError(t, f.Write([]byte(fmt.Sprintf("secret-%d", i))))
+			shared := NewSecureFile(filepath.Join(dir, "shared.enc"), keyFile)
+			assert.NoError(t, shared.Write([]byte("shared")))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	// Everything can be decrypted with the key which was kept
+	for i := 0; i < writers; i++ {
+		contents, err := NewSecureFile(filepath.Join(dir, fmt.Sprintf("file-%d.enc", i)), keyFile).Read()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("secret-%d", i), string(contents))
+	}
+	contents, err := NewSecureFile(filepath.Join(dir, "shared.enc"), keyFile).Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "shared", string(contents))
+
+	// No temp files are left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, writers+2)
+}
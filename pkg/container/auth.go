@@ -8,10 +8,21 @@ import (
 )
 
 func GetRegistryAuth(username, password string) string {
-	authConfig := registry.AuthConfig{
+	return EncodeRegistryAuth(registry.AuthConfig{
 		Username: username,
 		Password: password,
-	}
+	})
+}
+
+// GetRegistryIdentityTokenAuth encodes an identity token (e.g. as returned by a
+// docker credential helper) which is exchanged for an access token by the engine
+func GetRegistryIdentityTokenAuth(identityToken string) string {
+	return EncodeRegistryAuth(registry.AuthConfig{
+		IdentityToken: identityToken,
+	})
+}
+
+func EncodeRegistryAuth(authConfig registry.AuthConfig) string {
 	encodedJSON, err := json.Marshal(authConfig)
 	if err != nil {
 		panic(err)
@@ -14,6 +14,7 @@ import (
 	"go.yaml.in/yaml/v3"
 
 	composeCli "github.com/compose-spec/compose-go/v2/cli"
+	composeTypes "github.com/compose-spec/compose-go/v2/types"
 )
 
 // execCommand is a variable to enable mocking of exec.Command
@@ -105,6 +106,11 @@ func prepareComposeCommand(args ...string) (string, []string, error) {
 		// to check of any errors, however in newer podman versions, e.g. podman 5.2
 		// https://github.com/thin-edge/tedge-container-plugin/issues/70
 		cmdbuilder.PrependFlag("podman-compose", "up", "--verbose", cmdbuilder.MustVersionConstraint(">=1.1.0")),
+
+		// podman-compose and docker-compose v1 don't support the pull policy argument, however they
+		// only pull images which are missing unless the service's pull_policy says otherwise
+		cmdbuilder.RemoveFlag("podman-compose", "up", "--pull=never", nil),
+		cmdbuilder.RemoveFlag("docker-compose", "up", "--pull=never", cmdbuilder.MustVersionConstraint("<2.0.0")),
 	)
 
 	return command.Base.Name(), command.Base.Args(command.Args...), err
@@ -135,6 +141,28 @@ func ReadImages(ctx context.Context, paths []string, workingDir string) ([]strin
 	return images, nil
 }
 
+// CheckPullPolicies checks that the services only use the images which are already available
+// locally (e.g. after the images were pulled and verified), so a service must not use a
+// pull_policy which pulls or builds the image again, e.g. "always", "build" or "daily"
+func CheckPullPolicies(ctx context.Context, paths []string) error {
+	project, err := composeCli.NewProjectOptions(paths, composeCli.WithDotEnv)
+	if err != nil {
+		return err
+	}
+	projectT, err := project.LoadProject(ctx)
+	if err != nil {
+		return err
+	}
+	for name, service := range projectT.Services {
+		switch service.PullPolicy {
+		case "", composeTypes.PullPolicyMissing, composeTypes.PullPolicyIfNotPresent, composeTypes.PullPolicyNever:
+		default:
+			return fmt.Errorf("service pull_policy is not supported when the image verification is enforced. service=%s, pull_policy=%s", name, service.PullPolicy)
+		}
+	}
+	return nil
+}
+
 // EnsureExtraHost ensures every service in composePaths[0] that does not
 // already define hostname in extra_hosts has "hostname=ipValue" added.
 // Services that already define the hostname (under either the "=" or ":"
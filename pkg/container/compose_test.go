@@ -109,6 +109,38 @@ services:
 	})
 }
 
+func TestCheckPullPolicies(t *testing.T) {
+	workingDir := t.TempDir()
+	testcases := []struct {
+		PullPolicy string
+		Valid      bool
+	}{
+		{PullPolicy: "", Valid: true},
+		{PullPolicy: "missing", Valid: true},
+		{PullPolicy: "if_not_present", Valid: true},
+		{PullPolicy: "never", Valid: true},
+		{PullPolicy: "always", Valid: false},
+		{PullPolicy: "build", Valid: false},
+		{PullPolicy: "daily", Valid: false},
+	}
+	for _, tc := range testcases {
+		contents := "services:\n  app1:\n    image: hello-world\n"
+		if tc.PullPolicy != "" {
+			contents += "    pull_policy: " + tc.PullPolicy + "\n"
+		}
+		composeFile := filepath.Join(workingDir, "docker-compose.yml")
+		if err := os.WriteFile(composeFile, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write compose file: %v", err)
+		}
+		err := CheckPullPolicies(context.Background(), []string{composeFile})
+		if tc.Valid {
+			assert.NoError(t, err, "pull_policy=%s", tc.PullPolicy)
+		} else {
+			assert.Error(t, err, "pull_policy=%s", tc.PullPolicy)
+		}
+	}
+}
+
 func TestEnsureExtraHost(t *testing.T) {
 	ctx := context.Background()
 	const hostname = "host.containers.internal"
@@ -456,4 +488,29 @@ func TestPrepareComposeCommand(t *testing.T) {
 		assert.Equal(t, "podman-compose", cmdName)
 		assert.Equal(t, []string{"up", "--detach"}, args)
 	})
+
+	t.Run("pull policy only used by the backends which support it", func(t *testing.T) {
+		oldDetect := detectComposeFunc
+		defer func() { detectComposeFunc = oldDetect }()
+
+		testcases := []struct {
+			Base    cmdbuilder.BaseCommand
+			Version string
+			Expect  []string
+		}{
+			{Base: cmdbuilder.NewBaseCommand("docker", "compose"), Version: "2.29.0", Expect: []string{"compose", "up", "--detach", "--pull=never"}},
+			{Base: cmdbuilder.NewBaseCommand("docker-compose"), Version: "2.29.0", Expect: []string{"up", "--detach", "--pull=never"}},
+			{Base: cmdbuilder.NewBaseCommand("docker-compose"), Version: "1.29.2", Expect: []string{"up", "--detach"}},
+			{Base: cmdbuilder.NewBaseCommand("podman-compose"), Version: "1.0.6", Expect: []string{"up", "--detach"}},
+		}
+		for _, tc := range testcases {
+			detectComposeFunc = func() (*cmdbuilder.Command, error) {
+				v, _ := version.NewVersion(tc.Version)
+				return &cmdbuilder.Command{Base: tc.Base, Args: []string{}, Version: v}, nil
+			}
+			_, args, err := prepareComposeCommand("up", "--detach", "--pull=never")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expect, args, "command=%s, version=%s", tc.Base.String(), tc.Version)
+		}
+	})
 }
@@ -49,6 +49,22 @@ var ContainerGroupType string = "container-group"
 // strips the registry host prefix from image names).
 const LabelModuleVersion = "io.thin-edge.module.version"
 
+// LabelAutoUpdate is a container label used to opt in to automatic image updates.
+// Setting it to AutoUpdateDigest updates the container when the digest of its
+// image tag changes in the registry.
+const LabelAutoUpdate = "tedge.autoupdate"
+
+const AutoUpdateDigest = "digest"
+
+// LabelPreviousImage is a container label used to record the image of the container
+// which was replaced when the container was installed. It is used to roll back the
+// container if it ends up in a crash loop.
+const LabelPreviousImage = "io.thin-edge.previous.image"
+
+// LabelCrashLoopAction is a container label used to select the action which is taken
+// when the container is in a crash loop, overriding the global setting
+const LabelCrashLoopAction = "tedge.crashloop"
+
 func NewJSONTime(t time.Time) JSONTime {
 	return JSONTime{
 		Time: t,
@@ -118,6 +134,23 @@ type Container struct {
 	Filesystem  string   `json:"filesystem,omitempty"`
 	Command     string   `json:"command,omitempty"`
 	NetworkMode string   `json:"networkMode,omitempty"`
+	// Engine is the address of the container engine running the container
+	// (only set when monitoring multiple engines)
+	Engine string `json:"engine,omitempty"`
+
+	// Optional details, see TwinOptions
+	RestartCount  *int              `json:"restartCount,omitempty"`
+	Health        string            `json:"health,omitempty"`
+	FailingStreak *int              `json:"failingStreak,omitempty"`
+	ExitCode      *int              `json:"exitCode,omitempty"`
+	StartedAt     string            `json:"startedAt,omitempty"`
+	FinishedAt    string            `json:"finishedAt,omitempty"`
+	IPAddresses   map[string]string `json:"ipAddresses,omitempty"`
+	Mounts        []TwinMount       `json:"mounts,omitempty"`
+	RestartPolicy string            `json:"restartPolicy,omitempty"`
+	Resources     *TwinResources    `json:"resources,omitempty"`
+	TwinLabels    map[string]string `json:"labels,omitempty"`
+	Env           []string          `json:"env,omitempty"`
 
 	// Only used for container groups
 	ServiceName string `json:"serviceName,omitempty"`
@@ -214,6 +247,29 @@ func (c *Container) GetName() string {
 	return fmt.Sprintf("%s@%s", project, c.ServiceName)
 }
 
+// Container health states, parsed from the container status
+const (
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+	HealthStarting  = "starting"
+	HealthNone      = "none"
+)
+
+// ParseHealth returns the health state from the human readable container status,
+// e.g. "Up 2 minutes (healthy)". HealthNone is returned if the container does not have a health check
+func ParseHealth(status string) string {
+	status = strings.ToLower(status)
+	switch {
+	case strings.Contains(status, "(unhealthy)"):
+		return HealthUnhealthy
+	case strings.Contains(status, "(healthy)"):
+		return HealthHealthy
+	case strings.Contains(status, "(health: starting)"), strings.Contains(status, "(starting)"):
+		return HealthStarting
+	}
+	return HealthNone
+}
+
 func ConvertToTedgeStatus(v string) string {
 	switch v {
 	case "up", "running":
@@ -468,6 +524,29 @@ func newContainerClient(ctx context.Context, options *ClientOptions) (*Container
 	}, nil
 }
 
+// NewUnavailableContainerClient creates a client for a container engine which could not be reached (yet),
+// without checking the engine. Requests are not retried, so they fail immediately until the client is
+// replaced by one created by NewContainerClient
+func NewUnavailableContainerClient(host string) (*ContainerClient, error) {
+	if host == "" {
+		host = findContainerEngineSocket()
+	}
+	if host == "" {
+		host = client.DefaultDockerHost
+	}
+	cli, err := client.NewClientWithOpts(
+		client.WithHost(host),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerClient{
+		Client: cli,
+		Engine: EngineCapabilities{Type: EngineUnknown},
+	}, nil
+}
+
 func NewContainerClient(ctx context.Context, opts ...Opt) (*ContainerClient, error) {
 	options := &ClientOptions{
 		Attempts:      5,
@@ -546,7 +625,8 @@ type ContainerStats struct {
 	NetIO  LowPrecisionFloat `json:"netio"`
 }
 
-func (c *ContainerClient) GetStats(ctx context.Context, containerID string) (*ContainerTelemetryMessage, error) {
+// GetStatsEntry collects a single sample of the resource usage (cpu, memory, network, block io and pids) of a container
+func (c *ContainerClient) GetStatsEntry(ctx context.Context, containerID string) (StatsEntry, error) {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	containerStats := &Stats{
@@ -559,15 +639,23 @@ func (c *ContainerClient) GetStats(ctx context.Context, containerID string) (*Co
 	collect(ctx, containerStats, c.Client, false, &wg)
 	wg.Wait()
 
-	s := containerStats.GetStatistics()
-	stats := &ContainerTelemetryMessage{
+	return containerStats.GetStatistics(), containerStats.GetError()
+}
+
+func (c *ContainerClient) GetStats(ctx context.Context, containerID string) (*ContainerTelemetryMessage, error) {
+	s, _ := c.GetStatsEntry(ctx, containerID)
+	return NewContainerTelemetryMessage(s), nil
+}
+
+// NewContainerTelemetryMessage converts a resource usage sample to the telemetry message
+func NewContainerTelemetryMessage(s StatsEntry) *ContainerTelemetryMessage {
+	return &ContainerTelemetryMessage{
 		Container: ContainerStats{
 			Cpu:    NewLowerPrecisionFloat64(s.CPUPercentage, 2),
 			Memory: NewLowerPrecisionFloat64(s.MemoryPercentage, 2),
 			NetIO:  NewLowerPrecisionFloat64(s.NetworkTx, 0),
 		},
 	}
-	return stats, nil
 }
 
 type FilterOptions struct {
@@ -647,6 +735,18 @@ func (c *ContainerClient) StopContainer(ctx context.Context, containerID string)
 	return c.Client.ContainerStop(ctx, containerID, container.StopOptions{})
 }
 
+// PauseContainer pauses all processes of a container
+func (c *ContainerClient) PauseContainer(ctx context.Context, containerID string) error {
+	slog.Info("Pausing container.", "id", containerID)
+	return c.Client.ContainerPause(ctx, containerID)
+}
+
+// UnpauseContainer resumes all processes of a paused container
+func (c *ContainerClient) UnpauseContainer(ctx context.Context, containerID string) error {
+	slog.Info("Unpausing container.", "id", containerID)
+	return c.Client.ContainerUnpause(ctx, containerID)
+}
+
 // RestartContainer a container
 func (c *ContainerClient) RestartContainer(ctx context.Context, containerID string) error {
 	slog.Info("Restarting container.", "id", containerID)
@@ -761,9 +861,31 @@ func (c *ContainerClient) MonitorEvents(ctx context.Context) (<-chan events.Mess
 }
 
 type ImagePullOptions struct {
-	AuthFunc    func(context.Context, int) (string, error)
-	MaxAttempts int
-	Wait        time.Duration
+	AuthFunc func(context.Context, int) (string, error)
+	// AuthFailedFunc is called when a pull using the credentials returned by
+	// AuthFunc failed due to an authentication error, so that any cached
+	// credentials can be invalidated
+	AuthFailedFunc func(context.Context, error)
+	MaxAttempts    int
+	Wait           time.Duration
+}
+
+// IsAuthError checks if an error returned by the engine is related to
+// the registry rejecting the credentials
+func IsAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errdefs.IsUnauthorized(err) || errdefs.IsPermissionDenied(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range []string{"unauthorized", "authentication required", "access denied", "denied:", "access forbidden", "incorrect username or password"} {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
 }
 
 // BuildImageRef combines a software module name and version into an image reference.
@@ -850,15 +972,10 @@ func (c *ContainerClient) ImagePullWithRetries(ctx context.Context, imageRef str
 		return &imageInspect, nil
 	}
 
-	result, err := utils.Retry(opts.MaxAttempts, opts.Wait, func(attempt int) (any, error) {
+	return pullWithRetries(ctx, opts, func(attempt int, registryAuth string) (*image.InspectResponse, error) {
 		slog.Info("Pulling image.", "attempt", attempt)
-		pullOptions := image.PullOptions{}
-
-		// Get authentication header
-		if opts.AuthFunc != nil {
-			if auth, err := opts.AuthFunc(ctx, attempt); auth != "" && err == nil {
-				pullOptions.RegistryAuth = auth
-			}
+		pullOptions := image.PullOptions{
+			RegistryAuth: registryAuth,
 		}
 
 		// Note: ImagePull does not seem to return an error if the private registries authentication fails
@@ -908,6 +1025,31 @@ func (c *ContainerClient) ImagePullWithRetries(ctx context.Context, imageRef str
 		slog.Info("Image found after pull.", "id", imageInspect.ID, "name", imageInspect.RepoTags)
 		return &imageInspect, nil
 	})
+}
+
+// pullWithRetries calls pull with the registry authentication (if any) until it is successful or the
+// maximum attempts are reached. If the registry rejects the credentials, then AuthFailedFunc is called
+// before the next attempt so that any cached credentials are not used again
+func pullWithRetries(ctx context.Context, opts ImagePullOptions, pull func(attempt int, registryAuth string) (*image.InspectResponse, error)) (*image.InspectResponse, error) {
+	result, err := utils.Retry(opts.MaxAttempts, opts.Wait, func(attempt int) (any, error) {
+		// Get authentication header
+		registryAuth := ""
+		if opts.AuthFunc != nil {
+			if auth, err := opts.AuthFunc(ctx, attempt); auth != "" && err == nil {
+				registryAuth = auth
+			}
+		}
+
+		imageInspect, err := pull(attempt, registryAuth)
+		if err != nil {
+			if registryAuth != "" && opts.AuthFailedFunc != nil && IsAuthError(err) {
+				slog.Warn("Registry rejected the credentials.", "attempt", attempt, "err", err)
+				opts.AuthFailedFunc(ctx, err)
+			}
+			return nil, err
+		}
+		return imageInspect, nil
+	})
 
 	if err != nil {
 		return nil, err
@@ -1032,11 +1174,10 @@ func (c *ContainerClient) DockerCommand(args ...string) (string, []string, error
 
 func (c *ContainerClient) ComposeUp(ctx context.Context, w io.Writer, projectName string, workingDir string, extraArgs ...string) error {
 	slog.Info("Preparing compose command.", "name", projectName, "dir", workingDir)
-	command, args, err := prepareComposeCommand("up", "--detach", "--remove-orphans")
+	command, args, err := prepareComposeCommand(append([]string{"up", "--detach", "--remove-orphans"}, extraArgs...)...)
 	if err != nil {
 		return err
 	}
-	args = append(args, extraArgs...)
 	slog.Info("Starting compose project.", "name", projectName, "dir", workingDir, "command", command, "args", strings.Join(args, " "))
 	prog := exec.Command(command, args...)
 	prog.Dir = workingDir
@@ -2,8 +2,11 @@ package container
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/docker/docker/api/types/image"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -153,3 +156,67 @@ func Test_BuildImageRef(t *testing.T) {
 		}
 	}
 }
+
+func Test_ParseHealth(t *testing.T) {
+	testcases := []struct {
+		Status string
+		Expect string
+	}{
+		{Status: "Up 2 minutes (healthy)", Expect: HealthHealthy},
+		{Status: "Up 2 minutes (unhealthy)", Expect: HealthUnhealthy},
+		{Status: "Up 3 seconds (health: starting)", Expect: HealthStarting},
+		{Status: "Up 2 minutes", Expect: HealthNone},
+		{Status: "Exited (1) 5 seconds ago", Expect: HealthNone},
+		{Status: "", Expect: HealthNone},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Expect, ParseHealth(tc.Status), tc.Status)
+	}
+}
+
+func Test_PullWithRetriesInvalidatesRejectedCredentials(t *testing.T) {
+	authCalls := 0
+	authFailed := make([]error, 0)
+	pullAuths := make([]string, 0)
+
+	opts := ImagePullOptions{
+		MaxAttempts: 3,
+		AuthFunc: func(ctx context.Context, attempt int) (string, error) {
+			authCalls++
+			return fmt.Sprintf("auth-%d", attempt), nil
+		},
+		AuthFailedFunc: func(ctx context.Context, err error) {
+			authFailed = append(authFailed, err)
+		},
+	}
+	result, err := pullWithRetries(context.Background(), opts, func(attempt int, registryAuth string) (*image.InspectResponse, error) {
+		pullAuths = append(pullAuths, registryAuth)
+		if attempt == 1 {
+			return nil, errors.New("Error response from daemon: pull access denied for example.com/app, repository does not exist or may require 'docker login': denied: requested access to the resource is denied")
+		}
+		return &image.InspectResponse{ID: "sha256:1234"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:1234", result.ID)
+	assert.Equal(t, 2, authCalls)
+	assert.Equal(t, []string{"auth-1", "auth-2"}, pullAuths)
+	assert.Len(t, authFailed, 1)
+}
+
+func Test_PullWithRetriesKeepsCredentialsOnOtherErrors(t *testing.T) {
+	authFailed := 0
+	opts := ImagePullOptions{
+		MaxAttempts: 2,
+		AuthFunc: func(ctx context.Context, attempt int) (string, error) {
+			return "auth", nil
+		},
+		AuthFailedFunc: func(ctx context.Context, err error) {
+			authFailed++
+		},
+	}
+	_, err := pullWithRetries(context.Background(), opts, func(attempt int, registryAuth string) (*image.InspectResponse, error) {
+		return nil, errors.New("dial tcp: lookup example.com: no such host")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, authFailed)
+}
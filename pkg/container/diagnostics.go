@@ -0,0 +1,323 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"go.yaml.in/yaml/v3"
+)
+
+// DiagnosticsOptions controls the information included in the diagnostics bundle
+type DiagnosticsOptions struct {
+	// LogLines is the number of (most recent) log lines included for each container
+	LogLines int
+	// ComposeDir is the directory of the container-groups deployed by the plugin (one sub directory per
+	// project). Only the compose files inside of the project's directory are included, and none if empty
+	ComposeDir string
+}
+
+// RedactedValue replaces the value of sensitive environment variables
+const RedactedValue = "********"
+
+// sensitiveEnvPattern matches the names of environment variables which are likely to contain secrets
+var sensitiveEnvPattern = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|PASS$|SECRET|TOKEN|CREDENTIAL|PRIVATE|API_?KEY|ACCESS_?KEY|AUTH|_KEY$|^KEY$)`)
+
+// RedactEnv replaces the values of environment variables which are likely to contain secrets
+func RedactEnv(env []string) []string {
+	return RedactEnvMatching(env, sensitiveEnvPattern)
+}
+
+// RedactEnvMatching replaces the values of environment variables of which the name matches any of the patterns
+func RedactEnvMatching(env []string, patterns ...*regexp.Regexp) []string {
+	out := make([]string, 0, len(env))
+	for _, item := range env {
+		name, _, hasValue := strings.Cut(item, "=")
+		if hasValue && slices.ContainsFunc(patterns, func(p *regexp.Regexp) bool { return p.MatchString(name) }) {
+			item = name + "=" + RedactedValue
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// ResolveContainers returns the containers (including stopped containers) matching a name, which is
+// either a container name or id, a container-group service ("project@service"), or a container-group
+// (compose project) in which case all of its containers are returned
+func (c *ContainerClient) ResolveContainers(ctx context.Context, name string) ([]string, error) {
+	if project, service, ok := ParseContainerGroup(name); ok {
+		return c.ListProjectContainers(ctx, project, service)
+	}
+	if con, err := c.Client.ContainerInspect(ctx, name); err == nil {
+		return []string{con.ID}, nil
+	}
+	return c.ListProjectContainers(ctx, name, "")
+}
+
+// ListProjectContainers returns the containers (including stopped containers) of a compose project, or
+// of one of its services
+func (c *ContainerClient) ListProjectContainers(ctx context.Context, project string, service string) ([]string, error) {
+	if resolved, err := c.ResolveComposeProjectName(ctx, project); err == nil && resolved != "" {
+		project = resolved
+	}
+	projectFilter := filters.NewArgs(
+		filters.Arg("label", "com.docker.compose.project="+project),
+	)
+	if service != "" {
+		projectFilter.Add("label", "com.docker.compose.service="+service)
+	}
+	items, err := c.Client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: projectFilter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+// diagnosticsArchive writes files to a gzip compressed tarball, and records the errors
+// collecting the information so that a partial bundle is still useful
+type diagnosticsArchive struct {
+	tw     *tar.Writer
+	errors []string
+}
+
+func (a *diagnosticsArchive) AddFile(name string, data []byte) error {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a *diagnosticsArchive) AddJSON(name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		a.AddError(name, err)
+		return nil
+	}
+	return a.AddFile(name, b)
+}
+
+func (a *diagnosticsArchive) AddError(name string, err error) {
+	slog.Warn("Could not collect diagnostics.", "item", name, "err", err)
+	a.errors = append(a.errors, fmt.Sprintf("%s: %s", name, err))
+}
+
+// WriteDiagnostics writes a gzip compressed tarball with the diagnostics of the containers: the inspect
+// output (with redacted environment variables), recent logs, resource usage, and the compose files
+// of container-groups, along with the container engine information
+func (c *ContainerClient) WriteDiagnostics(ctx context.Context, w io.Writer, containerIDs []string, opts DiagnosticsOptions) error {
+	gz := gzip.NewWriter(w)
+	archive := &diagnosticsArchive{
+		tw: tar.NewWriter(gz),
+	}
+
+	engine := map[string]any{
+		"type":    c.Engine.Type,
+		"version": c.Engine.Version,
+		"host":    c.Client.DaemonHost(),
+	}
+	if info, err := c.Client.Info(ctx); err == nil {
+		engine["info"] = info
+	} else {
+		archive.AddError("engine.json", err)
+	}
+	if err := archive.AddJSON("engine.json", engine); err != nil {
+		return err
+	}
+
+	projects := make(map[string]struct{})
+	for _, id := range containerIDs {
+		con, err := c.Client.ContainerInspect(ctx, id)
+		if err != nil {
+			archive.AddError(id, err)
+			continue
+		}
+		dir := strings.TrimPrefix(con.Name, "/")
+		if con.Config != nil {
+			con.Config.Env = RedactEnv(con.Config.Env)
+		}
+		if err := archive.AddJSON(dir+"/inspect.json", con); err != nil {
+			return err
+		}
+
+		logs := &bytes.Buffer{}
+		if err := c.ContainerLogs(ctx, logs, con.ID, LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Timestamps: true,
+			Tail:       fmt.Sprintf("%d", opts.LogLines),
+		}); err != nil {
+			archive.AddError(dir+"/logs.txt", err)
+		}
+		if err := archive.AddFile(dir+"/logs.txt", logs.Bytes()); err != nil {
+			return err
+		}
+
+		if con.State != nil && con.State.Running {
+			if stats, err := c.GetStatsEntry(ctx, con.ID); err == nil {
+				if err := archive.AddJSON(dir+"/stats.json", stats); err != nil {
+					return err
+				}
+			} else {
+				archive.AddError(dir+"/stats.json", err)
+			}
+		}
+
+		// Include the compose files of the container-group (once per project)
+		if con.Config == nil {
+			continue
+		}
+		project := con.Config.Labels["com.docker.compose.project"]
+		if _, done := projects[project]; project == "" || done {
+			continue
+		}
+		projects[project] = struct{}{}
+		for _, file := range strings.Split(con.Config.Labels["com.docker.compose.project.config_files"], ",") {
+			if file = strings.TrimSpace(file); file == "" {
+				continue
+			}
+			name := "compose/" + project + "/" + filepath.Base(file)
+			// The labels are set by the container, so only read files of projects deployed by the plugin
+			if !isProjectFile(opts.ComposeDir, project, file) {
+				slog.Info("Skipping compose file which is not in the project's directory.", "project", project, "file", file)
+				continue
+			}
+			contents, err := os.ReadFile(file)
+			if err != nil {
+				archive.AddError(name, err)
+				continue
+			}
+			contents, err = RedactComposeFile(contents)
+			if err != nil {
+				archive.AddError(name, fmt.Errorf("could not redact the compose file. %w", err))
+				continue
+			}
+			if err := archive.AddFile(name, contents); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(archive.errors) > 0 {
+		if err := archive.AddFile("errors.txt", []byte(strings.Join(archive.errors, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := archive.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// isProjectFile checks if a file is inside of the directory of a project deployed by the plugin
+// (after resolving any symlinks)
+func isProjectFile(composeDir string, project string, file string) bool {
+	if composeDir == "" || project == "." || project == ".." || filepath.Base(project) != project {
+		return false
+	}
+	projectDir, err := filepath.EvalSymlinks(filepath.Join(composeDir, project))
+	if err != nil {
+		return false
+	}
+	path, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(projectDir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// RedactComposeFile replaces the values of the sensitive environment variables of the services
+// of a compose file, in the same way as RedactEnv
+func RedactComposeFile(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return data, nil
+	}
+	servicesNode := resolveAlias(findMappingValue(doc.Content[0], "services"))
+	if servicesNode == nil || servicesNode.Kind != yaml.MappingNode {
+		return data, nil
+	}
+	for i := 0; i+1 < len(servicesNode.Content); i += 2 {
+		redactEnvironmentNode(findMappingValue(resolveAlias(servicesNode.Content[i+1]), "environment"))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc.Content[0]); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// redactEnvironmentNode redacts the environment of a service, either in the mapping ("KEY: value")
+// or sequence ("KEY=value") style. Merged (anchored) environments are also redacted
+func redactEnvironmentNode(node *yaml.Node) {
+	node = resolveAlias(node)
+	if node == nil {
+		return
+	}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item = resolveAlias(item); item.Kind == yaml.ScalarNode {
+				item.Value = RedactEnv([]string{item.Value})[0]
+			} else {
+				// Sequence of merged mappings
+				redactEnvironmentNode(item)
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				redactEnvironmentNode(value)
+				continue
+			}
+			if value = resolveAlias(value); value.Kind == yaml.ScalarNode && value.Tag != "!!null" && sensitiveEnvPattern.MatchString(key.Value) {
+				value.Value = RedactedValue
+				value.Tag = "!!str"
+				value.Style = 0
+			}
+		}
+	}
+}
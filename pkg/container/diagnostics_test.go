@@ -0,0 +1,84 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RedactEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/local/bin:/usr/bin",
+		"DB_PASSWORD=secret1",
+		"DB_PASS=secret2",
+		"API_TOKEN=abc",
+		"AWS_SECRET_ACCESS_KEY=def",
+		"SSH_KEY=ghi",
+		"MQTT_AUTH=jkl",
+		"LOG_LEVEL=debug",
+		"KEYBOARD=us",
+		"EMPTY",
+	}
+	assert.Equal(t, []string{
+		"PATH=/usr/local/bin:/usr/bin",
+		"DB_PASSWORD=" + RedactedValue,
+		"DB_PASS=" + RedactedValue,
+		"API_TOKEN=" + RedactedValue,
+		"AWS_SECRET_ACCESS_KEY=" + RedactedValue,
+		"SSH_KEY=" + RedactedValue,
+		"MQTT_AUTH=" + RedactedValue,
+		"LOG_LEVEL=debug",
+		"KEYBOARD=us",
+		"EMPTY",
+	}, RedactEnv(env))
+}
+
+func Test_RedactComposeFile(t *testing.T) {
+	contents := `
+x-env: &default-env
+  SHARED_TOKEN: shared
+services:
+  app:
+    image: example/app
+    environment:
+      <<: *default-env
+      DB_PASSWORD: secret1
+      LOG_LEVEL: debug
+  worker:
+    image: example/worker
+    environment:
+      - API_KEY=secret2
+      - MODE=worker
+`
+	out, err := RedactComposeFile([]byte(contents))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "secret1")
+	assert.NotContains(t, string(out), "secret2")
+	assert.NotContains(t, string(out), "SHARED_TOKEN: shared")
+	assert.Contains(t, string(out), "DB_PASSWORD: '********'")
+	assert.Contains(t, string(out), "API_KEY=********")
+	assert.Contains(t, string(out), "LOG_LEVEL: debug")
+	assert.Contains(t, string(out), "MODE=worker")
+}
+
+func Test_IsProjectFile(t *testing.T) {
+	composeDir := t.TempDir()
+	projectDir := filepath.Join(composeDir, "app")
+	assert.NoError(t, os.MkdirAll(projectDir, 0755))
+	composeFile := filepath.Join(projectDir, "docker-compose.yaml")
+	assert.NoError(t, os.WriteFile(composeFile, []byte("services: {}\n"), 0644))
+
+	outside := filepath.Join(t.TempDir(), "secret.key")
+	assert.NoError(t, os.WriteFile(outside, []byte("secret"), 0600))
+	link := filepath.Join(projectDir, "link.yaml")
+	assert.NoError(t, os.Symlink(outside, link))
+
+	assert.True(t, isProjectFile(composeDir, "app", composeFile))
+	assert.False(t, isProjectFile(composeDir, "app", outside))
+	assert.False(t, isProjectFile(composeDir, "app", link))
+	assert.False(t, isProjectFile(composeDir, "app", filepath.Join(projectDir, "..", "..", "etc", "shadow")))
+	assert.False(t, isProjectFile(composeDir, "..", composeFile))
+	assert.False(t, isProjectFile("", "app", composeFile))
+}
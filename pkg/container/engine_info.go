@@ -0,0 +1,98 @@
+package container
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+)
+
+// EngineInfo describes the container engine, e.g. to help support a device remotely
+type EngineInfo struct {
+	Type            EngineType `json:"type"`
+	Name            string     `json:"name,omitempty"`
+	Host            string     `json:"host,omitempty"`
+	Version         string     `json:"version,omitempty"`
+	APIVersion      string     `json:"apiVersion,omitempty"`
+	OperatingSystem string     `json:"operatingSystem,omitempty"`
+	Architecture    string     `json:"architecture,omitempty"`
+	KernelVersion   string     `json:"kernelVersion,omitempty"`
+	StorageDriver   string     `json:"storageDriver,omitempty"`
+	CgroupDriver    string     `json:"cgroupDriver,omitempty"`
+	CgroupVersion   string     `json:"cgroupVersion,omitempty"`
+	Rootless        bool       `json:"rootless"`
+	// EventsBackend is the events logger of podman (e.g. journald, file, none)
+	EventsBackend string `json:"eventsBackend,omitempty"`
+}
+
+// NewEngineInfo creates the engine info from the engine's info and version responses
+func NewEngineInfo(engine EngineCapabilities, info system.Info, serverVersion types.Version) EngineInfo {
+	engineInfo := EngineInfo{
+		Type:            engine.Type,
+		Name:            serverVersion.Platform.Name,
+		Version:         engine.Version,
+		APIVersion:      serverVersion.APIVersion,
+		OperatingSystem: info.OperatingSystem,
+		Architecture:    info.Architecture,
+		KernelVersion:   info.KernelVersion,
+		StorageDriver:   info.Driver,
+		CgroupDriver:    info.CgroupDriver,
+		CgroupVersion:   info.CgroupVersion,
+		Rootless:        isRootless(info.SecurityOptions),
+	}
+	if engineInfo.Version == "" {
+		engineInfo.Version = serverVersion.Version
+	}
+	return engineInfo
+}
+
+// isRootless checks if the security options of the engine include rootless, e.g. "name=rootless"
+func isRootless(securityOptions []string) bool {
+	for _, option := range securityOptions {
+		if slices.Contains(strings.Split(option, ","), "name=rootless") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEngineInfo reads the information about the container engine
+func (c *ContainerClient) GetEngineInfo(ctx context.Context) (EngineInfo, error) {
+	info, err := c.Client.Info(ctx)
+	if err != nil {
+		return EngineInfo{}, err
+	}
+	serverVersion, err := c.Client.ServerVersion(ctx)
+	if err != nil {
+		return EngineInfo{}, err
+	}
+	engineInfo := NewEngineInfo(c.Engine, info, serverVersion)
+	engineInfo.Host = c.Client.DaemonHost()
+	if c.Engine.HasLibPodAPI && c.LibPod != nil {
+		engineInfo.EventsBackend = c.LibPod.GetEventsBackend(ctx)
+	}
+	return engineInfo, nil
+}
+
+// ComposeInfo describes the compose backend which is used to deploy container groups
+type ComposeInfo struct {
+	Backend string `json:"backend"`
+	Version string `json:"version,omitempty"`
+}
+
+// GetComposeInfo detects the compose backend and its version
+func GetComposeInfo() (ComposeInfo, error) {
+	command, err := detectComposeFunc()
+	if err != nil {
+		return ComposeInfo{}, err
+	}
+	composeInfo := ComposeInfo{
+		Backend: command.Base.String(),
+	}
+	if command.Version != nil {
+		composeInfo.Version = command.Version.String()
+	}
+	return composeInfo, nil
+}
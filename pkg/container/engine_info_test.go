@@ -0,0 +1,65 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/hashicorp/go-version"
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/cmdbuilder"
+)
+
+func Test_NewEngineInfo(t *testing.T) {
+	serverVersion := types.Version{
+		Version:    "5.2.1",
+		APIVersion: "1.41",
+	}
+	serverVersion.Platform.Name = "Podman Engine"
+
+	info := NewEngineInfo(
+		EngineCapabilities{Type: EnginePodman, HasLibPodAPI: true},
+		system.Info{
+			Driver:          "overlay",
+			CgroupDriver:    "systemd",
+			CgroupVersion:   "2",
+			OperatingSystem: "debian",
+			Architecture:    "arm64",
+			SecurityOptions: []string{"name=seccomp,profile=default", "name=rootless"},
+		},
+		serverVersion,
+	)
+	assert.Equal(t, EnginePodman, info.Type)
+	assert.Equal(t, "Podman Engine", info.Name)
+	assert.Equal(t, "5.2.1", info.Version)
+	assert.Equal(t, "1.41", info.APIVersion)
+	assert.Equal(t, "overlay", info.StorageDriver)
+	assert.Equal(t, "2", info.CgroupVersion)
+	assert.True(t, info.Rootless)
+}
+
+func Test_NewEngineInfoRootful(t *testing.T) {
+	info := NewEngineInfo(
+		EngineCapabilities{Type: EngineDocker, Version: "27.3.1"},
+		system.Info{
+			SecurityOptions: []string{"name=apparmor", "name=seccomp,profile=builtin", "name=cgroupns"},
+		},
+		types.Version{Version: "ignored", APIVersion: "1.47"},
+	)
+	assert.Equal(t, "27.3.1", info.Version)
+	assert.False(t, info.Rootless)
+}
+
+func Test_GetComposeInfo(t *testing.T) {
+	oldDetect := detectComposeFunc
+	detectComposeFunc = func() (*cmdbuilder.Command, error) {
+		v, _ := version.NewVersion("2.29.7")
+		return &cmdbuilder.Command{Base: cmdbuilder.NewBaseCommand("docker", "compose"), Args: []string{}, Version: v}, nil
+	}
+	defer func() { detectComposeFunc = oldDetect }()
+
+	info, err := GetComposeInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, "docker compose", info.Backend)
+	assert.Equal(t, "2.29.7", info.Version)
+}
@@ -0,0 +1,110 @@
+package container
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/docker/docker/api/types"
+)
+
+// EngineUsage is the engine-wide usage of the container engine: the number of containers per state,
+// the number of images, and the disk usage of the images, containers, volumes and build cache
+type EngineUsage struct {
+	// Containers is the number of containers per state, e.g. running, exited
+	Containers map[string]int
+	Images     int
+
+	ImagesSize     int64
+	ContainersSize int64
+	VolumesSize    int64
+	BuildCacheSize int64
+
+	// DataRoot is the engine's data root directory, and the size, used and free space of its filesystem.
+	// The size is 0 if the filesystem could not be read (e.g. it is not accessible to the plugin)
+	DataRoot      string
+	DataRootSize  uint64
+	DataRootUsed  uint64
+	DataRootFree  uint64
+	DataRootError error
+}
+
+// NewEngineUsage summarizes the disk usage returned by the engine
+func NewEngineUsage(du types.DiskUsage) *EngineUsage {
+	usage := &EngineUsage{
+		Containers: make(map[string]int),
+		Images:     len(du.Images),
+		ImagesSize: du.LayersSize,
+	}
+	if usage.ImagesSize == 0 {
+		// Not all engines report the total size of the (shared) layers
+		for _, item := range du.Images {
+			if item != nil {
+				usage.ImagesSize += item.Size
+			}
+		}
+	}
+	for _, item := range du.Containers {
+		if item == nil {
+			continue
+		}
+		usage.Containers[item.State]++
+		usage.ContainersSize += item.SizeRw
+	}
+	for _, item := range du.Volumes {
+		// The size is -1 if it is not available
+		if item != nil && item.UsageData != nil && item.UsageData.Size > 0 {
+			usage.VolumesSize += item.UsageData.Size
+		}
+	}
+	for _, item := range du.BuildCache {
+		if item != nil && !item.Shared {
+			usage.BuildCacheSize += item.Size
+		}
+	}
+	return usage
+}
+
+// TotalContainers returns the number of containers
+func (u *EngineUsage) TotalContainers() int {
+	total := 0
+	for _, count := range u.Containers {
+		total += count
+	}
+	return total
+}
+
+// DataRootUsedPercent returns the used space of the data root filesystem in percent,
+// and false if it is not known
+func (u *EngineUsage) DataRootUsedPercent() (float64, bool) {
+	// Same as df, the space reserved for the root user is not included
+	if u.DataRootSize == 0 || u.DataRootUsed+u.DataRootFree == 0 {
+		return 0, false
+	}
+	return 100 * float64(u.DataRootUsed) / float64(u.DataRootUsed+u.DataRootFree), true
+}
+
+// GetEngineUsage reads the engine-wide usage. The free space of the data root filesystem is read from
+// dataRoot, or the engine's data root directory if empty
+func (c *ContainerClient) GetEngineUsage(ctx context.Context, dataRoot string) (*EngineUsage, error) {
+	du, err := c.Client.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		return nil, err
+	}
+	usage := NewEngineUsage(du)
+
+	usage.DataRoot = dataRoot
+	if usage.DataRoot == "" {
+		info, err := c.Client.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		usage.DataRoot = info.DockerRootDir
+	}
+	if usage.DataRoot != "" {
+		usage.DataRootSize, usage.DataRootUsed, usage.DataRootFree, usage.DataRootError = filesystemUsage(usage.DataRoot)
+		if usage.DataRootError != nil {
+			slog.Debug("Could not read the free space of the engine's data root.", "path", usage.DataRoot, "err", usage.DataRootError)
+		}
+	}
+	return usage, nil
+}
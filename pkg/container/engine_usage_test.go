@@ -0,0 +1,62 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewEngineUsage(t *testing.T) {
+	usage := NewEngineUsage(types.DiskUsage{
+		LayersSize: 1000,
+		Images:     []*image.Summary{{Size: 600}, {Size: 700}},
+		Containers: []*container.Summary{
+			{State: "running", SizeRw: 10},
+			{State: "running", SizeRw: 20},
+			{State: "exited", SizeRw: 5},
+		},
+		Volumes: []*volume.Volume{
+			{UsageData: &volume.UsageData{Size: 100}},
+			{UsageData: &volume.UsageData{Size: -1}},
+			{},
+		},
+		BuildCache: []*build.CacheRecord{
+			{Size: 50},
+			{Size: 25, Shared: true},
+		},
+	})
+	assert.Equal(t, map[string]int{"running": 2, "exited": 1}, usage.Containers)
+	assert.Equal(t, 3, usage.TotalContainers())
+	assert.Equal(t, 2, usage.Images)
+	assert.Equal(t, int64(1000), usage.ImagesSize)
+	assert.Equal(t, int64(35), usage.ContainersSize)
+	assert.Equal(t, int64(100), usage.VolumesSize)
+	assert.Equal(t, int64(50), usage.BuildCacheSize)
+
+	_, known := usage.DataRootUsedPercent()
+	assert.False(t, known)
+}
+
+func Test_NewEngineUsageWithoutLayersSize(t *testing.T) {
+	usage := NewEngineUsage(types.DiskUsage{
+		Images: []*image.Summary{{Size: 600}, {Size: 700}},
+	})
+	assert.Equal(t, int64(1300), usage.ImagesSize)
+	assert.Equal(t, 0, usage.TotalContainers())
+}
+
+func Test_EngineUsageDataRootUsedPercent(t *testing.T) {
+	usage := &EngineUsage{
+		DataRootSize: 1000,
+		DataRootUsed: 750,
+		DataRootFree: 200,
+	}
+	percent, known := usage.DataRootUsedPercent()
+	assert.True(t, known)
+	assert.InDelta(t, 78.95, percent, 0.01)
+}
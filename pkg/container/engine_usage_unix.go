@@ -0,0 +1,16 @@
+//go:build !windows
+
+package container
+
+import "syscall"
+
+// filesystemUsage returns the size, the used space and the space available to unprivileged users of
+// the filesystem of a path
+func filesystemUsage(path string) (uint64, uint64, uint64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	blockSize := uint64(stat.Bsize)
+	return uint64(stat.Blocks) * blockSize, uint64(stat.Blocks-stat.Bfree) * blockSize, uint64(stat.Bavail) * blockSize, nil
+}
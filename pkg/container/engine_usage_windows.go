@@ -0,0 +1,10 @@
+//go:build windows
+
+package container
+
+import "errors"
+
+// filesystemUsage is not supported on windows, so the data root usage (and disk usage alarm) is skipped
+func filesystemUsage(path string) (uint64, uint64, uint64, error) {
+	return 0, 0, 0, errors.New("reading the filesystem usage is not supported on windows")
+}
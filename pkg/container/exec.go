@@ -0,0 +1,99 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// LimitedBuffer is a buffer which only stores the first Limit bytes written to it.
+// Any additional data is discarded (without an error), so that the writer is never blocked.
+type LimitedBuffer struct {
+	Limit int
+
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func NewLimitedBuffer(limit int) *LimitedBuffer {
+	return &LimitedBuffer{
+		Limit: limit,
+	}
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.Limit - b.buf.Len()
+	if remaining <= 0 {
+		b.truncated = b.truncated || len(p) > 0
+		return len(p), nil
+	}
+	if len(p) > remaining {
+		b.buf.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the stored data
+func (b *LimitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// Len returns the length of the stored data
+func (b *LimitedBuffer) Len() int {
+	return b.buf.Len()
+}
+
+// Truncated reports if more data than the limit was written
+func (b *LimitedBuffer) Truncated() bool {
+	return b.truncated
+}
+
+// Exec runs a command inside a running container, and writes the combined stdout and stderr to w.
+// It returns the exit code of the command. If the context is cancelled (e.g. a timeout) the output
+// is no longer read, however the command is not stopped as the exec api does not support it.
+func (c *ContainerClient) Exec(ctx context.Context, containerID string, cmd []string, w io.Writer) (int, error) {
+	slog.Info("Executing command in container.", "id", containerID, "cmd", cmd)
+	resp, err := c.Client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	attach, err := c.Client.ContainerExecAttach(ctx, resp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return -1, err
+	}
+	defer attach.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, copyErr := StdCopy(w, w, attach.Reader)
+		done <- copyErr
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Stop reading the output, and wait so that w is no longer written to
+		attach.Close()
+		<-done
+		return -1, ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	inspect, err := c.Client.ContainerExecInspect(ctx, resp.ID)
+	if err != nil {
+		return -1, err
+	}
+	return inspect.ExitCode, nil
+}
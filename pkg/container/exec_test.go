@@ -0,0 +1,34 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LimitedBuffer(t *testing.T) {
+	buf := NewLimitedBuffer(5)
+	n, err := buf.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.False(t, buf.Truncated())
+
+	n, err = buf.Write([]byte("defgh"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.True(t, buf.Truncated())
+	assert.Equal(t, "abcde", string(buf.Bytes()))
+
+	n, err = buf.Write([]byte("ijk"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 5, buf.Len())
+}
+
+func Test_LimitedBufferExactLimit(t *testing.T) {
+	buf := NewLimitedBuffer(3)
+	_, _ = buf.Write([]byte("abc"))
+	assert.False(t, buf.Truncated())
+	_, _ = buf.Write([]byte{})
+	assert.False(t, buf.Truncated())
+}
@@ -0,0 +1,209 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Optional fields which can be included in the container twin information
+const (
+	TwinFieldRestartCount  = "restartCount"
+	TwinFieldHealth        = "health"
+	TwinFieldExitCode      = "exitCode"
+	TwinFieldTimestamps    = "timestamps"
+	TwinFieldIPAddresses   = "ipAddresses"
+	TwinFieldMounts        = "mounts"
+	TwinFieldRestartPolicy = "restartPolicy"
+	TwinFieldResources     = "resources"
+	TwinFieldLabels        = "labels"
+	TwinFieldEnv           = "env"
+)
+
+// TwinFields are all of the optional twin fields
+var TwinFields = []string{
+	TwinFieldRestartCount,
+	TwinFieldHealth,
+	TwinFieldExitCode,
+	TwinFieldTimestamps,
+	TwinFieldIPAddresses,
+	TwinFieldMounts,
+	TwinFieldRestartPolicy,
+	TwinFieldResources,
+	TwinFieldLabels,
+	TwinFieldEnv,
+}
+
+// TwinOptions controls the optional information which is included in the container twin
+type TwinOptions struct {
+	// Fields are the optional fields to include, see TwinFields
+	Fields []string
+	// Labels are the labels which are included, either the label name, or a prefix ending with "*",
+	// e.g. "org.opencontainers.image.*"
+	Labels []string
+	// EnvRedactPatterns match the names of additional environment variables of which the values are
+	// redacted. The default pattern is always used
+	EnvRedactPatterns []*regexp.Regexp
+}
+
+// Enabled checks if any optional fields are included
+func (o TwinOptions) Enabled() bool {
+	return len(o.Fields) > 0
+}
+
+// Has checks if a field is included
+func (o TwinOptions) Has(field string) bool {
+	return slices.Contains(o.Fields, field)
+}
+
+// CompileRedactPatterns compiles the patterns of the environment variables to redact. An error is
+// returned if any of the patterns is invalid, as the variables it should match would not be redacted
+func CompileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid env redact pattern. pattern=%s, err=%w", pattern, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// TwinMount is a volume or bind mount of a container
+type TwinMount struct {
+	Type        string `json:"type,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	ReadOnly    bool   `json:"readOnly"`
+}
+
+// TwinResources are the resource limits of a container
+type TwinResources struct {
+	CPUs              float64 `json:"cpus,omitempty"`
+	CPUShares         int64   `json:"cpuShares,omitempty"`
+	Memory            int64   `json:"memory,omitempty"`
+	MemoryReservation int64   `json:"memoryReservation,omitempty"`
+	MemorySwap        int64   `json:"memorySwap,omitempty"`
+	PidsLimit         int64   `json:"pidsLimit,omitempty"`
+}
+
+// AddTwinDetails inspects the container and adds the optional twin information to it
+func (c *ContainerClient) AddTwinDetails(ctx context.Context, con *Container, opts TwinOptions) error {
+	if !opts.Enabled() {
+		return nil
+	}
+	inspect, err := c.Client.ContainerInspect(ctx, con.Id)
+	if err != nil {
+		return err
+	}
+	ApplyTwinDetails(con, inspect, opts)
+	return nil
+}
+
+// ApplyTwinDetails adds the optional twin information from the container's inspect output
+func ApplyTwinDetails(con *Container, inspect container.InspectResponse, opts TwinOptions) {
+	if inspect.ContainerJSONBase == nil {
+		return
+	}
+	if opts.Has(TwinFieldRestartCount) {
+		count := inspect.RestartCount
+		con.RestartCount = &count
+	}
+	if state := inspect.State; state != nil {
+		if opts.Has(TwinFieldHealth) && state.Health != nil {
+			con.Health = string(state.Health.Status)
+			streak := state.Health.FailingStreak
+			con.FailingStreak = &streak
+		}
+		if opts.Has(TwinFieldExitCode) && !state.Running && state.FinishedAt != "" && !strings.HasPrefix(state.FinishedAt, "0001-") {
+			exitCode := state.ExitCode
+			con.ExitCode = &exitCode
+		}
+		if opts.Has(TwinFieldTimestamps) {
+			// The engines use the zero time if the container was never started/stopped
+			if !strings.HasPrefix(state.StartedAt, "0001-") {
+				con.StartedAt = state.StartedAt
+			}
+			if !strings.HasPrefix(state.FinishedAt, "0001-") {
+				con.FinishedAt = state.FinishedAt
+			}
+		}
+	}
+	if opts.Has(TwinFieldIPAddresses) && inspect.NetworkSettings != nil {
+		for name, network := range inspect.NetworkSettings.Networks {
+			if network == nil || network.IPAddress == "" {
+				continue
+			}
+			if con.IPAddresses == nil {
+				con.IPAddresses = make(map[string]string)
+			}
+			con.IPAddresses[name] = network.IPAddress
+		}
+	}
+	if opts.Has(TwinFieldMounts) {
+		for _, mount := range inspect.Mounts {
+			con.Mounts = append(con.Mounts, TwinMount{
+				Type:        string(mount.Type),
+				Name:        mount.Name,
+				Source:      mount.Source,
+				Destination: mount.Destination,
+				ReadOnly:    !mount.RW,
+			})
+		}
+	}
+	if hostConfig := inspect.HostConfig; hostConfig != nil {
+		if opts.Has(TwinFieldRestartPolicy) {
+			con.RestartPolicy = string(hostConfig.RestartPolicy.Name)
+		}
+		if opts.Has(TwinFieldResources) {
+			resources := TwinResources{
+				CPUs:              float64(hostConfig.NanoCPUs) / 1e9,
+				CPUShares:         hostConfig.CPUShares,
+				Memory:            hostConfig.Memory,
+				MemoryReservation: hostConfig.MemoryReservation,
+				MemorySwap:        hostConfig.MemorySwap,
+			}
+			if hostConfig.PidsLimit != nil {
+				resources.PidsLimit = *hostConfig.PidsLimit
+			}
+			con.Resources = &resources
+		}
+	}
+	if config := inspect.Config; config != nil {
+		if opts.Has(TwinFieldLabels) {
+			for key, value := range config.Labels {
+				if !matchLabelNames(key, opts.Labels) {
+					continue
+				}
+				if con.TwinLabels == nil {
+					con.TwinLabels = make(map[string]string)
+				}
+				con.TwinLabels[key] = value
+			}
+		}
+		if opts.Has(TwinFieldEnv) {
+			// The custom patterns are in addition to the default pattern, so they can't expose secrets
+			con.Env = RedactEnvMatching(config.Env, append([]*regexp.Regexp{sensitiveEnvPattern}, opts.EnvRedactPatterns...)...)
+		}
+	}
+}
+
+// matchLabelNames checks if a label is included, either by name, or by a prefix ending with "*"
+func matchLabelNames(key string, labels []string) bool {
+	for _, label := range labels {
+		if prefix, isPrefix := strings.CutSuffix(label, "*"); isPrefix {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == label {
+			return true
+		}
+	}
+	return false
+}
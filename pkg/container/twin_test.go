@@ -0,0 +1,114 @@
+package container
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ApplyTwinDetails(t *testing.T) {
+	pidsLimit := int64(100)
+	inspect := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			RestartCount: 3,
+			State: &container.State{
+				Running:    false,
+				ExitCode:   137,
+				StartedAt:  "2024-01-01T10:00:00Z",
+				FinishedAt: "2024-01-01T11:00:00Z",
+				Health: &container.Health{
+					Status:        container.Unhealthy,
+					FailingStreak: 4,
+				},
+			},
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+				Resources: container.Resources{
+					NanoCPUs:  1500000000,
+					Memory:    256 * 1024 * 1024,
+					PidsLimit: &pidsLimit,
+				},
+			},
+		},
+		Mounts: []container.MountPoint{
+			{Type: mount.TypeVolume, Name: "data", Source: "/var/lib/docker/volumes/data/_data", Destination: "/data", RW: true},
+			{Type: mount.TypeBind, Source: "/etc/app", Destination: "/config", RW: false},
+		},
+		Config: &container.Config{
+			Labels: map[string]string{
+				"org.opencontainers.image.version": "1.2.3",
+				"org.opencontainers.image.source":  "https://example.com",
+				"com.example.team":                 "iot",
+				"com.example.other":                "ignored",
+			},
+			Env: []string{"MODE=prod", "DB_PASSWORD=secret", "CUSTOM_VALUE=hidden"},
+		},
+		NetworkSettings: &container.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"bridge":  {IPAddress: "172.17.0.2"},
+				"backend": {IPAddress: ""},
+			},
+		},
+	}
+
+	t.Run("no fields", func(t *testing.T) {
+		con := Container{}
+		ApplyTwinDetails(&con, inspect, TwinOptions{})
+		assert.Equal(t, Container{}, con)
+	})
+
+	t.Run("all fields", func(t *testing.T) {
+		con := Container{}
+		ApplyTwinDetails(&con, inspect, TwinOptions{
+			Fields: TwinFields,
+			Labels: []string{"org.opencontainers.image.*", "com.example.team"},
+		})
+		assert.Equal(t, 3, *con.RestartCount)
+		assert.Equal(t, "unhealthy", con.Health)
+		assert.Equal(t, 4, *con.FailingStreak)
+		assert.Equal(t, 137, *con.ExitCode)
+		assert.Equal(t, "2024-01-01T10:00:00Z", con.StartedAt)
+		assert.Equal(t, "2024-01-01T11:00:00Z", con.FinishedAt)
+		assert.Equal(t, map[string]string{"bridge": "172.17.0.2"}, con.IPAddresses)
+		assert.Len(t, con.Mounts, 2)
+		assert.False(t, con.Mounts[0].ReadOnly)
+		assert.True(t, con.Mounts[1].ReadOnly)
+		assert.Equal(t, "unless-stopped", con.RestartPolicy)
+		assert.Equal(t, &TwinResources{CPUs: 1.5, Memory: 256 * 1024 * 1024, PidsLimit: 100}, con.Resources)
+		assert.Equal(t, map[string]string{
+			"org.opencontainers.image.version": "1.2.3",
+			"org.opencontainers.image.source":  "https://example.com",
+			"com.example.team":                 "iot",
+		}, con.TwinLabels)
+		assert.Equal(t, []string{"MODE=prod", "DB_PASSWORD=" + RedactedValue, "CUSTOM_VALUE=hidden"}, con.Env)
+	})
+
+	t.Run("custom redact patterns", func(t *testing.T) {
+		con := Container{}
+		ApplyTwinDetails(&con, inspect, TwinOptions{
+			Fields:            []string{TwinFieldEnv},
+			EnvRedactPatterns: []*regexp.Regexp{regexp.MustCompile("^CUSTOM_")},
+		})
+		assert.Equal(t, []string{"MODE=prod", "DB_PASSWORD=" + RedactedValue, "CUSTOM_VALUE=" + RedactedValue}, con.Env)
+		assert.Nil(t, con.RestartCount)
+	})
+
+	t.Run("running container has no exit code", func(t *testing.T) {
+		running := inspect
+		base := *inspect.ContainerJSONBase
+		state := *base.State
+		state.Running = true
+		state.FinishedAt = "0001-01-01T00:00:00Z"
+		base.State = &state
+		running.ContainerJSONBase = &base
+
+		con := Container{}
+		ApplyTwinDetails(&con, running, TwinOptions{Fields: []string{TwinFieldExitCode, TwinFieldTimestamps}})
+		assert.Nil(t, con.ExitCode)
+		assert.Equal(t, "", con.FinishedAt)
+	})
+}
@@ -0,0 +1,112 @@
+// Package openmetrics writes metrics in the OpenMetrics text format
+// (https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md)
+// which can be scraped by Prometheus.
+package openmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType of the OpenMetrics text format
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Metric types
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+	TypeSummary = "summary"
+	TypeInfo    = "info"
+)
+
+// Label is a metric label. A slice is used (rather than a map) to keep the order stable
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels creates labels from name/value pairs, e.g. Labels("service", "app", "image", "nginx")
+func Labels(pairs ...string) []Label {
+	labels := make([]Label, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, Label{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return labels
+}
+
+// Writer writes metric families. Each family must be written in one block,
+// i.e. all samples of a family must follow its Family call
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+// Family writes the metadata of a metric family
+func (w *Writer) Family(name string, metricType string, help string) {
+	w.printf("# TYPE %s %s\n", name, metricType)
+	if help != "" {
+		w.printf("# HELP %s %s\n", name, escape(help, false))
+	}
+}
+
+// Sample writes a single sample. The name must include any suffix required
+// by the family type, e.g. "_total" for counters
+func (w *Writer) Sample(name string, labels []Label, value float64) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+// Close writes the EOF marker and flushes the output
+func (w *Writer) Close() error {
+	w.printf("# EOF\n")
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, label.Name, escape(label.Value, true)))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escape(v string, quotes bool) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	if quotes {
+		v = strings.ReplaceAll(v, `"`, `\"`)
+	}
+	return v
+}
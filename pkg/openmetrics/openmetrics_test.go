@@ -0,0 +1,30 @@
+package openmetrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Writer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Family("container_cpu_percent", TypeGauge, "CPU usage\nin percent")
+	w.Sample("container_cpu_percent", Labels("service", "app", "image", `my "image"\1`), 1.5)
+	w.Sample("container_cpu_percent", Labels("service", "other"), math.NaN())
+	w.Family("updates", TypeCounter, "")
+	w.Sample("updates_total", nil, 10)
+	assert.NoError(t, w.Close())
+
+	expected := `# TYPE container_cpu_percent gauge
+# HELP container_cpu_percent CPU usage\nin percent
+container_cpu_percent{service="app",image="my \"image\"\\1"} 1.5
+container_cpu_percent{service="other"} NaN
+# TYPE updates counter
+updates_total 10
+# EOF
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,429 @@
+// Package registry is a minimal OCI distribution (registry v2) client which is
+// used to read image metadata, e.g. manifests and signatures, directly from a
+// container registry without pulling the image via the container engine.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	dockerRegistry "github.com/docker/docker/api/types/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// Max size of manifests and small blobs (e.g. signature payloads) which are read into memory
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+// ErrNotFound is returned when the manifest/blob does not exist in the registry
+var ErrNotFound = errors.New("not found")
+
+var manifestAccept = strings.Join([]string{
+	ocispec.MediaTypeImageManifest,
+	ocispec.MediaTypeImageIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}, ", ")
+
+// Credentials used to authenticate with the registry
+type Credentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// CredentialsFromRegistryAuth converts the encoded registry auth (as used by the
+// container engine's pull api) to credentials
+func CredentialsFromRegistryAuth(encoded string) Credentials {
+	if encoded == "" {
+		return Credentials{}
+	}
+	auth, err := dockerRegistry.DecodeAuthConfig(encoded)
+	if err != nil {
+		slog.Warn("Could not decode registry auth.", "err", err)
+		return Credentials{}
+	}
+	return Credentials{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		IdentityToken: auth.IdentityToken,
+	}
+}
+
+// Client to interact with a single registry
+type Client struct {
+	// Registry domain, e.g. docker.io, ghcr.io, localhost:5000
+	Registry    string
+	Credentials Credentials
+	HTTPClient  *http.Client
+
+	// PlainHTTP uses http instead of https
+	PlainHTTP bool
+
+	// bearer tokens by scope
+	tokens   map[string]string
+	tokensMu sync.Mutex
+}
+
+// NewClient creates a client for the registry which hosts the given image
+func NewClient(imageRef string, credentials Credentials) (*Client, error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	domain := reference.Domain(named)
+	host, _, _ := strings.Cut(domain, ":")
+	return &Client{
+		Registry:    domain,
+		Credentials: credentials,
+		HTTPClient:  &http.Client{Timeout: 60 * time.Second},
+		PlainHTTP:   host == "localhost" || host == "127.0.0.1" || host == "::1",
+		tokens:      make(map[string]string),
+	}, nil
+}
+
+// ParseReference splits an image reference into the repository path and the tag/digest
+// which are used by the registry api, e.g. "nginx:latest" => ("library/nginx", "latest")
+func ParseReference(imageRef string) (repository string, ref string, err error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return "", "", err
+	}
+	named = reference.TagNameOnly(named)
+	repository = reference.Path(named)
+	if canonical, ok := named.(reference.Canonical); ok {
+		return repository, canonical.Digest().String(), nil
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		return repository, tagged.Tag(), nil
+	}
+	return repository, "latest", nil
+}
+
+func (c *Client) baseURL() string {
+	host := c.Registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	if c.PlainHTTP {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// Resolve the digest of a manifest (without downloading it)
+func (c *Client) Resolve(ctx context.Context, repository string, ref string) (ocispec.Descriptor, error) {
+	resp, err := c.do(ctx, http.MethodHead, repository, fmt.Sprintf("/v2/%s/manifests/%s", repository, ref), manifestAccept)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		// Some registries don't return the digest for HEAD requests, so fallback to a GET
+		desc, _, getErr := c.GetManifest(ctx, repository, ref)
+		return desc, getErr
+	}
+	return ocispec.Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    dgst,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// GetManifest returns the raw manifest and its descriptor
+func (c *Client) GetManifest(ctx context.Context, repository string, ref string) (ocispec.Descriptor, []byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, repository, fmt.Sprintf("/v2/%s/manifests/%s", repository, ref), manifestAccept)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := readLimited(resp.Body, maxManifestSize)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	dgst := digest.FromBytes(b)
+	if expected, err := digest.Parse(ref); err == nil && expected != dgst {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("manifest digest mismatch. expected=%s, got=%s", expected, dgst)
+	}
+	return ocispec.Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    dgst,
+		Size:      int64(len(b)),
+	}, b, nil
+}
+
+// GetBlob reads a (small) blob into memory and verifies its digest
+func (c *Client) GetBlob(ctx context.Context, repository string, dgst digest.Digest) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, repository, fmt.Sprintf("/v2/%s/blobs/%s", repository, dgst), "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := readLimited(resp.Body, maxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	if got := digest.FromBytes(b); got != dgst {
+		return nil, fmt.Errorf("blob digest mismatch. expected=%s, got=%s", dgst, got)
+	}
+	return b, nil
+}
+
+// GetReferrers returns the manifests which refer to the given digest (OCI 1.1 referrers api),
+// optionally filtered by the artifact type. The referrers tag schema is used
+// if the registry does not support the referrers api.
+func (c *Client) GetReferrers(ctx context.Context, repository string, dgst digest.Digest, artifactType string) ([]ocispec.Descriptor, error) {
+	path := fmt.Sprintf("/v2/%s/referrers/%s", repository, dgst)
+	if artifactType != "" {
+		path += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+
+	var index ocispec.Index
+	resp, err := c.do(ctx, http.MethodGet, repository, path, ocispec.MediaTypeImageIndex)
+	if err == nil {
+		defer func() { _ = resp.Body.Close() }()
+		b, readErr := readLimited(resp.Body, maxManifestSize)
+		if readErr != nil {
+			return nil, readErr
+		}
+		if err := json.Unmarshal(b, &index); err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, ErrNotFound) {
+		// Fallback to the referrers tag schema: <alg>-<ref>
+		_, b, tagErr := c.GetManifest(ctx, repository, fmt.Sprintf("%s-%s", dgst.Algorithm(), dgst.Encoded()))
+		if tagErr != nil {
+			if errors.Is(tagErr, ErrNotFound) {
+				return nil, nil
+			}
+			return nil, tagErr
+		}
+		if err := json.Unmarshal(b, &index); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	referrers := make([]ocispec.Descriptor, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrers, nil
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("response exceeds the maximum size of %d bytes", limit)
+	}
+	return b, nil
+}
+
+// do sends a request to the registry, and handles the authentication challenge
+// if the registry requires it
+func (c *Client) do(ctx context.Context, method string, repository string, path string, accept string) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL()+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	if token := c.getToken(scope); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		_ = resp.Body.Close()
+
+		req, err = newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.authorize(ctx, req, challenge, scope); err != nil {
+			return nil, err
+		}
+		resp, err = c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s: %w", method, path, ErrNotFound)
+	}
+	return nil, fmt.Errorf("unexpected registry response. method=%s, path=%s, status=%s", method, path, resp.Status)
+}
+
+func (c *Client) getToken(scope string) string {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	return c.tokens[scope]
+}
+
+// authorize sets the authorization header on the request based on the registry's challenge
+func (c *Client) authorize(ctx context.Context, req *http.Request, challenge string, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.Credentials.Username == "" {
+			return fmt.Errorf("registry requires credentials. registry=%s", c.Registry)
+		}
+		req.SetBasicAuth(c.Credentials.Username, c.Credentials.Password)
+		return nil
+	case "bearer":
+		if s := params["scope"]; s != "" {
+			scope = s
+		}
+		token, err := c.fetchToken(ctx, params["realm"], params["service"], scope)
+		if err != nil {
+			return err
+		}
+		c.tokensMu.Lock()
+		c.tokens[scope] = token
+		c.tokensMu.Unlock()
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	default:
+		return fmt.Errorf("unsupported registry authentication challenge. challenge=%s", challenge)
+	}
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+func (c *Client) fetchToken(ctx context.Context, realm string, service string, scope string) (string, error) {
+	if realm == "" {
+		return "", fmt.Errorf("registry authentication challenge is missing the realm")
+	}
+
+	var req *http.Request
+	var err error
+	if c.Credentials.IdentityToken != "" {
+		// OAuth2 refresh token flow
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", c.Credentials.IdentityToken)
+		form.Set("service", service)
+		form.Set("scope", scope)
+		form.Set("client_id", "tedge-container-plugin")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		u, err := url.Parse(realm)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		if service != "" {
+			query.Set("service", service)
+		}
+		query.Set("scope", scope)
+		u.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if c.Credentials.Username != "" {
+			req.SetBasicAuth(c.Credentials.Username, c.Credentials.Password)
+		}
+	}
+
+	slog.Debug("Requesting registry token.", "realm", realm, "service", service, "scope", scope)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get registry token. status=%s", resp.Status)
+	}
+	b, err := readLimited(resp.Body, maxManifestSize)
+	if err != nil {
+		return "", err
+	}
+	token := tokenResponse{}
+	if err := json.Unmarshal(b, &token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g.
+// Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:thin-edge/tedge:pull"
+func parseChallenge(v string) (string, map[string]string) {
+	params := make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(v), " ")
+	for len(rest) > 0 {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,305 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const testArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+// tokenRegistry is a fake registry which requires a bearer token issued by its token endpoint
+type tokenRegistry struct {
+	*httptest.Server
+	Username string
+	Password string
+	Token    string
+
+	// Manifests by tag or digest
+	Manifests map[string][]byte
+	// Referrers enables the referrers api
+	Referrers bool
+
+	tokenRequests atomic.Int32
+	scopes        []string
+}
+
+func newTokenRegistry(t *testing.T) *tokenRegistry {
+	r := &tokenRegistry{
+		Username:  "user",
+		Password:  "secret",
+		Token:     "registry-token",
+		Manifests: make(map[string][]byte),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *tokenRegistry) client(t *testing.T, imagePath string, credentials Credentials) *Client {
+	client, err := NewClient(strings.TrimPrefix(r.URL, "http://")+"/"+imagePath, credentials)
+	assert.NoError(t, err)
+	return client
+}
+
+func (r *tokenRegistry) handle(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.tokenRequests.Add(1)
+		username, password, ok := req.BasicAuth()
+		if !ok || username != r.Username || password != r.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": r.Token})
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer "+r.Token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="repository:example/app:pull"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case strings.Contains(req.URL.Path, "/referrers/"):
+		if !r.Referrers {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		index := ocispec.Index{Manifests: []ocispec.Descriptor{
+			{MediaType: ocispec.MediaTypeImageManifest, ArtifactType: testArtifactType, Digest: digest.FromString("signature")},
+			{MediaType: ocispec.MediaTypeImageManifest, ArtifactType: "application/vnd.example.sbom", Digest: digest.FromString("sbom")},
+		}}
+		_ = json.NewEncoder(w).Encode(index)
+	case strings.Contains(req.URL.Path, "/manifests/"):
+		ref := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+		b, ok := r.Manifests[ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		if req.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(b)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func Test_ParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:thin-edge/tedge:pull"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://ghcr.io/token",
+		"service": "ghcr.io",
+		"scope":   "repository:thin-edge/tedge:pull",
+	}, params)
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	assert.Equal(t, "Basic", scheme)
+	assert.Equal(t, "registry", params["realm"])
+}
+
+func Test_ParseReference(t *testing.T) {
+	testcases := []struct {
+		Image      string
+		Repository string
+		Ref        string
+	}{
+		{Image: "nginx", Repository: "library/nginx", Ref: "latest"},
+		{Image: "ghcr.io/thin-edge/tedge:1.0.0", Repository: "thin-edge/tedge", Ref: "1.0.0"},
+		{Image: "localhost:5000/app@" + digest.FromString("app").String(), Repository: "app", Ref: digest.FromString("app").String()},
+	}
+	for _, tc := range testcases {
+		repository, ref, err := ParseReference(tc.Image)
+		assert.NoError(t, err, tc.Image)
+		assert.Equal(t, tc.Repository, repository, tc.Image)
+		assert.Equal(t, tc.Ref, ref, tc.Image)
+	}
+}
+
+func Test_BearerTokenChallenge(t *testing.T) {
+	r := newTokenRegistry(t)
+	manifest := []byte(`{"schemaVersion":2}`)
+	r.Manifests["1.0.0"] = manifest
+
+	client := r.client(t, "example/app:1.0.0", Credentials{Username: "user", Password: "secret"})
+	desc, b, err := client.GetManifest(context.Background(), "example/app", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, b)
+	assert.Equal(t, digest.FromBytes(manifest), desc.Digest)
+	assert.Equal(t, []string{"repository:example/app:pull"}, r.scopes)
+
+	// The token is reused for the following requests
+	desc, err = client.Resolve(context.Background(), "example/app", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(manifest), desc.Digest)
+	assert.Equal(t, int32(1), r.tokenRequests.Load())
+}
+
+func Test_BearerTokenRejectedCredentials(t *testing.T) {
+	r := newTokenRegistry(t)
+	r.Manifests["1.0.0"] = []byte(`{"schemaVersion":2}`)
+
+	client := r.client(t, "example/app:1.0.0", Credentials{Username: "user", Password: "wrong"})
+	_, _, err := client.GetManifest(context.Background(), "example/app", "1.0.0")
+	assert.ErrorContains(t, err, "failed to get registry token")
+	assert.ErrorContains(t, err, "401")
+}
+
+func Test_UnauthorizedAfterToken(t *testing.T) {
+	// The registry rejects the request even with the issued token, e.g. the token is missing the required scope
+	r := newTokenRegistry(t)
+	r.Manifests["1.0.0"] = []byte(`{"schemaVersion":2}`)
+	r.Token = "token-without-access"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			r.handle(w, req)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(strings.TrimPrefix(server.URL, "http://")+"/example/app:1.0.0", Credentials{Username: "user", Password: "secret"})
+	assert.NoError(t, err)
+	_, _, err = client.GetManifest(context.Background(), "example/app", "1.0.0")
+	assert.ErrorContains(t, err, "unexpected registry response")
+	assert.ErrorContains(t, err, "401")
+	assert.NotErrorIs(t, err, ErrNotFound)
+}
+
+func Test_BasicChallenge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		username, password, ok := req.BasicAuth()
+		if !ok || username != "user" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"schemaVersion":2}`))
+	}))
+	t.Cleanup(server.Close)
+	image := strings.TrimPrefix(server.URL, "http://") + "/example/app:1.0.0"
+
+	client, err := NewClient(image, Credentials{Username: "user", Password: "secret"})
+	assert.NoError(t, err)
+	_, _, err = client.GetManifest(context.Background(), "example/app", "1.0.0")
+	assert.NoError(t, err)
+
+	// Registries requiring basic auth can't be used without credentials
+	client, err = NewClient(image, Credentials{})
+	assert.NoError(t, err)
+	_, _, err = client.GetManifest(context.Background(), "example/app", "1.0.0")
+	assert.ErrorContains(t, err, "registry requires credentials")
+}
+
+func Test_IdentityTokenFlow(t *testing.T) {
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			assert.Equal(t, http.MethodPost, req.Method)
+			assert.NoError(t, req.ParseForm())
+			form = map[string]string{
+				"grant_type":    req.PostForm.Get("grant_type"),
+				"refresh_token": req.PostForm.Get("refresh_token"),
+				"scope":         req.PostForm.Get("scope"),
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "access"})
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer access" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake-registry"`, req.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"schemaVersion":2}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(strings.TrimPrefix(server.URL, "http://")+"/example/app:1.0.0", Credentials{IdentityToken: "refresh"})
+	assert.NoError(t, err)
+	_, _, err = client.GetManifest(context.Background(), "example/app", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": "refresh",
+		"scope":         "repository:example/app:pull",
+	}, form)
+}
+
+func Test_GetManifestDigestMismatch(t *testing.T) {
+	r := newTokenRegistry(t)
+	expected := digest.FromString("expected")
+	r.Manifests[expected.String()] = []byte(`{"schemaVersion":2}`)
+
+	client := r.client(t, "example/app", Credentials{Username: "user", Password: "secret"})
+	_, _, err := client.GetManifest(context.Background(), "example/app", expected.String())
+	assert.ErrorContains(t, err, "manifest digest mismatch")
+
+	_, _, err = client.GetManifest(context.Background(), "example/app", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_GetReferrers(t *testing.T) {
+	imageDigest := digest.FromString("image")
+
+	t.Run("referrers api", func(t *testing.T) {
+		r := newTokenRegistry(t)
+		r.Referrers = true
+		client := r.client(t, "example/app", Credentials{Username: "user", Password: "secret"})
+
+		referrers, err := client.GetReferrers(context.Background(), "example/app", imageDigest, testArtifactType)
+		assert.NoError(t, err)
+		assert.Len(t, referrers, 1)
+		assert.Equal(t, digest.FromString("signature"), referrers[0].Digest)
+
+		referrers, err = client.GetReferrers(context.Background(), "example/app", imageDigest, "")
+		assert.NoError(t, err)
+		assert.Len(t, referrers, 2)
+	})
+
+	t.Run("fallback to the referrers tag schema", func(t *testing.T) {
+		r := newTokenRegistry(t)
+		index, err := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{
+			{MediaType: ocispec.MediaTypeImageManifest, ArtifactType: testArtifactType, Digest: digest.FromString("signature")},
+		}})
+		assert.NoError(t, err)
+		r.Manifests[fmt.Sprintf("sha256-%s", imageDigest.Encoded())] = index
+		client := r.client(t, "example/app", Credentials{Username: "user", Password: "secret"})
+
+		referrers, err := client.GetReferrers(context.Background(), "example/app", imageDigest, testArtifactType)
+		assert.NoError(t, err)
+		assert.Len(t, referrers, 1)
+		assert.Equal(t, digest.FromString("signature"), referrers[0].Digest)
+	})
+
+	t.Run("no referrers", func(t *testing.T) {
+		r := newTokenRegistry(t)
+		// The cosign ".sig" tag is not a referrer, it is read separately by the signature verification
+		r.Manifests[fmt.Sprintf("sha256-%s.sig", imageDigest.Encoded())] = []byte(`{"schemaVersion":2}`)
+		client := r.client(t, "example/app", Credentials{Username: "user", Password: "secret"})
+
+		referrers, err := client.GetReferrers(context.Background(), "example/app", imageDigest, testArtifactType)
+		assert.NoError(t, err)
+		assert.Empty(t, referrers)
+
+		_, b, err := client.GetManifest(context.Background(), "example/app", fmt.Sprintf("sha256-%s.sig", imageDigest.Encoded()))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(`{"schemaVersion":2}`), b)
+	})
+}
@@ -0,0 +1,268 @@
+// Package signature verifies container image signatures created by sigstore/cosign
+// (using a key pair) either stored in a registry (OCI referrers or ".sig" tags)
+// or as detached signatures of image archives (cosign sign-blob).
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/thin-edge/tedge-container-plugin/pkg/registry"
+)
+
+const (
+	// Artifact type used by cosign when storing signatures as OCI 1.1 referrers
+	ArtifactTypeCosignSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// Media type of the cosign signature payload layers
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// Layer annotation containing the base64 encoded signature
+	AnnotationCosignSignature = "dev.cosignproject.cosign/signature"
+
+	simpleSigningType = "cosign container image signature"
+)
+
+// ErrNoSignature is returned when the image does not have any signatures
+var ErrNoSignature = errors.New("no signatures found")
+
+// ErrInvalidSignature is returned when the image has signatures, but none of them
+// can be verified by the trusted public keys
+var ErrInvalidSignature = errors.New("no valid signature found")
+
+// SimpleSigning is the payload signed by cosign
+type SimpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+// LoadPublicKeys reads PEM encoded public keys (or certificates) from the given files.
+// Each file can contain multiple PEM blocks.
+func LoadPublicKeys(paths ...string) ([]crypto.PublicKey, error) {
+	keys := make([]crypto.PublicKey, 0)
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		fileKeys, err := ParsePublicKeys(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key file. path=%s: %w", p, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
+// ParsePublicKeys parses PEM encoded public keys (or certificates)
+func ParsePublicKeys(b []byte) ([]crypto.PublicKey, error) {
+	keys := make([]crypto.PublicKey, 0)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case "RSA PUBLIC KEY":
+			key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, cert.PublicKey)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+	return keys, nil
+}
+
+// Verify a signature of a message with any of the public keys.
+// The ECDSA and RSA signatures use SHA256 (the cosign default).
+func Verify(keys []crypto.PublicKey, message []byte, sig []byte) error {
+	hash := sha256.Sum256(message)
+	for _, key := range keys {
+		if verifyHash(key, message, hash[:], sig) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func verifyHash(key crypto.PublicKey, message []byte, hash []byte, sig []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash, sig)
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, hash, sig) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(k, crypto.SHA256, hash, sig, nil) == nil
+	case ed25519.PublicKey:
+		return message != nil && ed25519.Verify(k, message, sig)
+	}
+	return false
+}
+
+// DecodeSignature decodes a signature which is either base64 encoded
+// (the cosign default) or in its raw form
+func DecodeSignature(b []byte) []byte {
+	if sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err == nil {
+		return sig
+	}
+	return b
+}
+
+// VerifyBlob verifies a detached signature (e.g. created by "cosign sign-blob") of a file,
+// e.g. an image archive. The file is streamed so that large archives are not read into memory
+// (unless an ed25519 key is used, as it signs the whole message)
+func VerifyBlob(keys []crypto.PublicKey, path string, sig []byte) error {
+	sig = DecodeSignature(sig)
+
+	needsMessage := false
+	for _, key := range keys {
+		if _, ok := key.(ed25519.PublicKey); ok {
+			needsMessage = true
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	h := sha256.New()
+	var message []byte
+	if needsMessage {
+		buf := &bytes.Buffer{}
+		if _, err := io.Copy(io.MultiWriter(h, buf), file); err != nil {
+			return err
+		}
+		message = buf.Bytes()
+	} else if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+
+	hash := h.Sum(nil)
+	for _, key := range keys {
+		if verifyHash(key, message, hash, sig) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// VerifyPayload checks the cosign signature of a simple signing payload, and
+// that the payload refers to the expected manifest digest
+func VerifyPayload(keys []crypto.PublicKey, payload []byte, sig []byte, manifestDigest digest.Digest) error {
+	if err := Verify(keys, payload, DecodeSignature(sig)); err != nil {
+		return err
+	}
+	signed := SimpleSigning{}
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("invalid signature payload. %w", err)
+	}
+	if signed.Critical.Type != simpleSigningType {
+		return fmt.Errorf("unexpected signature payload type. type=%s", signed.Critical.Type)
+	}
+	if signed.Critical.Image.DockerManifestDigest != manifestDigest.String() {
+		return fmt.Errorf("signature is for a different image. expected=%s, got=%s", manifestDigest, signed.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+// VerifyImage verifies the cosign signatures of an image manifest (identified by its digest) stored
+// in a registry. Signatures attached as OCI referrers and via the "sha256-<digest>.sig"
+// tag are supported. ErrNoSignature is returned if the image is not signed.
+func VerifyImage(ctx context.Context, client *registry.Client, repository string, manifestDigest digest.Digest, keys []crypto.PublicKey) error {
+	signatureManifests := make([]string, 0)
+
+	referrers, err := client.GetReferrers(ctx, repository, manifestDigest, ArtifactTypeCosignSignature)
+	if err != nil {
+		slog.Warn("Could not get image referrers.", "repository", repository, "digest", manifestDigest, "err", err)
+	}
+	for _, referrer := range referrers {
+		signatureManifests = append(signatureManifests, referrer.Digest.String())
+	}
+	signatureManifests = append(signatureManifests, fmt.Sprintf("%s-%s.sig", manifestDigest.Algorithm(), manifestDigest.Encoded()))
+
+	errs := make([]error, 0)
+	found := false
+	for _, ref := range signatureManifests {
+		_, b, err := client.GetManifest(ctx, repository, ref)
+		if err != nil {
+			if !errors.Is(err, registry.ErrNotFound) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		manifest := ocispec.Manifest{}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			sig, ok := layer.Annotations[AnnotationCosignSignature]
+			if layer.MediaType != MediaTypeSimpleSigning || !ok {
+				continue
+			}
+			found = true
+			payload, err := client.GetBlob(ctx, repository, layer.Digest)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := VerifyPayload(keys, payload, []byte(sig), manifestDigest); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			slog.Info("Verified image signature.", "repository", repository, "digest", manifestDigest, "signature", ref)
+			return nil
+		}
+	}
+
+	if !found {
+		if len(errs) > 0 {
+			return errors.Join(append([]error{ErrNoSignature}, errs...)...)
+		}
+		return ErrNoSignature
+	}
+	return errors.Join(append([]error{ErrInvalidSignature}, errs...)...)
+}
@@ -0,0 +1,176 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/thin-edge/tedge-container-plugin/pkg/registry"
+)
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, []crypto.PublicKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	keys, err := ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(t, err)
+	return key, keys
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, message []byte) string {
+	hash := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// fakeRegistry serves the given manifests (by tag or digest) and blobs
+func fakeRegistry(t *testing.T, manifests map[string][]byte, blobs map[digest.Digest][]byte, referrers bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/referrers/"):
+			if !referrers {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			index := ocispec.Index{Manifests: []ocispec.Descriptor{}}
+			for ref, b := range manifests {
+				if strings.HasPrefix(ref, "sha256:") {
+					index.Manifests = append(index.Manifests, ocispec.Descriptor{
+						MediaType:    ocispec.MediaTypeImageManifest,
+						ArtifactType: ArtifactTypeCosignSignature,
+						Digest:       digest.FromBytes(b),
+					})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(index)
+		case strings.Contains(r.URL.Path, "/manifests/"):
+			ref := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			b, ok := manifests[ref]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(b)
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			b, ok := blobs[digest.Digest(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func signatureManifest(t *testing.T, key *ecdsa.PrivateKey, imageDigest digest.Digest) ([]byte, digest.Digest, []byte) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"example/app"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, imageDigest))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType:   MediaTypeSimpleSigning,
+				Digest:      digest.FromBytes(payload),
+				Size:        int64(len(payload)),
+				Annotations: map[string]string{AnnotationCosignSignature: sign(t, key, payload)},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	return manifest, digest.FromBytes(payload), payload
+}
+
+func Test_VerifyImage(t *testing.T) {
+	key, keys := newKey(t)
+	_, otherKeys := newKey(t)
+	imageDigest := digest.FromString("image")
+
+	manifest, payloadDigest, payload := signatureManifest(t, key, imageDigest)
+	blobs := map[digest.Digest][]byte{payloadDigest: payload}
+
+	testcases := []struct {
+		Name      string
+		Manifests map[string][]byte
+		Referrers bool
+		Keys      []crypto.PublicKey
+		Expect    error
+	}{
+		{
+			Name:      "signature tag",
+			Manifests: map[string][]byte{fmt.Sprintf("sha256-%s.sig", imageDigest.Encoded()): manifest},
+			Keys:      keys,
+		},
+		{
+			Name:      "referrers",
+			Manifests: map[string][]byte{digest.FromBytes(manifest).String(): manifest},
+			Referrers: true,
+			Keys:      keys,
+		},
+		{
+			Name:      "untrusted key",
+			Manifests: map[string][]byte{fmt.Sprintf("sha256-%s.sig", imageDigest.Encoded()): manifest},
+			Keys:      otherKeys,
+			Expect:    ErrInvalidSignature,
+		},
+		{
+			Name:      "unsigned",
+			Manifests: map[string][]byte{},
+			Keys:      keys,
+			Expect:    ErrNoSignature,
+		},
+	}
+
+	for _, tc := range testcases {
+		server := fakeRegistry(t, tc.Manifests, blobs, tc.Referrers)
+		client, err := registry.NewClient(strings.TrimPrefix(server.URL, "http://")+"/example/app@"+imageDigest.String(), registry.Credentials{})
+		assert.NoError(t, err)
+
+		err = VerifyImage(context.Background(), client, "example/app", imageDigest, tc.Keys)
+		if tc.Expect == nil {
+			assert.NoError(t, err, tc.Name)
+		} else {
+			assert.True(t, errors.Is(err, tc.Expect), "%s: err=%v", tc.Name, err)
+		}
+		server.Close()
+	}
+
+	// The signature must be for the same image
+	server := fakeRegistry(t, map[string][]byte{fmt.Sprintf("sha256-%s.sig", digest.FromString("other").Encoded()): manifest}, blobs, false)
+	defer server.Close()
+	client, err := registry.NewClient(strings.TrimPrefix(server.URL, "http://")+"/example/app", registry.Credentials{})
+	assert.NoError(t, err)
+	err = VerifyImage(context.Background(), client, "example/app", digest.FromString("other"), keys)
+	assert.Error(t, err)
+}
+
+func Test_VerifyBlob(t *testing.T) {
+	key, keys := newKey(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "image.tar.gz")
+	contents := []byte("image archive contents")
+	assert.NoError(t, os.WriteFile(file, contents, 0644))
+
+	assert.NoError(t, VerifyBlob(keys, file, []byte(sign(t, key, contents))))
+	assert.ErrorIs(t, VerifyBlob(keys, file, []byte(sign(t, key, []byte("other")))), ErrInvalidSignature)
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -54,6 +55,14 @@ type Client struct {
 	TedgeAPI         *TedgeAPIClient
 
 	Entities map[string]any
+
+	// Additional topics to subscribe to (on each connect)
+	subscriptions   map[string]byte
+	subscriptionsMu sync.Mutex
+
+	// Functions called after each (re)connect
+	connectHandlers   []func()
+	connectHandlersMu sync.Mutex
 }
 
 func fileExists(filePath string) bool {
@@ -158,11 +167,20 @@ func NewClient(parent Target, target Target, serviceName string, config *ClientC
 	opts.SetResumeSubs(false)
 	opts.SetKeepAlive(60 * time.Second)
 
+	c := &Client{
+		ServiceName:   serviceName,
+		Parent:        parent,
+		Target:        target,
+		TedgeAPI:      NewTedgeAPIClient(useCerts, config),
+		Entities:      make(map[string]any),
+		subscriptions: make(map[string]byte),
+	}
+
 	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
 		slog.Info("MQTT Client is disconnected.", "err", err)
 	})
 
-	opts.SetOnConnectHandler(func(c mqtt.Client) {
+	opts.SetOnConnectHandler(func(mc mqtt.Client) {
 		slog.Info("MQTT Client is connected")
 
 		// Configure subscriptions
@@ -172,9 +190,15 @@ func NewClient(parent Target, target Target, serviceName string, config *ClientC
 		// Subscribe to service health status topics so bridge online/offline
 		// transitions can be detected and used to retry pending cloud operations.
 		subscriptions[target.RootPrefix+"/+/+/service/+/status/health"] = 1
+		c.subscriptionsMu.Lock()
+		for topic, qos := range c.subscriptions {
+			subscriptions[topic] = qos
+		}
+		c.subscriptionsMu.Unlock()
 		slog.Info("Subscribing to topics.", "topics", subscriptions)
-		tok := c.SubscribeMultiple(subscriptions, nil)
+		tok := mc.SubscribeMultiple(subscriptions, nil)
 		tok.Wait()
+		defer c.runConnectHandlers()
 
 		payload, err := PayloadHealthStatus(map[string]any{}, StatusUp)
 		if err != nil {
@@ -182,7 +206,7 @@ func NewClient(parent Target, target Target, serviceName string, config *ClientC
 		}
 		topic := GetHealthTopic(target)
 		slog.Info("Updating health topic.", "topic", topic)
-		tok = c.Publish(topic, 1, true, payload)
+		tok = mc.Publish(topic, 1, true, payload)
 		<-tok.Done()
 		if err := tok.Error(); err != nil {
 			slog.Warn("Failed to publish health message.", "err", err)
@@ -191,22 +215,47 @@ func NewClient(parent Target, target Target, serviceName string, config *ClientC
 		slog.Info("Published health message.", "topic", topic, "payload", payload)
 	})
 
-	client := mqtt.NewClient(opts)
-
-	c8yclient := CumulocityClientFromConfig(useCerts, config)
+	c.Client = mqtt.NewClient(opts)
+	c.CumulocityClient = CumulocityClientFromConfig(useCerts, config)
 	slog.Info("MQTT Client options.", "clientID", opts.ClientID)
 
-	c := &Client{
-		ServiceName:      serviceName,
-		Client:           client,
-		Parent:           parent,
-		Target:           target,
-		CumulocityClient: c8yclient,
-		TedgeAPI:         NewTedgeAPIClient(useCerts, config),
-		Entities:         make(map[string]any),
+	return c
+}
+
+// OnConnect registers a function which is called (in the background) whenever the client
+// has reconnected. Functions registered before the client is connected are also called
+// on the initial connect.
+func (c *Client) OnConnect(handler func()) {
+	c.connectHandlersMu.Lock()
+	defer c.connectHandlersMu.Unlock()
+	c.connectHandlers = append(c.connectHandlers, handler)
+}
+
+func (c *Client) runConnectHandlers() {
+	c.connectHandlersMu.Lock()
+	defer c.connectHandlersMu.Unlock()
+	for _, handler := range c.connectHandlers {
+		go handler()
 	}
+}
 
-	return c
+// Subscribe to an additional topic and register the handler for it.
+// The subscription is restored whenever the client reconnects. Topics can be
+// added before the client is connected.
+func (c *Client) Subscribe(topic string, qos byte, handler mqtt.MessageHandler) error {
+	c.subscriptionsMu.Lock()
+	c.subscriptions[topic] = qos
+	c.subscriptionsMu.Unlock()
+
+	c.Client.AddRoute(topic, handler)
+	if !c.Client.IsConnectionOpen() {
+		return nil
+	}
+	tok := c.Client.Subscribe(topic, qos, nil)
+	if !tok.WaitTimeout(30 * time.Second) {
+		return fmt.Errorf("timed out")
+	}
+	return tok.Error()
 }
 
 // Connect the MQTT client to the thin-edge.io broker
@@ -286,9 +335,15 @@ func (c *Client) GetEntities() (map[string]Entity, error) {
 	for _, v := range values {
 		resp, err := c.TedgeAPI.GetEntityTwin(context.Background(), Target{TopicID: v.TedgeTopicID})
 		if err == nil && resp.StatusCode() == 200 {
-			ev := &Entity{}
+			ev := &struct {
+				Type      string `json:"type"`
+				Container struct {
+					ContainerID string `json:"containerId"`
+				} `json:"container"`
+			}{}
 			if err := resp.Decode(&ev); err == nil {
 				v.Type = ev.Type
+				v.ContainerID = ev.Container.ContainerID
 			}
 			data[v.TedgeTopicID] = v
 		}
@@ -335,6 +390,10 @@ type Entity struct {
 	TedgeParentID string `json:"@parent,omitempty"`
 	Name          string `json:"name,omitempty"`
 	Type          string `json:"type,omitempty"`
+
+	// ContainerID is the id of the container (from the "container" twin fragment)
+	// which the service represents. Only set by GetEntities
+	ContainerID string `json:"-"`
 }
 
 type Responder func(*Response, error) (*Response, error)
@@ -466,6 +525,26 @@ func (c *TedgeAPIClient) GetEntityTwin(ctx context.Context, target Target, name
 	return c.Do(req, OkResponder(200))
 }
 
+// UploadFile uploads a file to the thin-edge.io file transfer service, and returns the url of the file
+func (c *TedgeAPIClient) UploadFile(ctx context.Context, path string, contentType string, body io.Reader) (string, *Response, error) {
+	reqURL := c.GetURL("te/v1/files", strings.TrimPrefix(path, "/"))
+	resp, err := c.UploadToURL(ctx, reqURL, contentType, body)
+	return reqURL, resp, err
+}
+
+// UploadToURL uploads a file to the given url of the thin-edge.io file transfer service, e.g.
+// the tedgeUrl of a command
+func (c *TedgeAPIClient) UploadToURL(ctx context.Context, reqURL string, contentType string, body io.Reader) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.Do(req, OkResponder(201, 204))
+}
+
 type Response struct {
 	RawResponse *http.Response
 	Body        []byte
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time window in local time, e.g. 02:00-04:00.
+// Windows can span midnight, e.g. 22:00-02:00.
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseTimeWindow parses a time window in the form "HH:MM-HH:MM".
+// An empty value returns a nil window, which means any time
+func ParseTimeWindow(v string) (*TimeWindow, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	startValue, endValue, ok := strings.Cut(v, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time window. expected HH:MM-HH:MM. got=%s", v)
+	}
+	start, err := parseTimeOfDay(startValue)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(endValue)
+	if err != nil {
+		return nil, err
+	}
+	return &TimeWindow{Start: start, End: end}, nil
+}
+
+func parseTimeOfDay(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day. expected HH:MM. got=%s", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains checks if the time is within the window. A nil window contains all times
+func (w *TimeWindow) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	// window spans midnight
+	return offset >= w.Start || offset < w.End
+}
+
+func (w *TimeWindow) String() string {
+	if w == nil {
+		return ""
+	}
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
@@ -47,6 +47,21 @@ func IsDirWritable(d string, perm fs.FileMode) (bool, error) {
 	return true, nil
 }
 
+// SamePath checks if two paths refer to the same location after cleaning them and
+// resolving any symlinks of their parent directories (the files themselves don't need to exist)
+func SamePath(a string, b string) bool {
+	return resolveDir(a) == resolveDir(b)
+}
+
+func resolveDir(p string) string {
+	p = filepath.Clean(p)
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return p
+	}
+	return filepath.Join(dir, filepath.Base(p))
+}
+
 func RootDir(p string) string {
 	for {
 		v1 := filepath.Dir(p)
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func Test_RootDir(t *testing.T) {
@@ -39,3 +42,63 @@ func Test_RootDir(t *testing.T) {
 
 	}
 }
+
+func Test_TimeWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	test_cases := []struct {
+		Window   string
+		Time     time.Time
+		Expected bool
+	}{
+		{Window: "02:00-04:00", Time: at(3, 0), Expected: true},
+		{Window: "02:00-04:00", Time: at(4, 0), Expected: false},
+		{Window: "02:00-04:00", Time: at(1, 59), Expected: false},
+		{Window: "22:00-02:00", Time: at(23, 0), Expected: true},
+		{Window: "22:00-02:00", Time: at(1, 0), Expected: true},
+		{Window: "22:00-02:00", Time: at(12, 0), Expected: false},
+		{Window: "", Time: at(12, 0), Expected: true},
+	}
+	for _, c := range test_cases {
+		window, err := ParseTimeWindow(c.Window)
+		if err != nil {
+			t.Fatalf("Unexpected error. %v", err)
+		}
+		if got := window.Contains(c.Time); got != c.Expected {
+			t.Errorf("Invalid window check. window=%s, time=%s, got=%v, wanted=%v", c.Window, c.Time, got, c.Expected)
+		}
+	}
+
+	for _, v := range []string{"02:00", "2am-4am", "25:00-26:00"} {
+		if _, err := ParseTimeWindow(v); err == nil {
+			t.Errorf("Expected an error. window=%s", v)
+		}
+	}
+}
+
+func Test_SamePath(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	test_cases := []struct {
+		A        string
+		B        string
+		Expected bool
+	}{
+		{A: "/etc/tedge-container-plugin/registries.toml", B: "/etc/tedge-container-plugin/registries.toml", Expected: true},
+		{A: "/etc/tedge-container-plugin/../tedge-container-plugin/registries.toml", B: "/etc/tedge-container-plugin/registries.toml", Expected: true},
+		{A: "/etc/tedge-container-plugin//registries.toml", B: "/etc/tedge-container-plugin/registries.toml", Expected: true},
+		{A: "/etc/tedge/tedge.toml", B: "/etc/tedge-container-plugin/registries.toml", Expected: false},
+		{A: "/etc/tedge-container-plugin/../tedge/tedge.toml", B: "/etc/tedge-container-plugin/registries.toml", Expected: false},
+		{A: filepath.Join(link, "registries.toml"), B: filepath.Join(dir, "registries.toml"), Expected: true},
+		{A: filepath.Join(link, "other.toml"), B: filepath.Join(dir, "registries.toml"), Expected: false},
+	}
+	for _, tc := range test_cases {
+		if got := SamePath(tc.A, tc.B); got != tc.Expected {
+			t.Errorf("SamePath(%q, %q) = %v, want %v", tc.A, tc.B, got, tc.Expected)
+		}
+	}
+}